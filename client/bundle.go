@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"discon-wrapper/shared/utils"
+)
+
+// SendBundle places the files in the session directory at their names, which
+// are relative slash-separated paths. The entries describe the files as sent,
+// with the hashes the server looks them up in its cache by and the transforms
+// applied by the client, which the server records. Only the files the server
+// hasn't cached are archived and sent. The number of files sent is returned.
+func (s *Session) SendBundle(ctx context.Context, files []utils.BundleFile, entries []utils.FileEntry) (int, error) {
+	if len(files) != len(entries) {
+		return 0, fmt.Errorf("bundle of %d files has %d entries", len(files), len(entries))
+	}
+
+	send := files
+	if !s.opts.NoCache {
+		wanted, err := s.Missing(ctx, entries)
+		if err != nil {
+			return 0, err
+		}
+		send = nil
+		var sentEntries []utils.FileEntry
+		for i, file := range files {
+			if wanted[entries[i]] {
+				send = append(send, file)
+				sentEntries = append(sentEntries, entries[i])
+			}
+		}
+		entries = sentEntries
+		s.debug("%d of %d bundle files were cached on the server", len(files)-len(send), len(files))
+	}
+	if len(send) == 0 {
+		return 0, nil
+	}
+
+	msg := &utils.ControlMessage{Type: utils.ControlBundle, Entries: transformedEntries(entries)}
+	content, err := s.uploadBundle(ctx, send, msg)
+	if err != nil {
+		return 0, err
+	}
+	if _, _, err := s.Control(ctx, msg, content); err != nil {
+		return 0, err
+	}
+	return len(send), nil
+}
+
+// uploadBundle writes the archive of the files to a temporary file. Small
+// archives are returned to be sent with the bundle message, large archives are
+// uploaded in chunks from the file and unpacked from the session directory,
+// which is set as the path of the message.
+func (s *Session) uploadBundle(ctx context.Context, files []utils.BundleFile, msg *utils.ControlMessage) ([]byte, error) {
+	archive, err := os.CreateTemp("", "discon-bundle-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	if err := utils.WriteBundle(io.MultiWriter(archive, hash), files); err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	s.debug("Sending bundle of %d files (size: %d bytes)", len(files), size)
+
+	if size <= s.opts.ChunkSize {
+		content := make([]byte, size)
+		if _, err := archive.ReadAt(content, 0); err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		return content, nil
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	entry := utils.FileEntry{Path: ".bundle-" + sum[:8] + ".tar", Hash: sum, Size: size}
+	if err := s.PutFileFrom(ctx, entry, archive, true); err != nil {
+		return nil, fmt.Errorf("failed to upload bundle: %w", err)
+	}
+	msg.Path = entry.Path
+	return nil, nil
+}
+
+// transformedEntries returns the entries of the bundled files which were
+// transformed, which the server records
+func transformedEntries(entries []utils.FileEntry) []utils.FileEntry {
+	var transformed []utils.FileEntry
+	for _, entry := range entries {
+		if entry.Transforms != "" {
+			transformed = append(transformed, entry)
+		}
+	}
+	return transformed
+}
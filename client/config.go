@@ -0,0 +1,557 @@
+// Package client resolves the discon-client configuration from the
+// configuration file profiles and the DISCON_* environment variables, so that
+// tools see exactly the settings the client library uses, and provides the
+// Session the client library and tools call controllers on a server with
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+
+	"github.com/spf13/viper"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Modes of calling the controller
+const (
+	ModeRemote = "remote" // Calls are forwarded to a discon-server
+	ModeDirect = "direct" // The controller library is called in-process
+)
+
+// Names of the configuration files searched for next to the client library
+var ConfigFileNames = []string{"discon-client.yaml", "discon-client.yml", "discon-client.json"}
+
+// Name of the profile used when none is selected explicitly
+const defaultProfileName = "default"
+
+// ConfigFile represents the structure of a discon-client configuration file
+type ConfigFile struct {
+	DefaultProfile string             `mapstructure:"default_profile"`
+	Profiles       map[string]*Config `mapstructure:"profiles"`
+}
+
+// Config represents the settings of a single client profile
+type Config struct {
+	ServerAddr        string            `mapstructure:"server_addr"`
+	LibPath           string            `mapstructure:"lib_path"`
+	LibProc           string            `mapstructure:"lib_proc"`
+	ControllerID      string            `mapstructure:"controller_id"`      // Controller ID on a discon-manager
+	ControllerVersion string            `mapstructure:"controller_version"` // Controller version on a discon-manager
+	Mode              string            `mapstructure:"mode"`               // 'remote' (default) or 'direct' to call lib_path in-process
+	FallbackLib       string            `mapstructure:"fallback_lib"`       // Local library called in-process if the server is unreachable
+	Match             []string          `mapstructure:"match"`              // Input file globs that select this profile
+	AdditionalFiles   []string          `mapstructure:"additional_files"`
+	PathMap           []PathMapping     `mapstructure:"path_map"`   // Local path prefixes the server reaches under other roots
+	Transforms        []TransformConfig `mapstructure:"transforms"` // Text conversions applied to matching files
+	Env               EnvConfig         `mapstructure:"env"`
+	Discovery         DiscoveryConfig   `mapstructure:"discovery"`
+	Bundle            BundleConfig      `mapstructure:"bundle"`
+	Cache             CacheConfig       `mapstructure:"cache"`
+	Upload            UploadConfig      `mapstructure:"upload"`
+	Output            OutputConfig      `mapstructure:"output"`
+	TLS               utils.TLSConfig   `mapstructure:"tls"`
+	Timeouts          TimeoutConfig     `mapstructure:"timeouts"`
+	Logging           LoggingConfig     `mapstructure:"logging"`
+
+	// Name of the profile and file the settings were loaded from (not part of the file)
+	ProfileName string `mapstructure:"-"`
+	SourceFile  string `mapstructure:"-"`
+}
+
+// TimeoutConfig represents the connection and transfer timeouts
+type TimeoutConfig struct {
+	Connect        time.Duration `mapstructure:"connect"`
+	ConnectRetries int           `mapstructure:"connect_retries"`
+	Transfer       time.Duration `mapstructure:"transfer"`
+	Call           time.Duration `mapstructure:"call"`      // Time to wait for the controller to respond
+	SlowCall       time.Duration `mapstructure:"slow_call"` // Calls taking longer are logged
+}
+
+// BundleConfig represents the settings for sending a directory tree to the server
+type BundleConfig struct {
+	Root    string   `mapstructure:"root"`    // Directory sent with its relative paths preserved
+	Include []string `mapstructure:"include"` // Globs of files to send, all files if empty
+	Exclude []string `mapstructure:"exclude"` // Globs of files to leave out
+}
+
+// CacheConfig represents the settings for reusing files cached on the server
+type CacheConfig struct {
+	Disabled bool `mapstructure:"disabled"` // Upload every file with the original file transfer
+}
+
+// UploadConfig represents the settings for uploading files in chunks
+type UploadConfig struct {
+	ChunkSize int64 `mapstructure:"chunk_size"` // Size of each chunk in bytes
+	Retries   *int  `mapstructure:"retries"`    // Reconnections to resume the uploads after the connection is lost, nil if unset
+}
+
+// RetryLimit returns the number of reconnections to resume the uploads, 0 turns them off
+func (u UploadConfig) RetryLimit() int {
+	if u.Retries == nil {
+		return 0
+	}
+	return max(*u.Retries, 0)
+}
+
+// OutputConfig represents the settings for retrieving files written by the controller
+type OutputConfig struct {
+	Dir     string   `mapstructure:"dir"`     // Local directory output files are downloaded into
+	Include []string `mapstructure:"include"` // Globs of files to download, all files if empty
+	Exclude []string `mapstructure:"exclude"` // Globs of files to leave on the server
+}
+
+// PathMapping maps a local path prefix to the prefix the server reaches the
+// same files under, e.g. a network share mounted on both machines
+type PathMapping struct {
+	From string `mapstructure:"from"` // Local prefix, e.g. 'Z:\cases'
+	To   string `mapstructure:"to"`   // Server prefix, e.g. '/mnt/cases'
+}
+
+// TransformConfig represents the conversions applied to the text files
+// matching its globs before they are transferred
+type TransformConfig struct {
+	Match       []string `mapstructure:"match"`        // Globs of the files the rule applies to
+	Charset     string   `mapstructure:"charset"`      // Declared charset converted to UTF-8, e.g. 'windows-1252'
+	StripBOM    bool     `mapstructure:"strip_bom"`    // Remove a UTF-8 byte order mark
+	LineEndings string   `mapstructure:"line_endings"` // Convert line endings to 'lf' or 'crlf'
+}
+
+// EnvConfig represents the environment variables forwarded to the controller,
+// in addition to the DISCON_ENV_ prefixed ones
+type EnvConfig struct {
+	Forward []string `mapstructure:"forward"` // Names or globs of variables forwarded unchanged
+}
+
+// DiscoveryConfig represents the settings for discovering files referenced by the input file
+type DiscoveryConfig struct {
+	Disabled bool `mapstructure:"disabled"`
+	MaxDepth int  `mapstructure:"max_depth"` // Maximum depth of nested references
+	DryRun   bool `mapstructure:"dry_run"`   // Report the files that would be sent and stop
+}
+
+// LoggingConfig represents the client logging settings
+type LoggingConfig struct {
+	Level     int    `mapstructure:"level"`
+	File      string `mapstructure:"file"`
+	Trace     string `mapstructure:"trace"`      // Trace file of the controller calls, see discon-trace
+	StatsFile string `mapstructure:"stats_file"` // JSON file the latency and throughput summary is written to
+
+	// Where the output the controller prints on the server goes: "console"
+	// (default), "off" or the path of a log file
+	ControllerOutput string `mapstructure:"controller_output"`
+}
+
+// NewDefaultConfig returns a profile with the built-in defaults applied
+func NewDefaultConfig() *Config {
+	retries := 3 // Unless a profile sets them, 0 included
+	return &Config{
+		ProfileName: defaultProfileName,
+		Timeouts: TimeoutConfig{
+			Connect:        45 * time.Second,
+			ConnectRetries: 5,
+			Transfer:       5 * time.Second,
+			Call:           60 * time.Second,
+			SlowCall:       time.Second,
+		},
+		Upload: UploadConfig{
+			ChunkSize: 1024 * 1024,
+			Retries:   &retries,
+		},
+	}
+}
+
+// FindConfigFile returns the configuration file named by DISCON_CONFIG or,
+// if unset, the first configuration file found in the directory of the client
+// library. An empty path is returned if no configuration file is in use.
+func FindConfigFile(libDir string) (string, error) {
+	if path, found := os.LookupEnv("DISCON_CONFIG"); found && path != "" {
+		if !utils.FileExists(path) {
+			return "", fmt.Errorf("configuration file named by DISCON_CONFIG does not exist: %s", path)
+		}
+		return path, nil
+	}
+
+	if libDir == "" {
+		return "", nil
+	}
+	for _, name := range ConfigFileNames {
+		path := filepath.Join(libDir, name)
+		if utils.FileExists(path) {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// LoadConfigFile reads the profiles from a YAML or JSON configuration file
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	configFile := &ConfigFile{}
+	if err := v.Unmarshal(configFile); err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+
+	return configFile, nil
+}
+
+// SelectProfile returns the profile to use for this client instance using the following priority:
+// 1. The profile named by DISCON_PROFILE
+// 2. The first profile in alphabetical order whose match globs match the controller input file
+// 3. The profile named by default_profile
+// 4. The profile named "default"
+func (cf *ConfigFile) SelectProfile(name, inFile string) (*Config, error) {
+	// Profile names are case-insensitive because viper lowercases map keys
+	if name != "" {
+		profile, ok := cf.Profiles[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("profile not found: %s", name)
+		}
+		return cf.withDefaults(strings.ToLower(name), profile), nil
+	}
+
+	if inFile != "" {
+		// Map order is random, the names are sorted so that the choice is stable
+		names := make([]string, 0, len(cf.Profiles))
+		for profileName := range cf.Profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		for _, profileName := range names {
+			if profile := cf.Profiles[profileName]; profile != nil && matchesAny(profile.Match, inFile) {
+				return cf.withDefaults(profileName, profile), nil
+			}
+		}
+	}
+
+	if cf.DefaultProfile != "" {
+		profile, ok := cf.Profiles[strings.ToLower(cf.DefaultProfile)]
+		if !ok {
+			return nil, fmt.Errorf("default profile not found: %s", cf.DefaultProfile)
+		}
+		return cf.withDefaults(strings.ToLower(cf.DefaultProfile), profile), nil
+	}
+
+	if profile, ok := cf.Profiles[defaultProfileName]; ok {
+		return cf.withDefaults(defaultProfileName, profile), nil
+	}
+
+	// Only a single profile, use it regardless of its name
+	if len(cf.Profiles) == 1 {
+		for profileName, profile := range cf.Profiles {
+			return cf.withDefaults(profileName, profile), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no profile selected, set DISCON_PROFILE or default_profile")
+}
+
+// withDefaults fills the unset fields of a profile with the built-in defaults
+func (cf *ConfigFile) withDefaults(name string, profile *Config) *Config {
+	config := NewDefaultConfig()
+	if profile == nil {
+		config.ProfileName = name
+		return config
+	}
+
+	merged := *profile
+	merged.ProfileName = name
+	if merged.Timeouts.Connect == 0 {
+		merged.Timeouts.Connect = config.Timeouts.Connect
+	}
+	if merged.Timeouts.ConnectRetries == 0 {
+		merged.Timeouts.ConnectRetries = config.Timeouts.ConnectRetries
+	}
+	if merged.Timeouts.Transfer == 0 {
+		merged.Timeouts.Transfer = config.Timeouts.Transfer
+	}
+	if merged.Timeouts.Call == 0 {
+		merged.Timeouts.Call = config.Timeouts.Call
+	}
+	if merged.Timeouts.SlowCall == 0 {
+		merged.Timeouts.SlowCall = config.Timeouts.SlowCall
+	}
+	if merged.Upload.ChunkSize == 0 {
+		merged.Upload.ChunkSize = config.Upload.ChunkSize
+	}
+	if merged.Upload.Retries == nil {
+		merged.Upload.Retries = config.Upload.Retries
+	}
+	return &merged
+}
+
+// matchesAny reports whether the path or its base name matches any of the globs
+func matchesAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyEnvOverrides overrides profile settings with any DISCON_* environment
+// variables that are set. It returns an error naming the first variable whose
+// value can't be parsed.
+func (c *Config) ApplyEnvOverrides() error {
+	if value, found := os.LookupEnv("DISCON_SERVER_ADDR"); found {
+		c.ServerAddr = value
+	}
+	if value, found := os.LookupEnv("DISCON_LIB_PATH"); found {
+		c.LibPath = value
+	}
+	if value, found := os.LookupEnv("DISCON_LIB_PROC"); found {
+		c.LibProc = value
+	}
+	if value, found := os.LookupEnv("DISCON_CONTROLLER_ID"); found {
+		c.ControllerID = value
+	}
+	if value, found := os.LookupEnv("DISCON_CONTROLLER_VERSION"); found {
+		c.ControllerVersion = value
+	}
+	if value, found := os.LookupEnv("DISCON_MODE"); found {
+		c.Mode = strings.ToLower(strings.TrimSpace(value))
+	}
+	if value, found := os.LookupEnv("DISCON_FALLBACK_LIB"); found {
+		c.FallbackLib = value
+	}
+	if value, found := os.LookupEnv("DISCON_CLIENT_DEBUG"); found {
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			// If not a number, treat as the trace file name and set debug level to 1
+			c.Logging.Trace = value + trace.FileExt
+			level = 1
+		}
+		c.Logging.Level = level
+	}
+	if value, found := os.LookupEnv("DISCON_TRACE"); found {
+		c.Logging.Trace = value
+	}
+	if value, found := os.LookupEnv("DISCON_STATS_FILE"); found {
+		c.Logging.StatsFile = value
+	}
+	if value, found := os.LookupEnv("DISCON_CONTROLLER_OUTPUT"); found {
+		c.Logging.ControllerOutput = value
+	}
+	if value, found := lookupValue("DISCON_DISCOVER_FILES"); found {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return envError("DISCON_DISCOVER_FILES", value, "a boolean such as 1 or 0")
+		}
+		c.Discovery.Disabled = !enabled
+	}
+	if value, found := lookupValue("DISCON_DRY_RUN"); found {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return envError("DISCON_DRY_RUN", value, "a boolean such as 1 or 0")
+		}
+		c.Discovery.DryRun = dryRun
+	}
+	if value, found := os.LookupEnv("DISCON_ADDITIONAL_FILES"); found {
+		c.AdditionalFiles = splitList(value)
+	}
+	if value, found := os.LookupEnv("DISCON_PATH_MAP"); found {
+		c.PathMap = nil
+		for _, rule := range splitList(value) {
+			from, to, _ := strings.Cut(rule, "=")
+			c.PathMap = append(c.PathMap, PathMapping{From: strings.TrimSpace(from), To: strings.TrimSpace(to)})
+		}
+	}
+	if value, found := lookupValue("DISCON_CALL_TIMEOUT"); found {
+		timeout, err := parseDuration(value)
+		if err != nil {
+			return envError("DISCON_CALL_TIMEOUT", value, "seconds or a duration such as 500ms")
+		}
+		c.Timeouts.Call = timeout
+	}
+	if value, found := lookupValue("DISCON_SLOW_CALL"); found {
+		threshold, err := parseDuration(value)
+		if err != nil {
+			return envError("DISCON_SLOW_CALL", value, "seconds or a duration such as 500ms")
+		}
+		c.Timeouts.SlowCall = threshold
+	}
+	if value, found := os.LookupEnv("DISCON_FORWARD_ENV"); found {
+		c.Env.Forward = splitList(value)
+	}
+	if value, found := lookupValue("DISCON_CACHE"); found {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return envError("DISCON_CACHE", value, "a boolean such as 1 or 0")
+		}
+		c.Cache.Disabled = !enabled
+	}
+	if value, found := lookupValue("DISCON_CHUNK_SIZE"); found {
+		chunkSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return envError("DISCON_CHUNK_SIZE", value, "a number of bytes")
+		}
+		c.Upload.ChunkSize = chunkSize
+	}
+	if value, found := os.LookupEnv("DISCON_OUTPUT_DIR"); found {
+		c.Output.Dir = value
+	}
+	if value, found := os.LookupEnv("DISCON_OUTPUT_INCLUDE"); found {
+		c.Output.Include = splitList(value)
+	}
+	if value, found := os.LookupEnv("DISCON_OUTPUT_EXCLUDE"); found {
+		c.Output.Exclude = splitList(value)
+	}
+	if value, found := os.LookupEnv("DISCON_BUNDLE_ROOT"); found {
+		c.Bundle.Root = value
+	}
+	if value, found := os.LookupEnv("DISCON_BUNDLE_INCLUDE"); found {
+		c.Bundle.Include = splitList(value)
+	}
+	if value, found := os.LookupEnv("DISCON_BUNDLE_EXCLUDE"); found {
+		c.Bundle.Exclude = splitList(value)
+	}
+	return nil
+}
+
+// lookupValue returns the value of a variable which is parsed, an empty
+// value counts as not set
+func lookupValue(name string) (string, bool) {
+	value := strings.TrimSpace(os.Getenv(name))
+	return value, value != ""
+}
+
+// envError returns the configuration error of a variable whose value can't be parsed
+func envError(name, value, expected string) error {
+	return fmt.Errorf("invalid value %q of %s, expected %s", value, name, expected)
+}
+
+// parseDuration parses a duration given in seconds or with a unit, e.g. "30" or "500ms"
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// splitList splits a semicolon-separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Validate checks that all required settings are present
+func (c *Config) Validate() error {
+	if c.Mode != "" && c.Mode != ModeRemote && c.Mode != ModeDirect {
+		return fmt.Errorf("mode must be '%s' or '%s', got %q (DISCON_MODE or mode)", ModeRemote, ModeDirect, c.Mode)
+	}
+	// In direct mode the controller is loaded in-process without a server
+	direct := c.Mode == ModeDirect
+	if c.ServerAddr == "" && !direct {
+		return fmt.Errorf("server address not set (DISCON_SERVER_ADDR or server_addr, e.g. 'localhost:8080' or 'https://controller.domain.com')")
+	}
+	// A discon-manager can select the controller by ID or version, in which
+	// case the library path and procedure default to the registered ones
+	managerSelection := !direct && (c.ControllerID != "" || c.ControllerVersion != "")
+	if c.LibPath == "" && !managerSelection {
+		return fmt.Errorf("controller library path not set (DISCON_LIB_PATH or lib_path, e.g. 'discon.dll')")
+	}
+	if c.LibProc == "" && !managerSelection {
+		return fmt.Errorf("controller procedure not set (DISCON_LIB_PROC or lib_proc, e.g. 'discon')")
+	}
+	if c.FallbackLib != "" && c.LibProc == "" {
+		return fmt.Errorf("fallback library needs the controller procedure (DISCON_LIB_PROC or lib_proc)")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	for _, mapping := range c.PathMap {
+		if mapping.From == "" || mapping.To == "" {
+			return fmt.Errorf("path mapping %q=%q must have both prefixes (DISCON_PATH_MAP or path_map, e.g. 'Z:\\cases=/mnt/cases')", mapping.From, mapping.To)
+		}
+	}
+	if c.Timeouts.Call <= 0 {
+		return fmt.Errorf("call timeout must be positive (DISCON_CALL_TIMEOUT or timeouts.call, e.g. '60s')")
+	}
+	for _, pattern := range c.Env.Forward {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid environment variable glob %q (DISCON_FORWARD_ENV or env.forward)", pattern)
+		}
+	}
+	for _, transform := range c.Transforms {
+		if len(transform.Match) == 0 {
+			return fmt.Errorf("transform must match at least one glob (transforms.match)")
+		}
+		if transform.LineEndings != "" && transform.LineEndings != "lf" && transform.LineEndings != "crlf" {
+			return fmt.Errorf("transform line endings must be 'lf' or 'crlf', got %q", transform.LineEndings)
+		}
+		if transform.Charset != "" {
+			if encoding, err := ianaindex.IANA.Encoding(transform.Charset); err != nil || encoding == nil {
+				return fmt.Errorf("transform charset %q is not supported", transform.Charset)
+			}
+		}
+	}
+	if c.Upload.ChunkSize <= 0 || c.Upload.ChunkSize > utils.MaxChunkSize {
+		return fmt.Errorf("upload chunk size must be between 1 and %d bytes (DISCON_CHUNK_SIZE or upload.chunk_size)", utils.MaxChunkSize)
+	}
+	return nil
+}
+
+// TLSClientConfig builds the TLS configuration for secure WebSocket connections
+func (c *Config) TLSClientConfig() (*tls.Config, error) {
+	return c.TLS.ClientConfig()
+}
+
+// ResolveConfig determines the configuration of a client from the
+// configuration file (if any) and the environment variables. The profile is
+// selected with the controller input file, and libDir is the directory of the
+// client library configuration files are searched in.
+func ResolveConfig(inFile, libDir string) (*Config, error) {
+	config := NewDefaultConfig()
+
+	path, err := FindConfigFile(libDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		configFile, err := LoadConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config, err = configFile.SelectProfile(os.Getenv("DISCON_PROFILE"), inFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config.SourceFile = path
+	}
+
+	// Environment variables always take precedence over the configuration file
+	if err := config.ApplyEnvOverrides(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectProfileByMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discon-client.yaml")
+	content := `profiles:
+  turbine-b:
+    server_addr: b:8080
+    match: ["*.IN"]
+  turbine-a:
+    server_addr: a:8080
+    match: ["*T2*"]
+  other:
+    server_addr: other:8080
+    match: ["*.dat"]
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both turbine profiles match, the first in alphabetical order is selected every time
+	for i := 0; i < 20; i++ {
+		config, err := configFile.SelectProfile("", "/cases/T2_DISCON.IN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.ProfileName != "turbine-a" {
+			t.Fatalf("Expected profile turbine-a, got %s", config.ProfileName)
+		}
+	}
+
+	config, err := configFile.SelectProfile("", "/cases/T1/DISCON.IN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ProfileName != "turbine-b" || config.ServerAddr != "b:8080" {
+		t.Errorf("Expected profile turbine-b, got %s", config.ProfileName)
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discon-client.yaml")
+	content := `profiles:
+  unset:
+    server_addr: a:8080
+  off:
+    server_addr: b:8080
+    upload:
+      retries: 0
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries set to 0 turn them off rather than selecting the default
+	for profile, expected := range map[string]int{"unset": 3, "off": 0} {
+		config, err := configFile.SelectProfile(profile, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retries := config.Upload.RetryLimit(); retries != expected {
+			t.Errorf("Expected %d retries for profile %s, got %d", expected, profile, retries)
+		}
+	}
+}
+
+func TestApplyEnvOverridesErrors(t *testing.T) {
+	for name, value := range map[string]string{
+		"DISCON_CALL_TIMEOUT":   "1 minute",
+		"DISCON_SLOW_CALL":      "fast",
+		"DISCON_CHUNK_SIZE":     "1MB",
+		"DISCON_CACHE":          "maybe",
+		"DISCON_DISCOVER_FILES": "enabled",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			err := NewDefaultConfig().ApplyEnvOverrides()
+			if err == nil || !strings.Contains(err.Error(), name) || !strings.Contains(err.Error(), value) {
+				t.Errorf("Expected an error naming %s and %q, got %v", name, value, err)
+			}
+		})
+	}
+
+	// Valid and empty values are applied or ignored
+	t.Setenv("DISCON_CALL_TIMEOUT", "500ms")
+	t.Setenv("DISCON_CHUNK_SIZE", "4096")
+	t.Setenv("DISCON_CACHE", "0")
+	t.Setenv("DISCON_SLOW_CALL", "")
+	config := NewDefaultConfig()
+	if err := config.ApplyEnvOverrides(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Timeouts.Call != 500*time.Millisecond || config.Upload.ChunkSize != 4096 || !config.Cache.Disabled {
+		t.Errorf("Unexpected configuration %+v", config)
+	}
+}
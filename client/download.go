@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Outputs returns the entries of the files the controller created or modified
+// in the session directory
+func (s *Session) Outputs(ctx context.Context) ([]utils.FileEntry, error) {
+	response, _, err := s.Control(ctx, &utils.ControlMessage{Type: utils.ControlOutputs}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Entries, nil
+}
+
+// RetrieveOutputs downloads the output files into the directory, keeping their
+// paths relative to the session directory. Only files which match one of the
+// include globs, if any are given, and none of the exclude globs are
+// downloaded. The number of files downloaded is returned.
+func (s *Session) RetrieveOutputs(ctx context.Context, dir string, include, exclude []string) (int, error) {
+	entries, err := s.Outputs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return 0, fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if len(include) > 0 && !utils.MatchAnyGlob(include, entry.Path) {
+			s.verbose("Skipping output file %s, not included", entry.Path)
+			continue
+		}
+		if utils.MatchAnyGlob(exclude, entry.Path) {
+			s.verbose("Skipping output file %s, excluded", entry.Path)
+			continue
+		}
+
+		// The server names the files, so they are checked like bundle entries
+		target, err := utils.BundleTarget(root, entry.Path)
+		if err != nil {
+			return count, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return count, err
+		}
+
+		if _, err := s.DownloadFile(ctx, entry.Path, target); err != nil {
+			return count, fmt.Errorf("failed to download %s: %w", entry.Path, err)
+		}
+		s.debug("Retrieved output file %s (size: %d bytes)", target, entry.Size)
+		count++
+	}
+
+	s.debug("Retrieved %d of %d output files into %s", count, len(entries), root)
+	return count, nil
+}
+
+// DownloadFile downloads a file from the session directory in chunks, verifying
+// each chunk and the complete file before replacing target. The hash of the
+// file is returned.
+func (s *Session) DownloadFile(ctx context.Context, name, target string) (string, error) {
+	partial := target + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(partial)
+	defer file.Close()
+
+	hash := sha256.New()
+	offset := int64(0)
+	lastProgress := int64(-1)
+	for {
+		msg := &utils.ControlMessage{
+			Type:    utils.ControlGet,
+			Entries: []utils.FileEntry{{Path: name}},
+			Offset:  offset,
+			Length:  s.opts.ChunkSize,
+		}
+		response, chunk, err := s.Control(ctx, msg, nil)
+		if err != nil {
+			return "", err
+		}
+		if len(response.Entries) != 1 || response.Offset != offset {
+			return "", fmt.Errorf("unexpected response for the chunk at offset %d", offset)
+		}
+		result := response.Entries[0]
+		if result.Hash != "" && len(result.Hash) != 64 || result.Size < 0 {
+			return "", fmt.Errorf("malformed response for the chunk at offset %d (hash: %q, size: %d)", offset, result.Hash, result.Size)
+		}
+		if utils.ComputeFileHash(chunk) != response.ChunkHash {
+			return "", fmt.Errorf("chunk at offset %d does not match its hash", offset)
+		}
+
+		if _, err := file.Write(chunk); err != nil {
+			return "", err
+		}
+		hash.Write(chunk)
+		offset += int64(len(chunk))
+
+		if result.Hash != "" {
+			if actual := hex.EncodeToString(hash.Sum(nil)); actual != result.Hash {
+				return "", fmt.Errorf("content hash %s does not match %s", actual[:8], result.Hash[:8])
+			}
+			break
+		}
+		if len(chunk) == 0 {
+			return "", fmt.Errorf("file shrank to %d bytes while downloading", offset)
+		}
+		if offset > result.Size {
+			return "", fmt.Errorf("malformed response, received %d bytes of a file of %d bytes", offset, result.Size)
+		}
+
+		// Report progress every 10% for files received in several chunks
+		if progress := offset * 10 / result.Size; progress != lastProgress {
+			s.debug("Downloading %s: %d of %d bytes (%d%%)", name, offset, result.Size, offset*100/result.Size)
+			lastProgress = progress
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), os.Rename(partial, target)
+}
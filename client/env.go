@@ -0,0 +1,49 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Prefix of the variables forwarded to the controller with the prefix removed,
+// e.g. DISCON_ENV_ROSCO_DEBUG is set as ROSCO_DEBUG for the controller
+const EnvForwardPrefix = "DISCON_ENV_"
+
+// ForwardedEnv returns the NAME=value pairs of the variables forwarded to the
+// controller: those with the forward prefix, and those matching the forward
+// globs unless a prefixed variable of the same name is set. The names of the
+// variables which may not be forwarded are returned separately.
+func (c *Config) ForwardedEnv() (env []string, blocked []string) {
+	forwarded := make(map[string]string)
+	for _, pair := range os.Environ() {
+		name, value, _ := strings.Cut(pair, "=")
+		if strings.HasPrefix(name, EnvForwardPrefix) {
+			forwarded[strings.TrimPrefix(name, EnvForwardPrefix)] = value
+			continue
+		}
+		for _, pattern := range c.Env.Forward {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				if _, set := forwarded[name]; !set {
+					forwarded[name] = value
+				}
+				break
+			}
+		}
+	}
+
+	env = make([]string, 0, len(forwarded))
+	for name, value := range forwarded {
+		if !utils.ForwardableEnv(name) {
+			blocked = append(blocked, name)
+			continue
+		}
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	sort.Strings(blocked)
+	return env, blocked
+}
@@ -0,0 +1,390 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options address the controller of a Session and set its limits
+type Options struct {
+	ServerAddr        string
+	LibPath           string
+	LibProc           string
+	ControllerID      string          // Controller ID on a discon-manager
+	ControllerVersion string          // Controller version on a discon-manager
+	TLS               utils.TLSConfig // Used for https:// and wss:// addresses
+	Header            http.Header     // Sent with the handshake, e.g. the forwarded environment variables
+
+	ConnectTimeout  time.Duration // Handshake timeout of each attempt, none if zero
+	ConnectRetries  int           // Handshake attempts, at least one is made
+	CallTimeout     time.Duration // Time the controller may take to respond, none if zero
+	TransferTimeout time.Duration // Time the server may take to respond to a control message, none if zero
+	ChunkSize       int64         // Size of the chunks files are uploaded in, 1 MiB if zero
+	NoCache         bool          // Upload every file as a transient file the server doesn't cache
+
+	Logger  *utils.DebugLogger // Logs the connection attempts and upload progress, nil to log nothing
+	Observe func(Exchange)     // Called after every request, e.g. to collect statistics
+
+	// Called with the lines the controller prints during a call, before the
+	// call returns. Setting it asks the server to forward the output.
+	Console func(utils.ConsoleLine)
+}
+
+// Exchange describes a request answered by the server
+type Exchange struct {
+	Control  bool          // A control message rather than a controller call
+	Duration time.Duration // Round trip of the request
+	Sent     int           // Size of the request in bytes
+	Received int           // Size of the response in bytes
+}
+
+// Default size of the chunks files are uploaded in
+const defaultChunkSize = 1024 * 1024
+
+// SessionOptions returns the options of a Session with the settings of the
+// configuration, forwarding the environment variables it selects
+func (c *Config) SessionOptions() Options {
+	header := http.Header{}
+	env, _ := c.ForwardedEnv()
+	for _, pair := range env {
+		header.Add(utils.EnvHeader, pair)
+	}
+	return Options{
+		ServerAddr:        c.ServerAddr,
+		LibPath:           c.LibPath,
+		LibProc:           c.LibProc,
+		ControllerID:      c.ControllerID,
+		ControllerVersion: c.ControllerVersion,
+		TLS:               c.TLS,
+		Header:            header,
+		ConnectTimeout:    c.Timeouts.Connect,
+		ConnectRetries:    c.Timeouts.ConnectRetries,
+		CallTimeout:       c.Timeouts.Call,
+		TransferTimeout:   c.Timeouts.Transfer,
+		ChunkSize:         c.Upload.ChunkSize,
+		NoCache:           c.Cache.Disabled,
+	}
+}
+
+// ErrClosed is returned by the requests of a closed Session
+var ErrClosed = errors.New("session closed")
+
+// RejectionError is returned if the server rejects the connection, e.g. for an
+// unknown controller, which retrying won't change
+type RejectionError struct {
+	Addr       string
+	StatusCode int
+	Reason     string
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("discon-server at %s rejected the connection: %s", e.Addr, e.Reason)
+}
+
+// ConnectionError is returned when the connection to the server was lost or a
+// request timed out. The Session can't be used afterwards, the transfers can
+// be resumed with a new one.
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// IsConnectionError reports whether the error was caused by a lost connection
+func IsConnectionError(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr)
+}
+
+// Session is a connection to a controller on a discon-server, directly or
+// through a discon-manager. The server loads the controller for the session
+// and places the files uploaded in a directory of its own. Requests are sent
+// one at a time, so a Session may be shared by goroutines, and sessions are
+// independent of each other.
+type Session struct {
+	opts Options
+	ws   *websocket.Conn
+
+	mu     sync.Mutex   // Held for a request and its response
+	dir    string       // Session directory on the server, once known
+	err    error        // Returned by every request once the connection failed
+	queued []queuedFile // Files queued by QueueFile until the next Flush
+
+	closeOnce sync.Once
+}
+
+// Dial connects to the server, retrying with an exponential backoff until the
+// attempts are exhausted, the context is done or the server rejects the
+// connection with a *RejectionError
+func Dial(ctx context.Context, opts Options) (*Session, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	params := utils.ControllerParams(opts.LibPath, opts.LibProc, opts.ControllerID, opts.ControllerVersion)
+	if opts.Console != nil {
+		params.Set(utils.ConsoleParam, "1")
+	}
+	u, err := utils.ServerURL(opts.ServerAddr, params)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = opts.ConnectTimeout
+	if u.Scheme == "wss" {
+		if dialer.TLSClientConfig, err = opts.TLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Session{opts: opts}
+	s.debug("Connecting to discon-server at '%s'", u.Redacted())
+
+	attempts := max(opts.ConnectRetries, 1)
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			s.debug("Retrying connection to server (attempt %d/%d)...", attempt+1, attempts)
+			if err := sleepWithBackoff(ctx, attempt, 500*time.Millisecond); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		s.ws, resp, err = dialer.DialContext(ctx, u.String(), opts.Header)
+		if err == nil {
+			// A server of another release would misread the payloads
+			if err := utils.CheckProtocol("discon-server", resp.Header.Get(utils.ProtocolHeader)); err != nil {
+				s.ws.Close()
+				return nil, fmt.Errorf("failed to connect to discon-server at %s: %w", opts.ServerAddr, err)
+			}
+			s.debug("Connected to discon-server at '%s'", u.Redacted())
+			return s, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to connect to discon-server at %s: %w", opts.ServerAddr, ctx.Err())
+		}
+
+		// Include the reason given by the server if the handshake was rejected
+		if resp != nil {
+			reason := utils.HandshakeRejectionReason(resp)
+			err = fmt.Errorf("%w (HTTP %d: %s)", err, resp.StatusCode, reason)
+
+			// Client errors such as an unknown controller won't be fixed by retrying
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+				return nil, &RejectionError{Addr: opts.ServerAddr, StatusCode: resp.StatusCode, Reason: reason}
+			}
+		}
+		s.debug("Connection attempt %d failed: %v", attempt+1, err)
+	}
+
+	if attempts == 1 {
+		return nil, fmt.Errorf("failed to connect to discon-server at %s: %w", opts.ServerAddr, err)
+	}
+	return nil, fmt.Errorf("failed to connect to discon-server at %s after %d attempts: %w",
+		opts.ServerAddr, attempts, err)
+}
+
+// sleepWithBackoff waits for the base delay doubled for every retry, at most
+// 10 seconds, unless the context is done first
+func sleepWithBackoff(ctx context.Context, retry int, base time.Duration) error {
+	timer := time.NewTimer(min(base<<retry, 10*time.Second))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Session) debug(format string, v ...interface{}) {
+	if s.opts.Logger != nil {
+		s.opts.Logger.Debug(format, v...)
+	}
+}
+
+func (s *Session) verbose(format string, v ...interface{}) {
+	if s.opts.Logger != nil {
+		s.opts.Logger.Verbose(format, v...)
+	}
+}
+
+// Call sends a controller call and replaces the fields of the payload with the
+// controller's response. Buffers of the right size are overwritten rather than
+// reallocated, so the response lands in the caller's avrSWAP, avcOUTNAME and
+// avcMSG when the payload refers to them. A call which times out fails the
+// Session, as the controller may still be running.
+func (s *Session) Call(ctx context.Context, p *dw.Payload) error {
+	p.FileContent = nil
+	p.ServerFilePath = nil
+	p.Control = nil
+	utils.PreparePayloadForTransmission(p)
+	return s.roundTrip(ctx, p, p, s.opts.CallTimeout, false)
+}
+
+// Control sends a control message with optional content and returns the
+// server's response with its content
+func (s *Session) Control(ctx context.Context, msg *utils.ControlMessage, content []byte) (*utils.ControlMessage, []byte, error) {
+	request, err := utils.CreateControlPayload(msg, content)
+	if err != nil {
+		return nil, nil, utils.FormatError("creating control message", err)
+	}
+
+	var response dw.Payload
+	if err := s.roundTrip(ctx, request, &response, s.opts.TransferTimeout, true); err != nil {
+		return nil, nil, err
+	}
+	if response.Fail != 0 {
+		return nil, nil, fmt.Errorf("%s request failed: %s", msg.Type, utils.GetErrorMessageFromPayload(&response))
+	}
+	if !utils.IsControlMessage(&response) {
+		return nil, nil, fmt.Errorf("%s request failed: server does not support control messages", msg.Type)
+	}
+
+	reply, err := utils.ParseControlMessage(&response)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The session directory is returned when files are placed in it
+	if (msg.Type == utils.ControlHave || msg.Type == utils.ControlBundle) && reply.Path != "" {
+		s.mu.Lock()
+		s.dir = reply.Path
+		s.mu.Unlock()
+	}
+	return reply, response.FileContent, nil
+}
+
+// roundTrip sends a request and decodes the response into the given payload.
+// The request is abandoned when the timeout expires or the context is done.
+func (s *Session) roundTrip(ctx context.Context, request, response *dw.Payload, timeout time.Duration, control bool) error {
+	b, err := request.MarshalBinary()
+	if err != nil {
+		return utils.FormatError("marshaling request", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+
+	// Cancelling the context closes the connection to interrupt the request,
+	// which leaves the session failed even if the response arrived
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		s.ws.NetConn().Close()
+		close(interrupted)
+	})
+	defer func() {
+		if !stop() {
+			<-interrupted
+			if s.err == nil {
+				s.err = &ConnectionError{ctx.Err()}
+			}
+		}
+	}()
+
+	start := time.Now()
+	s.ws.SetWriteDeadline(deadline)
+	if err := s.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return s.fail(ctx, "sending request", err)
+	}
+	s.ws.SetReadDeadline(deadline)
+	var resp []byte
+	for {
+		_, resp, err = s.ws.ReadMessage()
+		if err != nil {
+			return s.fail(ctx, "receiving server response", err)
+		}
+		if !s.console(resp) {
+			break
+		}
+	}
+	s.ws.SetWriteDeadline(time.Time{})
+	s.ws.SetReadDeadline(time.Time{})
+
+	if s.opts.Observe != nil {
+		s.opts.Observe(Exchange{Control: control, Duration: time.Since(start), Sent: len(b), Received: len(resp)})
+	}
+
+	if err := response.UnmarshalBinary(resp); err != nil {
+		return utils.FormatError("unmarshaling server response", err)
+	}
+	return nil
+}
+
+// console passes the lines of a console message sent ahead of the response on
+// and reports whether the message was one
+func (s *Session) console(message []byte) bool {
+	if s.opts.Console == nil {
+		return false
+	}
+	var payload dw.Payload
+	if err := payload.UnmarshalBinary(message); err != nil || !utils.IsControlMessage(&payload) {
+		return false
+	}
+	msg, err := utils.ParseControlMessage(&payload)
+	if err != nil || msg.Type != utils.ControlConsole {
+		return false
+	}
+	for _, line := range msg.Lines {
+		s.opts.Console(line)
+	}
+	return true
+}
+
+// fail closes the connection after a failed request, which leaves it in an
+// unknown state, and returns the error for this and later requests
+func (s *Session) fail(ctx context.Context, operation string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	s.err = &ConnectionError{utils.FormatError(operation, err)}
+	s.ws.Close()
+	return s.err
+}
+
+// Close closes the connection, which unloads the controller on the server. A
+// request in progress is interrupted.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = s.ws.Close()
+
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = ErrClosed
+		}
+		s.mu.Unlock()
+	})
+	return err
+}
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer answers have and put requests, holding the uploaded files, which
+// are listed as outputs and can be downloaded, and calls with avrSWAP(1)
+// incremented. A call with avrSWAP(2) < 0 isn't answered. If the client asks
+// for the console output, a line precedes every response. Downloads of a file
+// named "malformed" report a negative size.
+func fakeServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("path") == "" {
+			http.Error(w, "Controller not found", http.StatusNotFound)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, utils.ProtocolResponseHeader())
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		console := r.URL.Query().Get(utils.ConsoleParam) != ""
+
+		files := make(map[string][]byte)
+		for {
+			_, b, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request dw.Payload
+			if err := request.UnmarshalBinary(b); err != nil {
+				t.Error(err)
+				return
+			}
+
+			response := &request
+			if utils.IsControlMessage(&request) {
+				msg, _ := utils.ParseControlMessage(&request)
+				reply := &utils.ControlMessage{Type: msg.Type}
+				var content []byte
+				switch msg.Type {
+				case utils.ControlHave:
+					reply.Path = "/sessions/1"
+					for _, entry := range msg.Entries {
+						if _, ok := files[entry.Path]; !ok {
+							reply.Entries = append(reply.Entries, entry)
+						}
+					}
+				case utils.ControlPut:
+					entry := msg.Entries[0]
+					content := append(files[entry.Path][:msg.Offset:msg.Offset], request.FileContent...)
+					files[entry.Path] = content
+					reply.Offset = int64(len(content))
+					if reply.Offset == entry.Size {
+						reply.Path = "/sessions/1/" + entry.Path
+					}
+				case utils.ControlOutputs:
+					for name, content := range files {
+						reply.Entries = append(reply.Entries, utils.FileEntry{Path: name, Size: int64(len(content))})
+					}
+				case utils.ControlGet:
+					file := files[msg.Entries[0].Path]
+					end := min(msg.Offset+msg.Length, int64(len(file)))
+					content = file[msg.Offset:end]
+					result := utils.FileEntry{Path: msg.Entries[0].Path, Size: int64(len(file))}
+					if end == int64(len(file)) {
+						result.Hash = utils.ComputeFileHash(file)
+					}
+					if result.Path == "malformed" {
+						result.Size = -1
+					}
+					reply.Entries = []utils.FileEntry{result}
+					reply.Offset = msg.Offset
+					reply.ChunkHash = utils.ComputeFileHash(content)
+				}
+				response = utils.CreateControlResponse(reply, true, "")
+				response.FileContent = content
+			} else if request.Swap[1] < 0 {
+				continue
+			} else {
+				if console {
+					line := utils.ConsoleLine{Stream: "stdout", Time: request.Swap[1], Text: "called"}
+					msg := &utils.ControlMessage{Type: utils.ControlConsole, Lines: []utils.ConsoleLine{line}}
+					b, _ := utils.CreateControlResponse(msg, true, "").MarshalBinary()
+					ws.WriteMessage(websocket.BinaryMessage, b)
+				}
+				request.Swap[0]++
+				request.CallTime = int64(time.Millisecond)
+			}
+
+			b, _ = response.MarshalBinary()
+			if err := ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestSession(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	_, err := Dial(ctx, Options{ServerAddr: server.URL, ConnectRetries: 3})
+	var rejected *RejectionError
+	if !errors.As(err, &rejected) || rejected.StatusCode != http.StatusNotFound || rejected.Reason != "Controller not found" {
+		t.Fatalf("Expected the connection to be rejected, got %v", err)
+	}
+
+	var exchanges int
+	session, err := Dial(ctx, Options{
+		ServerAddr:  server.URL,
+		LibPath:     "discon.so",
+		ChunkSize:   4,
+		CallTimeout: 100 * time.Millisecond,
+		Observe:     func(Exchange) { exchanges++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	content := []byte("0123456789")
+	serverPath, err := session.UploadFile(ctx, utils.NewFileEntry("DISCON.IN", content), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverPath != "/sessions/1/DISCON.IN" {
+		t.Errorf("Unexpected server path %s", serverPath)
+	}
+	// A have request, a put asking for the offset to resume at and three chunks
+	if exchanges != 5 {
+		t.Errorf("Expected 5 requests, got %d", exchanges)
+	}
+
+	// The response is written into the caller's buffer
+	swap := []float32{1, 0, 0}
+	payload := &dw.Payload{Swap: swap}
+	if err := session.Call(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+	if swap[0] != 2 || payload.CallTime != int64(time.Millisecond) {
+		t.Errorf("Unexpected response %v", payload)
+	}
+
+	// A call which isn't answered times out and fails the session
+	err = session.Call(ctx, &dw.Payload{Swap: []float32{1, -1}})
+	if !IsConnectionError(err) {
+		t.Fatalf("Expected a connection error, got %v", err)
+	}
+	if err := session.Call(ctx, &dw.Payload{Swap: []float32{1}}); !IsConnectionError(err) {
+		t.Errorf("Expected the session to stay failed, got %v", err)
+	}
+}
+
+func TestSessionTransfers(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	var exchanges int
+	session, err := Dial(ctx, Options{
+		ServerAddr: server.URL,
+		LibPath:    "discon.so",
+		ChunkSize:  4,
+		Observe:    func(Exchange) { exchanges++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Queued files are looked up in one request, only the missing ones are uploaded
+	files := map[string][]byte{"a.IN": []byte("alpha"), "b.IN": []byte("bravo"), "malformed": []byte("x")}
+	for name, content := range files {
+		serverPath, err := session.QueueFile(ctx, utils.NewFileEntry(name, content), content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverPath != "/sessions/1/"+name {
+			t.Errorf("Unexpected server path %s", serverPath)
+		}
+	}
+	if err := session.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exchanges = 0
+	session.QueueFile(ctx, utils.NewFileEntry("a.IN", files["a.IN"]), files["a.IN"])
+	if err := session.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exchanges != 1 {
+		t.Errorf("Expected a cached file to be placed in 1 request, got %d", exchanges)
+	}
+
+	// Downloads are verified and only replace the target once complete
+	dir := t.TempDir()
+	count, err := session.RetrieveOutputs(ctx, dir, nil, []string{"b.*", "malformed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "a.IN"))
+	if count != 1 || string(content) != "alpha" {
+		t.Errorf("Expected a.IN to be retrieved, got %d files and %q", count, content)
+	}
+
+	target := filepath.Join(dir, "malformed")
+	_, err = session.DownloadFile(ctx, "malformed", target)
+	if err == nil || !strings.Contains(err.Error(), "malformed response") {
+		t.Errorf("Expected a malformed response to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be left by the failed download, got %v", err)
+	}
+}
+
+func TestSessionsAreIndependent(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session, err := Dial(context.Background(), Options{ServerAddr: server.URL, LibPath: "discon.so"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer session.Close()
+			for step := 0; step < 10; step++ {
+				payload := &dw.Payload{Swap: []float32{float32(i * 100), 0}}
+				if err := session.Call(context.Background(), payload); err != nil {
+					t.Error(err)
+					return
+				}
+				if payload.Swap[0] != float32(i*100+1) {
+					t.Errorf("Session %d received %g", i, payload.Swap[0])
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSessionConsole(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	var lines []utils.ConsoleLine
+	session, err := Dial(context.Background(), Options{
+		ServerAddr: server.URL,
+		LibPath:    "discon.so",
+		Console:    func(line utils.ConsoleLine) { lines = append(lines, line) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// The console message ahead of the response doesn't end the call
+	payload := &dw.Payload{Swap: []float32{1, 0.5}}
+	if err := session.Call(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Swap[0] != 2 {
+		t.Errorf("Unexpected response %v", payload)
+	}
+	if len(lines) != 1 || lines[0] != (utils.ConsoleLine{Stream: "stdout", Time: 0.5, Text: "called"}) {
+		t.Errorf("Unexpected console output %v", lines)
+	}
+}
+
+func TestSessionProtocol(t *testing.T) {
+	// A server of an older release doesn't announce its protocol version
+	upgrader := websocket.Upgrader{}
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.URL.Query().Get(utils.ProtocolParam) == "" {
+			t.Error("Expected the client to send its protocol version")
+		}
+		if ws, err := upgrader.Upgrade(w, r, nil); err == nil {
+			ws.Close()
+		}
+	}))
+	defer server.Close()
+
+	_, err := Dial(context.Background(), Options{ServerAddr: server.URL, LibPath: "discon.so", ConnectRetries: 3})
+	if err == nil || !strings.Contains(err.Error(), "protocol version") || attempts != 1 {
+		t.Errorf("Expected the connection to fail without retries, got %v after %d attempts", err, attempts)
+	}
+
+	// Payloads written with another layout aren't misread
+	b, _ := (&dw.Payload{Swap: []float32{1}}).MarshalBinary()
+	var payload dw.Payload
+	if err := payload.UnmarshalBinary(b[6:]); !errors.Is(err, dw.ErrProtocolVersion) {
+		t.Errorf("Expected a payload without magic to be rejected, got %v", err)
+	}
+	b[4]++
+	if err := payload.UnmarshalBinary(b); !errors.Is(err, dw.ErrProtocolVersion) {
+		t.Errorf("Expected a payload of another version to be rejected, got %v", err)
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	session, err := Dial(context.Background(), Options{ServerAddr: server.URL, LibPath: "discon.so"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Without a call timeout, cancelling the context interrupts the call
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = session.Call(ctx, &dw.Payload{Swap: []float32{1, -1}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the call to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Dir returns the directory on the server files are placed in for the session,
+// asking the server the first time
+func (s *Session) Dir(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	dir := s.dir
+	s.mu.Unlock()
+	if dir != "" {
+		return dir, nil
+	}
+
+	if _, _, err := s.Control(ctx, &utils.ControlMessage{Type: utils.ControlHave}, nil); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir, nil
+}
+
+// Missing sends the file entries to the server, which places the files it has
+// cached in the session directory, and returns the entries it lacks
+func (s *Session) Missing(ctx context.Context, entries []utils.FileEntry) (map[utils.FileEntry]bool, error) {
+	response, _, err := s.Control(ctx, &utils.ControlMessage{Type: utils.ControlHave, Entries: entries}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[utils.FileEntry]bool)
+	for _, entry := range response.Entries {
+		missing[entry] = true
+	}
+	return missing, nil
+}
+
+// UploadFile places the content at the path of the entry in the session
+// directory, uploading it only if the server hasn't cached it, and returns its
+// server path. The entry is created by utils.NewFileEntry.
+func (s *Session) UploadFile(ctx context.Context, entry utils.FileEntry, content []byte) (string, error) {
+	upload := true
+	if !s.opts.NoCache {
+		missing, err := s.Missing(ctx, []utils.FileEntry{entry})
+		if err != nil {
+			return "", err
+		}
+		upload = missing[entry]
+	}
+	if upload {
+		if err := s.PutFile(ctx, entry, content, s.opts.NoCache); err != nil {
+			return "", fmt.Errorf("failed to upload %s: %w", entry.Path, err)
+		}
+	}
+
+	dir, err := s.Dir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, entry.Path), nil
+}
+
+// queuedFile is a file queued to be placed by the next Flush
+type queuedFile struct {
+	entry   utils.FileEntry
+	content []byte
+}
+
+// QueueFile queues the content to be placed at the path of the entry in the
+// session directory by the next Flush and returns its server path, so that
+// the files of a transfer are looked up in the server's cache at once
+func (s *Session) QueueFile(ctx context.Context, entry utils.FileEntry, content []byte) (string, error) {
+	dir, err := s.Dir(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.queued = append(s.queued, queuedFile{entry, content})
+	s.mu.Unlock()
+	return path.Join(dir, entry.Path), nil
+}
+
+// Flush sends the entries of the queued files to the server, which places the
+// ones it has cached, and uploads only the files it lacks. Without the cache
+// every file is uploaded. The queue is emptied even if the transfer fails.
+func (s *Session) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	queued := s.queued
+	s.queued = nil
+	s.mu.Unlock()
+	if len(queued) == 0 {
+		return nil
+	}
+
+	entries := make([]utils.FileEntry, len(queued))
+	for i, file := range queued {
+		entries[i] = file.entry
+	}
+	wanted := make(map[utils.FileEntry]bool)
+	if s.opts.NoCache {
+		for _, entry := range entries {
+			wanted[entry] = true
+		}
+	} else {
+		var err error
+		if wanted, err = s.Missing(ctx, entries); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range queued {
+		if !wanted[file.entry] {
+			s.debug("File %s is cached on the server", file.entry.Path)
+			continue
+		}
+		s.debug("Uploading %s to server (size: %d bytes)", file.entry.Path, len(file.content))
+		if err := s.PutFile(ctx, file.entry, file.content, s.opts.NoCache); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file.entry.Path, err)
+		}
+	}
+
+	if !s.opts.NoCache {
+		s.debug("%d of %d files were cached on the server", len(queued)-len(wanted), len(queued))
+	}
+	return nil
+}
+
+// PutFile uploads a file the server lacks in chunks, resuming at the offset
+// the server already holds from an earlier, interrupted upload. Transient
+// files are not added to the server's cache.
+func (s *Session) PutFile(ctx context.Context, entry utils.FileEntry, content []byte, transient bool) error {
+	return s.PutFileFrom(ctx, entry, bytes.NewReader(content), transient)
+}
+
+// PutFileFrom uploads a file of entry.Size bytes like PutFile, reading only
+// the chunks being sent from r, e.g. an *os.File
+func (s *Session) PutFileFrom(ctx context.Context, entry utils.FileEntry, r io.ReaderAt, transient bool) error {
+	chunkSize := s.opts.ChunkSize
+	size := entry.Size
+	offset := int64(0)
+
+	// Ask for the offset to resume at before sending a chunk which may not be needed
+	if size > chunkSize {
+		msg := &utils.ControlMessage{Type: utils.ControlPut, Entries: []utils.FileEntry{entry}, Transient: transient}
+		response, _, err := s.Control(ctx, msg, nil)
+		if err != nil {
+			return err
+		}
+		if response.Path != "" {
+			return nil
+		}
+		offset = response.Offset
+		if offset > 0 {
+			s.debug("Resuming upload of %s at %d of %d bytes", entry.Path, offset, size)
+		}
+	}
+
+	buf := make([]byte, min(chunkSize, size))
+	lastProgress := int64(-1)
+	for {
+		end := min(offset+chunkSize, size)
+		chunk := buf[:end-offset]
+		if n, err := r.ReadAt(chunk, offset); n < len(chunk) {
+			return fmt.Errorf("error reading %s at offset %d: %w", entry.Path, offset, err)
+		}
+		msg := &utils.ControlMessage{
+			Type:      utils.ControlPut,
+			Entries:   []utils.FileEntry{entry},
+			Offset:    offset,
+			ChunkHash: utils.ComputeFileHash(chunk),
+			Transient: transient,
+		}
+
+		response, _, err := s.Control(ctx, msg, chunk)
+		if err != nil {
+			return err
+		}
+		if response.Path != "" {
+			return nil
+		}
+		if response.Offset == offset || response.Offset > size {
+			return fmt.Errorf("server did not accept the chunk of %s at offset %d", entry.Path, offset)
+		}
+		offset = response.Offset
+
+		// Report progress every 10% for files sent in several chunks
+		s.verbose("Uploaded %s: %d of %d bytes", entry.Path, offset, size)
+		if progress := offset * 10 / size; progress != lastProgress {
+			s.debug("Uploading %s: %d of %d bytes (%d%%)", entry.Path, offset, size, offset*100/size)
+			lastProgress = progress
+		}
+	}
+}
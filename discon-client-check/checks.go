@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+)
+
+// Sizes of the avrSWAP and avcMSG buffers of the initialization call
+const (
+	callSwapSize = 300
+	callMsgSize  = 1024
+)
+
+// options of a check run
+type options struct {
+	inFile string // Controller input file, optional
+	libDir string // Directory configuration files are searched in
+	call   bool   // Make an initialization call
+}
+
+// Results of a check
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusWarn = "WARN"
+	statusSkip = "SKIP"
+)
+
+// checklist prints the result of every check as it completes
+type checklist struct {
+	out    io.Writer
+	failed int
+}
+
+func (l *checklist) report(status, name, format string, args ...interface{}) {
+	if status == statusFail {
+		l.failed++
+	}
+	fmt.Fprintf(l.out, "[%s] %-18s %s\n", status, name, fmt.Sprintf(format, args...))
+}
+
+// runChecks runs the checks and returns the number which failed. A check is
+// skipped if one it depends on failed.
+func runChecks(opts options, out io.Writer) int {
+	l := &checklist{out: out}
+
+	config, err := client.ResolveConfig(opts.inFile, opts.libDir)
+	if err != nil {
+		if path, findErr := client.FindConfigFile(opts.libDir); findErr == nil && path != "" {
+			l.report(statusFail, "Configuration", "%s: %v", path, err)
+		} else {
+			l.report(statusFail, "Configuration", "%v", err)
+		}
+		return l.failed
+	}
+	if config.SourceFile != "" {
+		l.report(statusPass, "Configuration", "profile '%s' from %s", config.ProfileName, config.SourceFile)
+	} else {
+		l.report(statusPass, "Configuration", "environment variables, no configuration file in %s", displayDir(opts.libDir))
+	}
+	printSettings(out, config)
+
+	checkLocalFiles(l, config, opts.inFile)
+
+	env, blocked := config.ForwardedEnv()
+	if len(blocked) > 0 {
+		l.report(statusWarn, "Environment", "not forwarded to the controller: %s", strings.Join(blocked, ", "))
+	} else if len(env) > 0 {
+		l.report(statusPass, "Environment", "%d variables forwarded to the controller", len(env))
+	}
+
+	if config.Mode == client.ModeDirect {
+		checkFile(l, "Controller library", config.LibPath)
+		l.report(statusSkip, "Server", "direct mode, the controller is loaded in-process")
+		return l.failed
+	}
+	if config.FallbackLib != "" {
+		checkFile(l, "Fallback library", config.FallbackLib)
+	}
+
+	session := checkConnection(l, config)
+	if session == nil {
+		return l.failed
+	}
+	defer session.Close()
+
+	if opts.call {
+		checkInitCall(l, config, session, opts.inFile)
+	} else {
+		l.report(statusSkip, "Init call", "use -call to call the controller")
+	}
+	return l.failed
+}
+
+// printSettings prints the settings which address the controller
+func printSettings(out io.Writer, config *client.Config) {
+	mode := config.Mode
+	if mode == "" {
+		mode = client.ModeRemote
+	}
+	fmt.Fprintf(out, "       %-18s %s\n", "Mode", mode)
+	if mode == client.ModeRemote {
+		fmt.Fprintf(out, "       %-18s %s\n", "Server address", config.ServerAddr)
+	}
+	fmt.Fprintf(out, "       %-18s %s (procedure '%s')\n", "Library", config.LibPath, config.LibProc)
+	if config.ControllerID != "" || config.ControllerVersion != "" {
+		fmt.Fprintf(out, "       %-18s %s %s\n", "Controller", config.ControllerID, config.ControllerVersion)
+	}
+}
+
+func displayDir(dir string) string {
+	if dir == "" {
+		return "the client directory"
+	}
+	return dir
+}
+
+// checkLocalFiles checks that the files the client transfers exist
+func checkLocalFiles(l *checklist, config *client.Config, inFile string) {
+	if inFile == "" {
+		l.report(statusSkip, "Input file", "use -infile to check the controller input file")
+	} else {
+		checkFile(l, "Input file", inFile)
+	}
+	for _, file := range config.AdditionalFiles {
+		checkFile(l, "Additional file", file)
+	}
+	if config.Bundle.Root != "" {
+		if info, err := os.Stat(config.Bundle.Root); err != nil || !info.IsDir() {
+			l.report(statusFail, "Bundle root", "%s is not a directory", config.Bundle.Root)
+		} else {
+			l.report(statusPass, "Bundle root", "%s", config.Bundle.Root)
+		}
+	}
+}
+
+func checkFile(l *checklist, name, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		l.report(statusFail, name, "%v", err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		l.report(statusFail, name, "%s is not a file", path)
+		return
+	}
+	l.report(statusPass, name, "%s (%d bytes)", path, info.Size())
+}
+
+// checkConnection tests each step of connecting to the server and returns
+// the session, or nil if a step failed
+func checkConnection(l *checklist, config *client.Config) *client.Session {
+	params := utils.ControllerParams(config.LibPath, config.LibProc, config.ControllerID, config.ControllerVersion)
+	u, err := utils.ServerURL(config.ServerAddr, params)
+	if err != nil {
+		l.report(statusFail, "Server URL", "invalid server address %q: %v", config.ServerAddr, err)
+		return nil
+	}
+	l.report(statusPass, "Server URL", "%s", u.Redacted())
+
+	timeout := config.Timeouts.Connect
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		l.report(statusPass, "DNS", "%s is an IP address", host)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			l.report(statusFail, "DNS", "%v", err)
+			return nil
+		}
+		l.report(statusPass, "DNS", "%s resolves to %s", host, strings.Join(addrs, ", "))
+	}
+
+	address := serverHostPort(u)
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		l.report(statusFail, "TCP", "%v", err)
+		return nil
+	}
+	conn.Close()
+	l.report(statusPass, "TCP", "connected to %s in %v", address, time.Since(start).Round(time.Millisecond))
+
+	if u.Scheme == "wss" {
+		tlsConfig, err := config.TLSClientConfig()
+		if err != nil {
+			l.report(statusFail, "TLS", "%v", err)
+			return nil
+		}
+		if !checkTLS(l, address, host, tlsConfig, timeout) {
+			return nil
+		}
+	}
+
+	// A single attempt, the client's retries would only delay the report
+	opts := config.SessionOptions()
+	opts.ConnectTimeout = timeout
+	opts.ConnectRetries = 1
+	session, err := client.Dial(context.Background(), opts)
+	if err != nil {
+		var rejected *client.RejectionError
+		if errors.As(err, &rejected) {
+			l.report(statusFail, "WebSocket", "server rejected the connection with HTTP %d: %s", rejected.StatusCode, rejected.Reason)
+		} else {
+			l.report(statusFail, "WebSocket", "%v", err)
+		}
+		return nil
+	}
+	l.report(statusPass, "WebSocket", "connection accepted, the server found the controller")
+	return session
+}
+
+// serverHostPort returns the host and port of the server, with the default
+// port of the scheme if none is given
+func serverHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkTLS performs a TLS handshake with the client's TLS settings
+func checkTLS(l *checklist, address, host string, config *tls.Config, timeout time.Duration) bool {
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, config)
+	if err != nil {
+		l.report(statusFail, "TLS", "%v (check tls.ca_file and tls.server_name)", err)
+		return false
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	detail := tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		detail += fmt.Sprintf(", certificate for %s valid until %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+	}
+	if config.InsecureSkipVerify {
+		l.report(statusWarn, "TLS", "%s, certificate not verified (tls.insecure_skip_verify)", detail)
+	} else {
+		l.report(statusPass, "TLS", "%s", detail)
+	}
+	return true
+}
+
+// checkInitCall transfers the input and additional files and makes the
+// initialization call a simulation would make, reporting the controller's response
+func checkInitCall(l *checklist, config *client.Config, session *client.Session, inFile string) {
+	ctx := context.Background()
+	serverInFile := ""
+	if inFile != "" {
+		files := append([]string{inFile}, config.AdditionalFiles...)
+		paths, err := placeFiles(ctx, session, files)
+		if err != nil {
+			l.report(statusFail, "File transfer", "%v", err)
+			return
+		}
+		l.report(statusPass, "File transfer", "%d files placed on the server", len(files))
+		serverInFile = paths[0]
+	}
+
+	swap := make([]float32, callSwapSize)
+	swap[2] = 0.01 // Communication interval
+	swap[48] = callMsgSize
+	swap[49] = float32(len(serverInFile) + 1)
+	swap[63] = float32(len("discon-client-check") + 1)
+	swap[128] = callSwapSize
+	payload := &dw.Payload{
+		Swap:    swap,
+		InFile:  []byte(serverInFile + "\x00"),
+		OutName: []byte("discon-client-check\x00"),
+		Msg:     make([]byte, callMsgSize),
+	}
+
+	start := time.Now()
+	if err := session.Call(ctx, payload); err != nil {
+		l.report(statusFail, "Init call", "%v", err)
+		return
+	}
+	msg := utils.ExtractStringFromBytes(payload.Msg)
+	if payload.Fail < 0 {
+		l.report(statusFail, "Init call", "controller failed with aviFAIL=%d: %s", payload.Fail, msg)
+		return
+	}
+	l.report(statusPass, "Init call", "aviFAIL=%d in %v (controller %v) %s", payload.Fail,
+		time.Since(start).Round(time.Microsecond), time.Duration(payload.CallTime).Round(time.Microsecond), msg)
+}
+
+// placeFiles places the files in the session directory under the names the
+// client uses and returns their server paths
+func placeFiles(ctx context.Context, session *client.Session, files []string) ([]string, error) {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		content, err := utils.ReadFileContents(file)
+		if err != nil {
+			return nil, err
+		}
+		entry := utils.NewFileEntry(utils.GenerateServerFilePath(content, file), content)
+		if paths[i], err = session.UploadFile(ctx, entry, content); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
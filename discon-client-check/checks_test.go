@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunChecksReportsRejection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Controller 'rosco' version 'v9' not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	inFile := filepath.Join(dir, "DISCON.IN")
+	os.WriteFile(inFile, []byte("1 ! a\n"), 0644)
+	t.Setenv("DISCON_CONFIG", "")
+	t.Setenv("DISCON_SERVER_ADDR", server.URL)
+	t.Setenv("DISCON_LIB_PATH", "")
+	t.Setenv("DISCON_LIB_PROC", "")
+	t.Setenv("DISCON_CONTROLLER_ID", "rosco")
+	t.Setenv("DISCON_CONTROLLER_VERSION", "v9")
+	t.Setenv("DISCON_ADDITIONAL_FILES", filepath.Join(dir, "missing.txt"))
+
+	var out bytes.Buffer
+	failed := runChecks(options{inFile: inFile, libDir: dir}, &out)
+	if failed != 2 {
+		t.Errorf("Expected the additional file and WebSocket checks to fail, got %d failures:\n%s", failed, out.String())
+	}
+	for _, want := range []string{
+		"[PASS] Input file",
+		"[FAIL] Additional file",
+		"[PASS] TCP",
+		"HTTP 404: Controller 'rosco' version 'v9' not found",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+}
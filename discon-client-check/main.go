@@ -0,0 +1,46 @@
+// discon-client-check diagnoses the connection of discon-client to its server
+// outside the simulation. It resolves the configuration from the same
+// configuration file and DISCON_* environment variables as the client library,
+// tests each step of the connection and prints a pass/fail checklist.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const program = "discon-client-check"
+const version = "v0.2.0"
+
+func main() {
+	inFile := flag.String("infile", "", "Controller input file passed by the simulation (DLL_InFile), selects the profile and is checked")
+	clientLib := flag.String("client", "", "discon-client library loaded by the simulation, configuration files are searched next to it (default: next to this program)")
+	call := flag.Bool("call", false, "Make an initialization call to the controller, transferring the input and additional files")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s\n\nUsage: %s [options]\n\n", program, version, program)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	libDir := ""
+	if *clientLib != "" {
+		libDir = filepath.Dir(*clientLib)
+	} else if exe, err := os.Executable(); err == nil {
+		libDir = filepath.Dir(exe)
+	}
+
+	fmt.Printf("%s %s\n\n", program, version)
+	failed := runChecks(options{inFile: *inFile, libDir: libDir, call: *call}, os.Stdout)
+	if failed > 0 {
+		fmt.Printf("\nFailed checks: %d\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("\nAll checks passed")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Files sent in the bundle, mapped from their file key to the slash-separated relative path
+var bundledFiles = make(map[string]string)
+
+// bundleRoot returns the absolute path of the bundle root, or an empty string if bundles are not used
+func bundleRoot() string {
+	if clientConfig == nil || clientConfig.Bundle.Root == "" {
+		return ""
+	}
+	return fileKey(clientConfig.Bundle.Root)
+}
+
+// sendBundle sends the configured directory tree to the server, which unpacks
+// it into the session directory preserving the relative paths
+func sendBundle() error {
+	root := bundleRoot()
+	files, err := utils.CollectBundleFiles(root, clientConfig.Bundle.Include, clientConfig.Bundle.Exclude)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files found in bundle directory %s", root)
+	}
+
+	// Only the files with a transform rule are read into memory, the others
+	// are hashed and archived from disk
+	entries := make([]utils.FileEntry, len(files))
+	for i := range files {
+		filePath := files[i].Path
+		if transformFor(files[i].Name) == nil {
+			hash, err := utils.HashFile(filePath)
+			if err != nil {
+				return err
+			}
+			entries[i] = utils.FileEntry{Path: files[i].Name, Hash: hash, Size: files[i].Size}
+			continue
+		}
+		content, err := utils.ReadFileContents(filePath)
+		if err != nil {
+			return err
+		}
+		if files[i].Content, err = transformFile(filePath, files[i].Name, content); err != nil {
+			return err
+		}
+		entries[i] = utils.NewFileEntry(files[i].Name, files[i].Content)
+		entries[i].Transforms = appliedTransforms[fileKey(filePath)]
+	}
+
+	logger.Debug("Sending bundle of %d files from %s", len(files), root)
+	sent, err := session.SendBundle(context.Background(), files, entries)
+	if err != nil {
+		return fmt.Errorf("failed to send bundle of %s: %w", root, err)
+	}
+
+	for _, file := range files {
+		bundledFiles[fileKey(filepath.Join(root, filepath.FromSlash(file.Name)))] = file.Name
+		logger.Verbose("Bundled %s", file.Name)
+	}
+
+	logger.Debug("Bundle placed on server, %d of %d files sent", sent, len(files))
+	return nil
+}
+
+// bundledServerPath returns the server path of a file sent in the bundle. The
+// session directory is known once the bundle was sent, should the session have
+// failed since the file is sent on its own, which reports the failure.
+func bundledServerPath(filePath string) (string, bool) {
+	rel, ok := bundledFiles[fileKey(filePath)]
+	if !ok {
+		return "", false
+	}
+	dir, err := session.Dir(context.Background())
+	if err != nil {
+		return "", false
+	}
+	return path.Join(dir, rel), true
+}
+
+// sendBundledFile replaces a file in the bundle directory on the server with new content
+func sendBundledFile(filePath string, content []byte) (string, error) {
+	rel := bundledFiles[fileKey(filePath)]
+	logger.Debug("Replacing bundled file %s on server (size: %d bytes)", rel, len(content))
+
+	file := utils.BundleFile{Name: rel, Content: content, Size: int64(len(content))}
+	entry := utils.NewFileEntry(rel, content)
+	entry.Transforms = appliedTransforms[fileKey(filePath)]
+	if _, err := session.SendBundle(context.Background(), []utils.BundleFile{file}, []utils.FileEntry{entry}); err != nil {
+		return "", err
+	}
+
+	serverPath, _ := bundledServerPath(filePath)
+	serverFilePaths[fileKey(filePath)] = serverPath
+	return serverPath, nil
+}
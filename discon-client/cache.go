@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"discon-wrapper/shared/utils"
+)
+
+// cacheEnabled reports whether files the server has cached are reused
+func cacheEnabled() bool {
+	return !clientConfig.Cache.Disabled
+}
+
+// queueCachedFile returns the server path of the file and queues it to be
+// placed on the server by the next flushUploads
+func queueCachedFile(filePath string, content []byte) (string, error) {
+	return queueFileAt(filePath, utils.GenerateServerFilePath(content, filePath), content)
+}
+
+// queueFileAt queues a file to be placed at the path relative to the session directory
+func queueFileAt(filePath, name string, content []byte) (string, error) {
+	entry := utils.NewFileEntry(name, content)
+	entry.Transforms = appliedTransforms[fileKey(filePath)]
+	serverPath, err := session.QueueFile(context.Background(), entry, content)
+	if err != nil {
+		return "", err
+	}
+	logger.Verbose("Queued %s for transfer to %s", filePath, serverPath)
+	serverFilePaths[fileKey(filePath)] = serverPath
+	return serverPath, nil
+}
+
+// flushUploads places the queued files on the server, uploading only the files
+// it lacks, once the mapped files are confirmed
+func flushUploads() error {
+	if err := verifyMappedFiles(); err != nil {
+		return err
+	}
+	if err := session.Flush(context.Background()); err != nil {
+		// Files of the failed transfer may be missing on the server, so every
+		// file is sent again, the ones already placed are found in its cache
+		clear(serverFilePaths)
+		return err
+	}
+	return nil
+}
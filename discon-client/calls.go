@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Slow calls logged individually, later ones are only counted
+const maxLoggedSlowCalls = 10
+
+// Calls to the controller which took longer than the slow call threshold
+var (
+	slowCalls       int
+	slowestCall     time.Duration
+	slowestCallTime float32
+)
+
+// isTimeout reports whether a read failed because its deadline expired
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// recordCallDuration logs a controller call slower than the threshold with its
+// simulation time, so that slow controllers or connections can be located
+func recordCallDuration(elapsed time.Duration, simTime float32) {
+	if elapsed < clientConfig.Timeouts.SlowCall {
+		return
+	}
+
+	slowCalls++
+	if elapsed > slowestCall {
+		slowestCall = elapsed
+		slowestCallTime = simTime
+	}
+	if slowCalls <= maxLoggedSlowCalls {
+		logger.LogAtLevel(0, "Slow controller call at t=%g s took %s", simTime, elapsed.Round(time.Millisecond))
+		if slowCalls == maxLoggedSlowCalls {
+			logger.LogAtLevel(0, "Further slow calls are only counted")
+		}
+	}
+}
+
+// reportSlowCalls logs a summary of the slow calls after the last call
+func reportSlowCalls() {
+	if slowCalls > 0 {
+		logger.LogAtLevel(0, "%d controller calls took longer than %s, the slowest at t=%g s took %s",
+			slowCalls, clientConfig.Timeouts.SlowCall, slowestCallTime, slowestCall.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Status flags passed by OpenFAST in avrSWAP[0] when it creates a checkpoint
+// and when it restarts from one. The controller writes or reads its state in
+// files named from avcOUTNAME.
+const (
+	statusCheckpoint = -8
+	statusRestart    = -9
+)
+
+// Suffix of the file listing the controller state files saved at a checkpoint
+const stateManifestSuffix = ".discon-state"
+
+// stateManifest lists the controller state files saved next to a checkpoint
+type stateManifest struct {
+	Files []utils.FileEntry `json:"files"`
+}
+
+// splitOutName splits the controller output root name into the local
+// directory and the name used in the session directory
+func splitOutName(outName string) (string, string) {
+	i := strings.LastIndexAny(outName, `/\`)
+	return outName[:i+1], outName[i+1:]
+}
+
+// saveCheckpointState downloads the files the controller wrote in the session
+// directory at a checkpoint, whose names start with the root name, next to the
+// local checkpoint. A manifest of the files is written for the restart.
+func saveCheckpointState(outName string) error {
+	localDir, name := splitOutName(outName)
+	if name == "" {
+		return fmt.Errorf("checkpoint has no root name")
+	}
+
+	outputs, err := session.Outputs(context.Background())
+	if err != nil {
+		return err
+	}
+
+	manifest := stateManifest{}
+	for _, entry := range outputs {
+		if strings.Contains(entry.Path, "/") || !strings.HasPrefix(entry.Path, name) {
+			continue
+		}
+		target := filepath.Join(localDir, entry.Path)
+		hash, err := session.DownloadFile(context.Background(), entry.Path, target)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", entry.Path, err)
+		}
+		logger.Debug("Saved controller state file %s (size: %d bytes)", target, entry.Size)
+		manifest.Files = append(manifest.Files, utils.FileEntry{Path: entry.Path, Hash: hash, Size: entry.Size})
+	}
+
+	if len(manifest.Files) == 0 {
+		logger.Debug("Controller wrote no state files for checkpoint %s", outName)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(localDir, name+stateManifestSuffix), data, 0644)
+}
+
+// restoreCheckpointState places the controller state files saved at a
+// checkpoint into the session directory before the restart call, so that the
+// controller finds them next to the root name it is given
+func restoreCheckpointState(outName string) error {
+	localDir, name := splitOutName(outName)
+	if name == "" {
+		return fmt.Errorf("restart has no root name")
+	}
+
+	manifestPath := filepath.Join(localDir, name+stateManifestSuffix)
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		logger.Debug("No controller state saved for checkpoint %s", outName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	manifest := stateManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid state manifest %s: %w", manifestPath, err)
+	}
+
+	for _, entry := range manifest.Files {
+		if err := utils.ValidateBundlePath(entry.Path); err != nil || strings.Contains(entry.Path, "/") {
+			return fmt.Errorf("invalid state file %q in %s", entry.Path, manifestPath)
+		}
+		filePath := filepath.Join(localDir, entry.Path)
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("error reading state file: %w", err)
+		}
+		if hash := utils.ComputeFileHash(content); hash != entry.Hash {
+			return fmt.Errorf("state file %s was modified after the checkpoint", filePath)
+		}
+		if _, err := queueFileAt(filePath, entry.Path, content); err != nil {
+			return err
+		}
+		logger.Debug("Restoring controller state file %s", filePath)
+	}
+	return flushUploads()
+}
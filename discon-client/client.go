@@ -3,22 +3,21 @@ package main
 import "C"
 
 import (
-	"crypto/tls"
+	"context"
 	dw "discon-wrapper"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"time" // Added time package
+	"sync"
+	"time"
 	"unsafe"
 
+	"discon-wrapper/client"
 	// GH-Cp gen: Import shared utilities
+	"discon-wrapper/shared/trace"
 	"discon-wrapper/shared/utils"
-
-	"github.com/gorilla/websocket"
 )
 
 const program = "discon-client"
@@ -26,22 +25,19 @@ const version = "v0.2.0"
 
 var debugLevel int = 0
 
-var ws *websocket.Conn
+// Session with the server, opened on the first call unless the controller is
+// called in-process
+var session *client.Session
 var payload dw.Payload
-var sentSwapFile *os.File
-var recvSwapFile *os.File
 
-// GH-Cp gen: Map to store server-side file paths for transferred files
-var serverFilePaths = make(map[string]string)
+// Configuration resolved on the first DISCON call
+var clientConfig *client.Config
 
-// Added map to track if a file is the primary input file
-var isPrimaryInputFile = make(map[string]bool)
+// Ends the run once, see endRun
+var runEnded sync.Once
 
-// Added map for storing file content replacements
-var fileContentReplacements = make(map[string][]struct {
-	Original string
-	Replaced string
-})
+// GH-Cp gen: Map to store server-side file paths for transferred files
+var serverFilePaths = make(map[string]string)
 
 // Track if additional files have been processed
 var additionalFilesProcessed bool = false
@@ -49,273 +45,227 @@ var additionalFilesProcessed bool = false
 // GH-Cp gen: Logger for client operations
 var logger *utils.DebugLogger
 
-// Process the DISCON_ADDITIONAL_FILES environment variable
+// Process the additional files from DISCON_ADDITIONAL_FILES or the configuration file
 func processAdditionalFiles() error {
-	additionalFilesStr, found := os.LookupEnv("DISCON_ADDITIONAL_FILES")
-	if (!found || additionalFilesStr == "") {
-		logger.Debug("No DISCON_ADDITIONAL_FILES specified")
+	additionalFiles := clientConfig.AdditionalFiles
+	if len(additionalFiles) == 0 {
+		logger.Debug("No additional files specified")
 		return nil
 	}
 
-	// Split the semicolon-separated list
-	additionalFiles := strings.Split(additionalFilesStr, ";")
 	logger.Debug("Processing %d additional files", len(additionalFiles))
 
 	// Process all additional files
 	for _, filePath := range additionalFiles {
-		filePath = strings.TrimSpace(filePath)
-		if filePath == "" {
-			continue
-		}
-
 		if !utils.FileExists(filePath) {
 			return fmt.Errorf("additional file does not exist: %s", filePath)
 		}
 
-		// Send the file but don't track errors - we'll collect and report them later
+		// Files in the bundle are already on the server
+		if serverPath, ok := bundledServerPath(filePath); ok {
+			logger.Debug("Additional file %s was sent in the bundle to %s", filePath, serverPath)
+			continue
+		}
+
 		serverPath, err := sendFileToServer(filePath)
 		if err != nil {
 			return fmt.Errorf("failed to send additional file %s: %w", filePath, err)
 		}
 
-		logger.Debug("Additional file %s transferred to server at %s", filePath, serverPath)
+		logger.Debug("Additional file %s queued for transfer to %s", filePath, serverPath)
 	}
 
-	return nil
+	return flushUploads()
 }
 
-// Function to update file references in a content buffer
-func updateFileReferences(content []byte) []byte {
-	contentStr := string(content)
-	
-	// Go through each file that might need replacement
-	for localPath, serverPath := range serverFilePaths {
-		// Skip the primary input file itself
-		if isPrimaryInputFile[localPath] {
-			continue
+// Set once the controller has been called, after which the connection can't be
+// replaced without losing the controller state
+var controllerCalled bool = false
+
+// transferFiles sends the bundle and additional files (once) followed by the
+// input file and the files it references. It returns the server path of the
+// input file, or an empty string if the input file doesn't exist locally.
+func transferFiles(inFilePath string) (string, error) {
+	if !additionalFilesProcessed {
+		if bundleRoot() != "" {
+			if err := sendBundle(); err != nil {
+				return "", fmt.Errorf("Bundle transfer failed: %w", err)
+			}
 		}
-		
-		// Also try to replace just the filename (in case only the filename is referenced)
-		// but only if it's not already a server path (doesn't start with "input_")
-		localFilename := filepath.Base(localPath)
-		serverFilename := filepath.Base(serverPath)
-		
-		// Only replace the filename if it's not already a server path (doesn't start with "input_")
-		if !strings.HasPrefix(localFilename, "input_") {
-			// Replace only whole words to avoid partial replacements within other words
-			contentStr = strings.ReplaceAll(contentStr, localFilename, serverFilename)
+
+		// Process any additional files specified via environment variable
+		if err := processAdditionalFiles(); err != nil {
+			return "", fmt.Errorf("Additional files transfer failed: %w", err)
 		}
+		additionalFilesProcessed = true
+	}
 
-		logger.Verbose("Replaced references from %s to %s in input file", localFilename, serverFilename)
+	// GH-Cp gen: Check if the input file exists locally and transfer it to server if needed
+	if inFilePath == "" {
+		return "", nil
 	}
-	
-	return []byte(contentStr)
+	if !utils.FileExists(inFilePath) {
+		// The server may reach the input file through a mapped prefix
+		if serverPath, ok := sendMappedFile(inFilePath); ok {
+			if err := flushUploads(); err != nil {
+				return "", fmt.Errorf("File transfer failed: %w", err)
+			}
+			return serverPath, nil
+		}
+		return "", nil
+	}
+	logger.Verbose("Input file found locally: %s", inFilePath)
+
+	// Transfer file, and the files it references, to server and get the server-side path
+	serverPath, err := transferInputFile(inFilePath)
+	if err != nil {
+		return "", fmt.Errorf("File transfer failed: %w", err)
+	}
+	return serverPath, nil
 }
 
-// GH-Cp gen: Function to send file to server with retry mechanism
+// sendFileToServer queues a file to be uploaded unless it was sent before
 func sendFileToServer(filePath string) (string, error) {
 	// Check if we've already sent this file
-	if serverPath, exists := serverFilePaths[filePath]; exists {
+	if serverPath, exists := serverFilePaths[fileKey(filePath)]; exists {
 		return serverPath, nil
 	}
 
 	// Read the file contents
-	content, err := utils.ReadFileContents(filePath)
+	content, err := readTransferFile(filePath, bundledFiles)
 	if err != nil {
 		return "", err
 	}
-	
-	// If this is the primary input file and we have additional files transferred,
-	// update references to those files in the content
-	if isPrimaryInputFile[filePath] && len(serverFilePaths) > 0 {
-		logger.Debug("Updating file references in content for %s", filePath)
-		content = updateFileReferences(content)
-	}
-
-	// Generate a server path using shared utility
-	serverPath := utils.GenerateServerFilePath(content, filePath)
 
-	// Create a file transfer payload using shared utility
-	fileTransferPayload := utils.CreateFileTransferPayload(content, serverPath)
-
-	logger.Debug("Sending file %s to server (size: %d bytes)", filePath, len(content))
-
-	// Add retry logic for file transfers
-	maxRetries := 3
-	var transferErr error
-	
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			logger.Debug("Retrying file transfer for %s (attempt %d/%d)...", filePath, retry+1, maxRetries)
-			// Wait a bit before retrying with exponential backoff
-			utils.SleepWithBackoff(retry, 200)
-		}
-		
-		// Send the file transfer payload to the server
-		b, err := fileTransferPayload.MarshalBinary()
-		if err != nil {
-			transferErr = utils.FormatError("marshaling file transfer payload", err)
-			continue
-		}
-
-		err = ws.WriteMessage(websocket.BinaryMessage, b)
-		if err != nil {
-			transferErr = utils.FormatError("sending file to server", err)
-			continue
-		}
-
-		// Wait for server response with a timeout
-		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
-		_, resp, err := ws.ReadMessage()
-		ws.SetReadDeadline(time.Time{}) // Clear the deadline after read
-		
-		if err != nil {
-			transferErr = utils.FormatError("receiving server response", err)
-			continue
-		}
-
-		// Unmarshal the response
-		var responsePayload dw.Payload
-		err = responsePayload.UnmarshalBinary(resp)
-		if err != nil {
-			transferErr = utils.FormatError("unmarshaling server response", err)
-			continue
-		}
-
-		// Check if the file transfer succeeded
-		if responsePayload.Fail != 0 {
-			// Get the error message from the Msg field using shared utility
-			errMsg := utils.GetErrorMessageFromPayload(&responsePayload)
-			transferErr = fmt.Errorf("file transfer failed: %s", errMsg)
-			continue
-		}
+	return sendFileContent(filePath, content)
+}
 
-		// Store the server path for future use
-		serverFilePaths[filePath] = serverPath
-		logger.Debug("File %s transferred successfully to server at %s", filePath, serverPath)
-		return serverPath, nil
-	}
-	
-	return "", fmt.Errorf("failed to transfer file after %d attempts: %v", maxRetries, transferErr)
+// sendFileContent queues the (possibly rewritten) content of a file to be
+// uploaded by the next flushUploads and returns its server path
+func sendFileContent(filePath string, content []byte) (string, error) {
+	return queueCachedFile(filePath, content)
 }
 
 func init() {
 	// Print info
 	fmt.Println("Loaded", program, version)
 
-	// Get debug flag from environment variable
-	csvFileName := "discon_swap"
-	debugStr, debug := os.LookupEnv("DISCON_CLIENT_DEBUG")
-	if debug {
+	// Start with the debug level from the environment so that configuration
+	// problems can be logged, the final level is set once connected
+	if debugStr, found := os.LookupEnv("DISCON_CLIENT_DEBUG"); found {
 		var err error
 		debugLevel, err = strconv.Atoi(debugStr)
 		if err != nil {
-			// If not a number, treat as filename and set debug level to 1
-			csvFileName = debugStr
 			debugLevel = 1
 		}
-
-		// Only create log files if debugLevel > 0
-		if debugLevel > 0 {
-			sentSwapFile, err = os.Create(csvFileName + "_sent.csv")
-			if err != nil {
-				log.Fatal("discon-client: error creating sent swap file:", err)
-			}
-			recvSwapFile, err = os.Create(csvFileName + "_recv.csv")
-			if err != nil {
-				log.Fatal("discon-client: error creating recv swap file:", err)
-			}
-		}
 	}
 
 	// GH-Cp gen: Initialize the logger
 	logger = utils.NewDebugLogger(debugLevel, "discon-client")
+}
 
-	// Get discon-server address from environment variable
-	serverAddr, found := os.LookupEnv("DISCON_SERVER_ADDR")
-	if !found {
-		log.Fatal("discon-client: environment variable DISCON_SERVER_ADDR not set (e.g. 'localhost:8080' or 'https://controller.domain.com')")
-	}
+// setupLogging applies the logging settings of the resolved configuration
+func setupLogging(config *client.Config) error {
+	debugLevel = config.Logging.Level
+	logger.DebugLevel = debugLevel
 
-	// Get shared library path from environment variable
-	disconPath, found := os.LookupEnv("DISCON_LIB_PATH")
-	if !found {
-		log.Fatal("discon-client: environment variable DISCON_LIB_PATH not set (e.g. 'discon.dll')")
+	if config.Logging.File != "" {
+		logFile, err := os.OpenFile(config.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening log file: %w", err)
+		}
+		log.SetOutput(logFile)
 	}
 
-	// Get shared library function name from environment variable
-	disconFunc, found := os.LookupEnv("DISCON_LIB_PROC")
-	if !found {
-		log.Fatal("discon-client: environment variable DISCON_LIB_PROC not set (e.g. 'discon')")
+	return openTrace(config)
+}
+
+// configureClient resolves the client configuration on the first DISCON call
+// so that the profile can be selected using the controller input file
+func configureClient(inFilePath string) error {
+	config, err := client.ResolveConfig(inFilePath, clientLibraryDir())
+	if err != nil {
+		return err
 	}
+	clientConfig = config
 
-	logger.Debug("DISCON_SERVER_ADDR= %s", serverAddr)
-	logger.Debug("DISCON_LIB_PATH= %s", disconPath)
-	logger.Debug("DISCON_LIB_PROC= %s", disconFunc)
-	logger.Debug("DISCON_CLIENT_DEBUG= %d", debugLevel)
-	logger.Debug("DISCON_ADDITIONAL_FILES= %s", os.Getenv("DISCON_ADDITIONAL_FILES"))
-
-	// Determine if we're using HTTPS/WSS based on the provided server address
-	var wsURL string
-	if strings.HasPrefix(strings.ToLower(serverAddr), "http://") {
-		// HTTP URL provided - use ws://
-		serverAddr = strings.TrimPrefix(serverAddr, "http://")
-		wsURL = fmt.Sprintf("ws://%s/ws", serverAddr)
-	} else if strings.HasPrefix(strings.ToLower(serverAddr), "https://") {
-		// HTTPS URL provided - use wss://
-		serverAddr = strings.TrimPrefix(serverAddr, "https://")
-		wsURL = fmt.Sprintf("wss://%s/ws", serverAddr)
-	} else {
-		// No protocol provided, assume ws:// (non-secure)
-		wsURL = fmt.Sprintf("ws://%s/ws", serverAddr)
+	if err := setupLogging(config); err != nil {
+		return err
 	}
 
-	// Create a URL object
-	u, err := url.Parse(wsURL)
-	if err != nil {
-		log.Fatal(err)
+	if config.SourceFile != "" {
+		logger.Debug("Using profile '%s' from %s", config.ProfileName, config.SourceFile)
 	}
+	logger.Debug("DISCON_SERVER_ADDR= %s", config.ServerAddr)
+	logger.Debug("DISCON_LIB_PATH= %s", config.LibPath)
+	logger.Debug("DISCON_LIB_PROC= %s", config.LibProc)
+	logger.Debug("DISCON_CONTROLLER_ID= %s", config.ControllerID)
+	logger.Debug("DISCON_CONTROLLER_VERSION= %s", config.ControllerVersion)
+	logger.Debug("DISCON_MODE= %s", config.Mode)
+	logger.Debug("DISCON_FALLBACK_LIB= %s", config.FallbackLib)
+	logger.Debug("DISCON_CLIENT_DEBUG= %d", debugLevel)
+	logger.Debug("DISCON_ADDITIONAL_FILES= %s", strings.Join(config.AdditionalFiles, ";"))
 
-	// Add query parameters for shared library path and proc
-	u.RawQuery = url.Values{"path": {disconPath}, "proc": {disconFunc}}.Encode()
+	return nil
+}
 
-	logger.Debug("connecting to discon-server at '%s'", u.String())
+// connectToServer opens a session with the server named in the client configuration
+func connectToServer() error {
+	opts := clientConfig.SessionOptions()
 
-	// Connect to websocket server with retry logic
-	dialer := websocket.DefaultDialer
-	// If using wss (secure WebSocket), we might need to skip certificate verification in some cases
-	if strings.HasPrefix(u.String(), "wss://") {
-		dialer.TLSClientConfig = &tls.Config{
-			// For production, you should properly handle certificates
-			// InsecureSkipVerify: true, // Uncomment this line to skip certificate verification (not recommended for production)
-		}
+	// Environment variables for the controller are forwarded in the handshake
+	opts.Header = envHeader(clientConfig)
+	opts.Logger = logger
+	opts.Observe = recordExchange
+	opts.Console = controllerConsole()
+
+	var err error
+	session, err = client.Dial(context.Background(), opts)
+	return err
+}
+
+// closeSession closes the session with the server, if one is open
+func closeSession() {
+	if session != nil {
+		session.Close()
+		session = nil
 	}
+}
 
-	// Add retry logic for initial connection
-	maxRetries := 5
-	var connectionErr error
-	
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			logger.Debug("Retrying connection to server (attempt %d/%d)...", retry+1, maxRetries)
-			// Wait a bit before retrying
-			utils.SleepWithBackoff(retry, 500) // Exponential backoff starting at 500ms
-		}
-		
-		ws, _, connectionErr = dialer.Dial(u.String(), nil)
-		if connectionErr == nil {
-			break // Connection successful
-		}
-		
-		logger.Debug("Connection attempt %d failed: %v", retry+1, connectionErr)
+// reconnect replaces a lost connection with a new one. The new connection has
+// a new session on the server, so every file has to be placed again.
+func reconnect() error {
+	closeSession()
+
+	pendingMapped = nil
+	additionalFilesProcessed = false
+	clear(serverFilePaths)
+	clear(bundledFiles)
+
+	return connectToServer()
+}
+
+// setFailure sets the fail flag and copies a null-terminated message into avcMSG
+func setFailure(aviFail *C.int, avcMsg *C.char, msgSize int, fail int, msg string) {
+	*aviFail = C.int(fail)
+	if avcMsg == nil || msgSize <= 0 {
+		return
 	}
-	
-	if connectionErr != nil {
-		log.Fatalf("discon-client: failed to connect to discon-server at %s after %d attempts: %s", 
-			serverAddr, maxRetries, connectionErr)
+	msgBytes := []byte(msg)
+	if len(msgBytes) > msgSize-1 {
+		msgBytes = msgBytes[:msgSize-1]
 	}
+	buf := (*[1 << 24]byte)(unsafe.Pointer(avcMsg))[:msgSize:msgSize]
+	n := copy(buf, msgBytes)
+	buf[n] = 0
+}
 
-	logger.Debug("Connected to discon-server at '%s'", u.String())
+// cBuffer returns a C buffer of the given size as a byte slice, or nil if there is none
+func cBuffer(p *C.char, size int) []byte {
+	if p == nil || size <= 0 {
+		return nil
+	}
+	return (*[1 << 24]byte)(unsafe.Pointer(p))[:size:size]
 }
 
 //export DISCON
@@ -328,6 +278,25 @@ func DISCON(avrSwap *C.float, aviFail *C.int, accInFile, avcOutName, avcMsg *C.c
 	inFileSize := int(swap[49])  // Maximum size of inFile string
 	outNameSize := int(swap[63]) // Maximum size of outName string
 	msgSize := int(swap[48])     // Maximum size of msg string
+	finalCall := swap[0] == -1   // Status flag is -1 on the last call of the simulation
+	simTime := swap[1]           // Simulation time of the call
+	checkpoint := swap[0] == statusCheckpoint
+	restart := swap[0] == statusRestart
+
+	// The sizes of the string buffers are changed to those of the server paths
+	// for the call and restored before returning to the caller
+	restoreSizes := func() {
+		swap[49] = float32(inFileSize)
+		swap[63] = float32(outNameSize)
+	}
+
+	// End the run after the final call, or after a call that failed as the
+	// simulation stops without a final call then
+	defer func() {
+		if finalCall || *aviFail < 0 {
+			endRun()
+		}
+	}()
 
 	// Resize payload arrays to match sizes
 	if len(payload.Swap) != swapSize {
@@ -351,41 +320,74 @@ func DISCON(avrSwap *C.float, aviFail *C.int, accInFile, avcOutName, avcMsg *C.c
 		// Remove any null terminators from the end of the string
 		inFilePath = utils.SafeTrimString(inFilePath) 
 	}
-	
-	// Process additional files before handling the main input file (but only once)
-	if !additionalFilesProcessed {
-		// Process any additional files specified via environment variable
-		if err := processAdditionalFiles(); err != nil {
-			logger.Error("Error processing additional files: %v", err)
-			// Set failure flag
-			*aviFail = C.int(1)
-			// Set error message
-			errMsg := fmt.Sprintf("Additional files transfer failed: %v", err)
-			copy((*[1 << 24]byte)(unsafe.Pointer(avcMsg))[:msgSize], []byte(errMsg))
+
+	// Resolve the configuration on the first call
+	if clientConfig == nil {
+		if err := configureClient(inFilePath); err != nil {
+			logger.Error("%v", err)
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: %v", err))
 			return
 		}
-		additionalFilesProcessed = true
 	}
 
-	// GH-Cp gen: Check if the input file exists locally and transfer it to server if needed
-	if inFilePath != "" && utils.FileExists(inFilePath) {
-		logger.Verbose("Input file found locally: %s", inFilePath)
-		
-		// Mark this as the primary input file
-		isPrimaryInputFile[inFilePath] = true
-
-		// Transfer file to server and get the server-side path
-		serverPath, err := sendFileToServer(inFilePath)
+	// In a dry run, report the files that would be transferred and stop the simulation
+	if clientConfig.Discovery.DryRun {
+		report, count, err := discoveryReport(inFilePath)
 		if err != nil {
-			logger.Error("Error transferring file %s: %v", inFilePath, err)
-			// Set failure flag
-			*aviFail = C.int(1)
-			// Set error message
-			errMsg := fmt.Sprintf("File transfer failed: %v", err)
-			copy((*[1 << 24]byte)(unsafe.Pointer(avcMsg))[:msgSize], []byte(errMsg))
+			logger.Error("Dry run failed: %v", err)
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: dry run failed: %v", err))
 			return
 		}
+		fmt.Print(report)
+		setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: dry run, %d files would be transferred", count))
+		return
+	}
 
+	// Copy the arguments for the trace before they are rewritten for the server
+	var traceInput trace.Step
+	if traceWriter != nil {
+		traceInput = trace.NewStep(swap[:swapSize], int32(*aviFail),
+			cBuffer(accInFile, inFileSize), cBuffer(avcOutName, outNameSize), cBuffer(avcMsg, msgSize))
+	}
+
+	// Load the controller in direct mode, otherwise connect to the server on
+	// the first call, calling the fallback library if it can't be reached
+	if clientConfig.Mode == client.ModeDirect && directLib == nil {
+		if err := loadDirectLibrary(clientConfig.LibPath); err != nil {
+			logger.Error("%v", err)
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: %v", err))
+			return
+		}
+	}
+	if directLib == nil && session == nil {
+		if err := connectToServer(); err != nil && !useFallbackLibrary(err) {
+			logger.Error("%v", err)
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: %v", err))
+			return
+		}
+	}
+	if directLib != nil {
+		callDirect(swap[:swapSize:swapSize], aviFail, accInFile, avcOutName, avcMsg, traceInput)
+		return
+	}
+	
+	// Transfer the files, reconnecting to resume the uploads if the connection is
+	// lost before the controller was first called
+	serverPath, err := transferFiles(inFilePath)
+	for attempt := 1; client.IsConnectionError(err) && !controllerCalled && attempt <= clientConfig.Upload.RetryLimit(); attempt++ {
+		logger.Error("Connection lost during file transfer: %v", err)
+		logger.Debug("Reconnecting to resume the file transfers (attempt %d/%d)", attempt, clientConfig.Upload.RetryLimit())
+		if err = reconnect(); err == nil {
+			serverPath, err = transferFiles(inFilePath)
+		}
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		setFailure(aviFail, avcMsg, msgSize, -1, err.Error())
+		return
+	}
+
+	if serverPath != "" {
 		// GH-Cp gen: Update the InFile field in payload with the server-side path
 		// First, create a new byte slice with the modified path
 		serverPathBytes := []byte(serverPath + "\x00")
@@ -421,60 +423,126 @@ func DISCON(avrSwap *C.float, aviFail *C.int, accInFile, avcOutName, avcMsg *C.c
 		payload.OutName = []byte{0}
 	}
 
+	// Move the output root name into the session directory so that the files
+	// written by the controller can be retrieved, and the controller state
+	// saved at a checkpoint can be placed next to it on restart
+	localOutName := utils.ExtractStringFromBytes(payload.OutName)
+	if outputsEnabled() || checkpoint || restart {
+		outName, err := sessionOutName(localOutName)
+		if err != nil {
+			logger.Error("Error opening session: %v", err)
+			restoreSizes()
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("Output file setup failed: %v", err))
+			return
+		}
+		if outName != "" {
+			payload.OutName = []byte(outName + "\x00")
+			swap[63] = float32(len(payload.OutName))
+			logger.Verbose("Using server path for output root name: %s", outName)
+		}
+	}
+
+	if restart {
+		if err := restoreCheckpointState(localOutName); err != nil {
+			logger.Error("Error restoring controller state: %v", err)
+			restoreSizes()
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: restoring controller state failed: %v", err))
+			return
+		}
+	}
+
 	if avcMsg != nil && msgSize > 0 {
 		payload.Msg = (*[1 << 24]byte)(unsafe.Pointer(avcMsg))[:msgSize:msgSize]
 	} else {
 		payload.Msg = []byte{0}
 	}
 
-	// Reset file transfer fields to avoid sending unnecessary data
-	payload.FileContent = nil
-	payload.ServerFilePath = nil
-
-	// GH-Cp gen: Ensure payload is properly formatted
-	utils.PreparePayloadForTransmission(&payload)
-
-	// Convert payload to binary and send over websocket
-	b, err := payload.MarshalBinary()
+	logger.Verbose("sending payload: %v", payload)
+
+	// Send the call, failing it if the controller doesn't respond in time
+	callStart := time.Now()
+	err = session.Call(context.Background(), &payload)
+	controllerCalled = true
+	if isTimeout(err) {
+		// The session can't be used after a timeout
+		closeSession()
+		restoreSizes()
+		logger.Error("Controller call at t=%g s timed out after %s", simTime, clientConfig.Timeouts.Call)
+		setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: controller call at t=%g s timed out after %s", simTime, clientConfig.Timeouts.Call))
+		return
+	}
 	if err != nil {
-		log.Fatalf("discon-client: %s", err)
+		// The session can't be used after a lost connection either, and the
+		// controller state is lost with it
+		closeSession()
+		restoreSizes()
+		logger.Error("Controller call at t=%g s failed: %v", simTime, err)
+		setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: controller call at t=%g s failed: %v", simTime, err))
+		return
 	}
-	ws.WriteMessage(websocket.BinaryMessage, b)
+	roundTrip := time.Since(callStart)
+	recordCallDuration(roundTrip, simTime)
+
+	logger.Verbose("received payload: %v", payload)
+	recordCall(roundTrip, time.Duration(payload.CallTime))
 
-	logger.Verbose("sent payload: %v", payload)
+	// Restore the sizes of the caller's string buffers
+	restoreSizes()
 
-	if debugLevel >= 1 && sentSwapFile != nil {
-		outSwapSize := min(swapSize, 163)
-		for _, v := range payload.Swap[:outSwapSize-1] {
-			fmt.Fprintf(sentSwapFile, "%g,", v)
+	// Set fail flag
+	*aviFail = C.int(payload.Fail)
+
+	// Save the controller state next to the checkpoint, failing the checkpoint
+	// if the state can't be saved as the restart would not find it
+	if checkpoint && payload.Fail >= 0 {
+		if err := saveCheckpointState(localOutName); err != nil {
+			logger.Error("Error saving controller state: %v", err)
+			setFailure(aviFail, avcMsg, msgSize, -1, fmt.Sprintf("discon-client: saving controller state failed: %v", err))
 		}
-		fmt.Fprintf(sentSwapFile, "%g\n", payload.Swap[outSwapSize-1])
 	}
 
-	// Read response from server
-	_, b, err = ws.ReadMessage()
-	if err != nil {
-		log.Fatalf("discon-client: %s", err)
+	// Record the call with the arguments as returned to the caller
+	if traceWriter != nil {
+		recordTrace(trace.Call{
+			Start:          callStart,
+			Duration:       roundTrip,
+			ControllerTime: time.Duration(payload.CallTime),
+			Input:          traceInput,
+			Output: trace.NewStep(swap[:swapSize], int32(*aviFail),
+				cBuffer(accInFile, inFileSize), cBuffer(avcOutName, outNameSize), cBuffer(avcMsg, msgSize)),
+		})
 	}
 
-	// Unmarshal binary data into payload
-	err = payload.UnmarshalBinary(b)
-	if err != nil {
-		log.Fatalf("discon-client: %s", err)
+	// Download the files written by the controller after its last call, the
+	// statistics are reported once these transfers are done
+	if finalCall && outputsEnabled() {
+		if err := retrieveOutputs(); err != nil {
+			logger.Error("Error retrieving output files: %v", err)
+		}
 	}
+}
 
-	logger.Verbose("received payload: %v", payload)
-
-	if debugLevel >= 1 && recvSwapFile != nil {
-		outSwapSize := min(swapSize, 163)
-		for _, v := range payload.Swap[:outSwapSize-1] {
-			fmt.Fprintf(recvSwapFile, "%g,", v)
-		}
-		fmt.Fprintf(recvSwapFile, "%g\n", payload.Swap[outSwapSize-1])
+// endRun reports the slow calls and the statistics of the run and closes the
+// trace. Only the first call after the client was configured has an effect.
+func endRun() {
+	if clientConfig == nil {
+		return
 	}
+	runEnded.Do(func() {
+		reportSlowCalls()
+		reportStats()
+		closeTrace()
+	})
+}
 
-	// Set fail flag
-	*aviFail = C.int(payload.Fail)
+// DISCON_CLOSE ends the run for callers which stop the simulation without a
+// final DISCON call, and closes the session with the server. It is the last
+// call into the library.
+//
+//export DISCON_CLOSE
+func DISCON_CLOSE() {
+	endRun()
+	closeSession()
 }
 
 func main() {}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"discon-wrapper/shared/utils"
+)
+
+var (
+	consoleMu   sync.Mutex
+	consoleFile *os.File // Log file of the controller output, opened on the first line
+	consoleErr  error    // Error opening the log file, the output is dropped
+)
+
+// controllerConsole returns the function echoing the output the controller
+// prints on the server, or nil if it's not forwarded
+func controllerConsole() func(utils.ConsoleLine) {
+	switch target := strings.TrimSpace(clientConfig.Logging.ControllerOutput); strings.ToLower(target) {
+	case "off", "none", "false":
+		return nil
+	case "", "console":
+		return echoConsole
+	default:
+		return func(line utils.ConsoleLine) { logConsole(target, line) }
+	}
+}
+
+// echoConsole writes a line of the controller to the same stream of the
+// client's process, tagged with the simulation time of the call
+func echoConsole(line utils.ConsoleLine) {
+	var w io.Writer = os.Stdout
+	if line.Stream == "stderr" {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "[t=%g] %s\n", line.Time, line.Text)
+}
+
+// logConsole appends a line of the controller to a log file
+func logConsole(path string, line utils.ConsoleLine) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if consoleErr != nil {
+		return
+	}
+	if consoleFile == nil {
+		if consoleFile, consoleErr = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); consoleErr != nil {
+			logger.Error("Error opening controller output file %s: %v", path, consoleErr)
+			return
+		}
+	}
+	fmt.Fprintf(consoleFile, "[t=%g] %s: %s\n", line.Time, line.Stream, line.Text)
+}
@@ -0,0 +1,127 @@
+package main
+
+import "C"
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/trace"
+)
+
+// Controller library called in-process, nil while calls are forwarded to a server
+var directLib *library.Library
+
+// loadDirectLibrary loads a controller library into the client process
+func loadDirectLibrary(path string) error {
+	bits, err := libraryBits(path)
+	if err != nil {
+		return err
+	}
+	if bits != strconv.IntSize {
+		return fmt.Errorf("%s is a %d-bit library, it can't be loaded by the %d-bit client", path, bits, strconv.IntSize)
+	}
+
+	lib, err := library.Load(0, path, clientConfig.LibProc)
+	if err != nil {
+		return fmt.Errorf("%w '%s' (procedure '%s')", err, path, clientConfig.LibProc)
+	}
+	directLib = lib
+	logger.Debug("Loaded controller library %s in-process", path)
+	return nil
+}
+
+// useFallbackLibrary loads the fallback library if the server couldn't be
+// reached on the first call, and reports whether calls can go to it
+func useFallbackLibrary(connectErr error) bool {
+	if clientConfig.FallbackLib == "" || controllerCalled {
+		return false
+	}
+
+	// A server which rejects the connection was reached, e.g. with an unknown controller
+	var rejected *client.RejectionError
+	if errors.As(connectErr, &rejected) {
+		return false
+	}
+
+	if err := loadDirectLibrary(clientConfig.FallbackLib); err != nil {
+		logger.Error("Fallback library not used: %v", err)
+		return false
+	}
+	logger.LogAtLevel(0, "discon-server unreachable, calling the fallback library %s in-process: %v", clientConfig.FallbackLib, connectErr)
+	return true
+}
+
+// callDirect calls the in-process controller with the caller's arguments
+func callDirect(swap []float32, aviFail *C.int, accInFile, avcOutName, avcMsg *C.char, traceInput trace.Step) {
+	inFileSize := int(swap[49])
+	outNameSize := int(swap[63])
+	msgSize := int(swap[48])
+	inFile := directBuffer(accInFile, inFileSize)
+	outName := directBuffer(avcOutName, outNameSize)
+	msg := directBuffer(avcMsg, msgSize)
+
+	callStart := time.Now()
+	directLib.Call(swap, (*int32)(unsafe.Pointer(aviFail)), inFile, outName, msg)
+	elapsed := time.Since(callStart)
+	controllerCalled = true
+
+	recordCallDuration(elapsed, swap[1])
+	recordCall(elapsed, elapsed)
+	if traceWriter != nil {
+		recordTrace(trace.Call{
+			Start:          callStart,
+			Duration:       elapsed,
+			ControllerTime: elapsed,
+			Input:          traceInput,
+			Output:         trace.NewStep(swap, int32(*aviFail), inFile, outName, msg),
+		})
+	}
+}
+
+// directBuffer returns a caller's string buffer, or an empty string if there is none
+func directBuffer(p *C.char, size int) []byte {
+	if buf := cBuffer(p, size); buf != nil {
+		return buf
+	}
+	return []byte{0}
+}
+
+// libraryBits returns whether a shared library was built for a 32 or 64-bit
+// process, reading the header of an ELF, PE (DLL) or Mach-O file
+func libraryBits(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if f, err := elf.NewFile(file); err == nil {
+		if f.Class == elf.ELFCLASS64 {
+			return 64, nil
+		}
+		return 32, nil
+	}
+	if f, err := pe.NewFile(file); err == nil {
+		if _, ok := f.OptionalHeader.(*pe.OptionalHeader64); ok {
+			return 64, nil
+		}
+		return 32, nil
+	}
+	if f, err := macho.NewFile(file); err == nil {
+		if f.Magic == macho.Magic64 {
+			return 64, nil
+		}
+		return 32, nil
+	}
+	return 0, fmt.Errorf("%s is not a shared library", path)
+}
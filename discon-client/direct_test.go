@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestLibraryBits(t *testing.T) {
+	// The test binary is built for the same architecture as the client
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip("test executable not found:", err)
+	}
+	if bits, err := libraryBits(exe); err != nil || bits != strconv.IntSize {
+		t.Errorf("Expected %d bits, got %d: %v", strconv.IntSize, bits, err)
+	}
+
+	text := filepath.Join(t.TempDir(), "DISCON.IN")
+	os.WriteFile(text, []byte("not a library"), 0644)
+	if _, err := libraryBits(text); err == nil {
+		t.Error("Expected a text file to be rejected")
+	}
+}
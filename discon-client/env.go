@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+)
+
+// envHeader returns the handshake header forwarding the variables, only their
+// names are logged as values may hold credentials
+func envHeader(config *client.Config) http.Header {
+	env, blocked := config.ForwardedEnv()
+	for _, name := range blocked {
+		logger.Error("Environment variable %s can't be forwarded to the controller, skipping", name)
+	}
+
+	header := http.Header{}
+	for _, pair := range env {
+		header.Add(utils.EnvHeader, pair)
+		name, _, _ := strings.Cut(pair, "=")
+		logger.Debug("Forwarding environment variable %s to the controller", name)
+	}
+	return header
+}
@@ -0,0 +1,66 @@
+package main
+
+/*
+#ifdef _WIN32
+#include <windows.h>
+
+// Returns the path of the module (DLL) containing this function
+static int client_library_path(char *buf, int size)
+{
+    HMODULE module = NULL;
+    if (!GetModuleHandleExA(GET_MODULE_HANDLE_EX_FLAG_FROM_ADDRESS | GET_MODULE_HANDLE_EX_FLAG_UNCHANGED_REFCOUNT,
+                            (LPCSTR)&client_library_path, &module))
+    {
+        return 0;
+    }
+    return (int)GetModuleFileNameA(module, buf, (DWORD)size);
+}
+#else
+#define _GNU_SOURCE
+#include <dlfcn.h>
+#include <string.h>
+
+// Returns the path of the shared object containing this function
+static int client_library_path(char *buf, int size)
+{
+    Dl_info info;
+    if (!dladdr((void *)&client_library_path, &info) || info.dli_fname == NULL)
+    {
+        return 0;
+    }
+    strncpy(buf, info.dli_fname, size - 1);
+    buf[size - 1] = '\0';
+    return (int)strlen(buf);
+}
+#endif
+*/
+import "C"
+
+import (
+	"path/filepath"
+	"unsafe"
+)
+
+// clientLibraryPath returns the absolute path of the loaded discon-client
+// library, or an empty string if it cannot be determined
+func clientLibraryPath() string {
+	buf := make([]byte, 4096)
+	n := C.client_library_path((*C.char)(unsafe.Pointer(&buf[0])), C.int(len(buf)))
+	if n <= 0 {
+		return ""
+	}
+	path, err := filepath.Abs(string(buf[:n]))
+	if err != nil {
+		return string(buf[:n])
+	}
+	return path
+}
+
+// clientLibraryDir returns the directory configuration files are searched in,
+// or an empty string if the library path cannot be determined
+func clientLibraryDir() string {
+	if path := clientLibraryPath(); path != "" {
+		return filepath.Dir(path)
+	}
+	return ""
+}
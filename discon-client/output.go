@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// outputsEnabled reports whether files written by the controller are retrieved
+func outputsEnabled() bool {
+	return clientConfig.Output.Dir != ""
+}
+
+// sessionOutName returns the controller output root name moved into the
+// session directory, so that files the controller writes next to it are
+// tracked by the server. An empty string is returned if there is no name.
+func sessionOutName(outName string) (string, error) {
+	// OpenFAST may pass a path with either separator, only the name is kept
+	name := outName[strings.LastIndexAny(outName, `/\`)+1:]
+	if name == "" {
+		return "", nil
+	}
+	dir, err := session.Dir(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, name), nil
+}
+
+// retrieveOutputs downloads the files created or modified by the controller
+// in the session directory which match the output globs
+func retrieveOutputs() error {
+	output := clientConfig.Output
+	_, err := session.RetrieveOutputs(context.Background(), output.Dir, output.Include, output.Exclude)
+	return err
+}
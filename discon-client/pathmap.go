@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"discon-wrapper/shared/utils"
+)
+
+// mappedFile is a file the server reaches through a path mapping, which is
+// confirmed to exist on the server by the next flushUploads
+type mappedFile struct {
+	filePath   string
+	serverPath string
+}
+
+// Files mapped since the last flushUploads
+var pendingMapped []mappedFile
+
+// mapServerPath returns the server path of a local file under one of the
+// mapped prefixes. Separators are normalised, the mapped path uses backslashes
+// only if the server prefix does.
+func mapServerPath(filePath string) (string, bool) {
+	if clientConfig == nil || len(clientConfig.PathMap) == 0 {
+		return "", false
+	}
+
+	local := filePath
+	if !isWindowsPath(local) {
+		if abs, err := filepath.Abs(local); err == nil {
+			local = abs
+		}
+	}
+	local = toSlash(local)
+
+	for _, mapping := range clientConfig.PathMap {
+		rest, ok := cutPathPrefix(local, strings.TrimRight(toSlash(mapping.From), "/"))
+		if !ok {
+			continue
+		}
+		mapped := strings.TrimRight(toSlash(mapping.To), "/") + rest
+		if strings.Contains(mapping.To, `\`) && !strings.Contains(mapping.To, "/") {
+			mapped = strings.ReplaceAll(mapped, "/", `\`)
+		}
+		if mapped == "" {
+			mapped = "/"
+		}
+		return mapped, true
+	}
+	return "", false
+}
+
+// cutPathPrefix removes a prefix ending at a path separator. Windows paths
+// are compared without regard to case.
+func cutPathPrefix(p, prefix string) (string, bool) {
+	if len(p) < len(prefix) {
+		return "", false
+	}
+	head, rest := p[:len(prefix)], p[len(prefix):]
+	if head != prefix && !(isWindowsPath(prefix) && strings.EqualFold(head, prefix)) {
+		return "", false
+	}
+	if rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+// isWindowsPath reports whether the path starts with a drive letter or is a UNC path
+func isWindowsPath(p string) bool {
+	if len(p) >= 2 && p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z') {
+		return true
+	}
+	return strings.HasPrefix(p, `\\`) || strings.HasPrefix(p, "//")
+}
+
+// toSlash replaces backslashes with forward slashes on any platform
+func toSlash(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// sendMappedFile returns the server path of a file under a mapped prefix and
+// queues it to be confirmed, instead of uploading it. Files in the bundle are
+// sent with the bundle.
+func sendMappedFile(filePath string) (string, bool) {
+	if _, inBundle := bundledFiles[fileKey(filePath)]; inBundle {
+		return "", false
+	}
+	serverPath, ok := mapServerPath(filePath)
+	if !ok {
+		return "", false
+	}
+	logger.Verbose("Mapped %s to server path %s", filePath, serverPath)
+	pendingMapped = append(pendingMapped, mappedFile{filePath, serverPath})
+	return serverPath, true
+}
+
+// verifyMappedFiles asks the server to confirm that the mapped files exist
+func verifyMappedFiles() error {
+	if len(pendingMapped) == 0 {
+		return nil
+	}
+	pending := pendingMapped
+	pendingMapped = nil
+
+	msg := &utils.ControlMessage{Type: utils.ControlStat}
+	for _, file := range pending {
+		msg.Files = append(msg.Files, file.serverPath)
+	}
+	response, _, err := session.Control(context.Background(), msg, nil)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	for _, entry := range response.Entries {
+		found[entry.Path] = true
+	}
+	for _, file := range pending {
+		if !found[file.serverPath] {
+			return fmt.Errorf("%s is mapped to %s, which does not exist on the server", file.filePath, file.serverPath)
+		}
+		logger.Debug("File %s is reached by the server at %s", file.filePath, file.serverPath)
+		serverFilePaths[fileKey(file.filePath)] = file.serverPath
+	}
+	return nil
+}
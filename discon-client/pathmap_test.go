@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"discon-wrapper/client"
+)
+
+func TestMapServerPath(t *testing.T) {
+	clientConfig = client.NewDefaultConfig()
+	clientConfig.PathMap = []client.PathMapping{
+		{From: `Z:\cases`, To: "/mnt/cases"},
+		{From: "/data/share/", To: `\\fileserver\share`},
+	}
+
+	tests := []struct {
+		local  string
+		mapped string
+		ok     bool
+	}{
+		{`Z:\cases\DISCON.IN`, "/mnt/cases/DISCON.IN", true},
+		{`z:\CASES\sub\Cp.txt`, "/mnt/cases/sub/Cp.txt", true},
+		{`Z:\cases`, "/mnt/cases", true},
+		{`Z:\cases2\DISCON.IN`, "", false},
+		{"/data/share/run1/DISCON.IN", `\\fileserver\share\run1\DISCON.IN`, true},
+		{"/Data/share/run1/DISCON.IN", "", false},
+		{"/tmp/DISCON.IN", "", false},
+	}
+	for _, test := range tests {
+		mapped, ok := mapServerPath(test.local)
+		if mapped != test.mapped || ok != test.ok {
+			t.Errorf("mapServerPath(%q) = %q, %v, expected %q, %v", test.local, mapped, ok, test.mapped, test.ok)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"discon-wrapper/shared/utils"
+)
+
+// Default maximum depth when following references between input files
+const defaultDiscoveryDepth = 4
+
+// Files larger than this are transferred but not parsed for references
+const maxParsedFileSize = 16 * 1024 * 1024
+
+// Reference is a value token in an input file that names another file
+type Reference struct {
+	Key   string // Name of the parameter, e.g. PerfFileName
+	Value string // Value of the token without quotes
+	Quote byte   // Quote around the value, 0 if unquoted
+	Start int    // Offset of the first byte of the value in the file content
+	End   int    // Offset one past the last byte of the value in the file content
+}
+
+// InputParser finds references to other files in a controller input file
+type InputParser interface {
+	// Name returns a short name of the parser for logging
+	Name() string
+	// Accepts reports whether the parser understands the given file
+	Accepts(path string, content []byte) bool
+	// References returns the value tokens which may name other files
+	References(content []byte) []Reference
+}
+
+// Registered input parsers, tried in order until one accepts the file
+var inputParsers = []InputParser{&keyValueParser{}}
+
+// RegisterInputParser adds a parser which is tried before the built-in parsers
+func RegisterInputParser(parser InputParser) {
+	inputParsers = append([]InputParser{parser}, inputParsers...)
+}
+
+// parserFor returns the first registered parser which accepts the file
+func parserFor(path string, content []byte) InputParser {
+	for _, parser := range inputParsers {
+		if parser.Accepts(path, content) {
+			return parser
+		}
+	}
+	return nil
+}
+
+// keyValueParser parses DISCON.IN and OpenFAST style input files, where each
+// line holds a value followed by the parameter name and a description, e.g.
+//
+//	"Cp_Ct_Cq.txt"    ! PerfFileName  - Name of the rotor performance file
+type keyValueParser struct{}
+
+func (p *keyValueParser) Name() string {
+	return "key-value"
+}
+
+// Accepts any text file, binary files are recognised by null bytes
+func (p *keyValueParser) Accepts(path string, content []byte) bool {
+	return bytes.IndexByte(content, 0) < 0
+}
+
+func (p *keyValueParser) References(content []byte) []Reference {
+	var refs []Reference
+
+	offset := 0
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		lineStart := offset
+		offset += len(line)
+
+		// Skip leading whitespace
+		i := 0
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) || line[i] == '!' || line[i] == '#' || line[i] == '-' || line[i] == '\r' || line[i] == '\n' {
+			continue
+		}
+
+		// Read the value token, which may be quoted
+		var start, end int
+		var quote byte
+		if line[i] == '"' || line[i] == '\'' {
+			quote = line[i]
+			start = i + 1
+			end = bytes.IndexByte(line[start:], quote)
+			if end < 0 {
+				continue
+			}
+			end += start
+			i = end + 1
+		} else {
+			start = i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			end = i
+		}
+
+		value := string(line[start:end])
+		if !looksLikePath(value) {
+			continue
+		}
+
+		// The parameter name is the next word, possibly after a comment marker
+		rest := strings.TrimLeft(string(line[i:]), " \t!")
+		key := ""
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			key = fields[0]
+		}
+
+		refs = append(refs, Reference{
+			Key:   key,
+			Value: value,
+			Quote: quote,
+			Start: lineStart + start,
+			End:   lineStart + end,
+		})
+	}
+
+	return refs
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == ','
+}
+
+// looksLikePath filters out values which can't be file names such as numbers and flags
+func looksLikePath(value string) bool {
+	if value == "" || strings.ContainsAny(value, "\x00\n") {
+		return false
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "t", "f", "default", "none", "unused":
+		return false
+	}
+	return true
+}
+
+// discoveredFile is a file found by following references from the primary input file
+type discoveredFile struct {
+	LocalPath  string
+	ServerPath string
+	Size       int
+	Parser     string
+	Mapped     bool     // Reached by the server through a path mapping
+	Rewrites   []string // Description of the rewritten value tokens
+}
+
+// fileSender sends (or pretends to send) file content and returns the server path
+type fileSender func(filePath string, content []byte) (string, error)
+
+// referenceWalker transfers an input file and the files it references,
+// rewriting the references to point at the server paths
+type referenceWalker struct {
+	maxDepth    int
+	send        fileSender
+	sendBundled fileSender                           // Replaces a file which was sent in the bundle
+	bundled     map[string]string                    // Names in the bundle of the bundled files, by file key
+	bundledPath func(filePath string) (string, bool) // Server path of a bundled file
+	visiting    map[string]bool
+	done        map[string]string
+	files       []*discoveredFile
+}
+
+func newReferenceWalker(maxDepth int, send, sendBundled fileSender) *referenceWalker {
+	if maxDepth <= 0 {
+		maxDepth = defaultDiscoveryDepth
+	}
+	return &referenceWalker{
+		maxDepth:    maxDepth,
+		send:        send,
+		sendBundled: sendBundled,
+		bundled:     bundledFiles,
+		bundledPath: bundledServerPath,
+		visiting:    make(map[string]bool),
+		done:        make(map[string]string),
+	}
+}
+
+// transfer sends the file after transferring every file it references
+func (w *referenceWalker) transfer(filePath string, depth int) (string, error) {
+	key := fileKey(filePath)
+	if serverPath, ok := w.done[key]; ok {
+		return serverPath, nil
+	}
+	if serverPath, ok := serverFilePaths[key]; ok {
+		return serverPath, nil
+	}
+
+	// The server reads mapped files, and the files they reference, itself
+	_, inBundle := w.bundled[key]
+	if !inBundle {
+		if serverPath, ok := sendMappedFile(filePath); ok {
+			w.files = append(w.files, &discoveredFile{LocalPath: filePath, ServerPath: serverPath, Mapped: true})
+			w.done[key] = serverPath
+			return serverPath, nil
+		}
+	}
+
+	content, err := readTransferFile(filePath, w.bundled)
+	if err != nil {
+		return "", err
+	}
+
+	file := &discoveredFile{LocalPath: filePath}
+	w.visiting[key] = true
+
+	if depth < w.maxDepth && len(content) <= maxParsedFileSize {
+		if parser := parserFor(filePath, content); parser != nil {
+			file.Parser = parser.Name()
+			content, err = w.rewriteReferences(filePath, content, parser.References(content), depth, file, inBundle)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	delete(w.visiting, key)
+
+	var serverPath string
+	if inBundle && len(file.Rewrites) == 0 {
+		// Already on the server with the original content
+		serverPath, _ = w.bundledPath(filePath)
+		serverFilePaths[key] = serverPath
+	} else if inBundle {
+		serverPath, err = w.sendBundled(filePath, content)
+	} else {
+		serverPath, err = w.send(filePath, content)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	file.ServerPath = serverPath
+	file.Size = len(content)
+	w.files = append(w.files, file)
+	w.done[key] = serverPath
+	return serverPath, nil
+}
+
+// rewriteReferences transfers the referenced files and replaces only their value tokens
+func (w *referenceWalker) rewriteReferences(filePath string, content []byte, refs []Reference, depth int, file *discoveredFile, inBundle bool) ([]byte, error) {
+	type replacement struct {
+		ref        Reference
+		serverPath string
+	}
+	var replacements []replacement
+
+	for _, ref := range refs {
+		refPath := resolveReference(filePath, ref.Value)
+		if refPath == "" {
+			continue
+		}
+		if w.visiting[fileKey(refPath)] {
+			// Circular reference, leave the token unchanged
+			logger.Debug("Skipping circular reference to %s in %s", refPath, filePath)
+			continue
+		}
+
+		serverPath, err := w.transfer(refPath, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transfer %s referenced by %s in %s: %w", refPath, ref.Key, filePath, err)
+		}
+
+		// Relative paths between bundled files are preserved on the server,
+		// so the reference only changes if it leaves the bundle or is absolute
+		if _, targetInBundle := w.bundled[fileKey(refPath)]; inBundle && targetInBundle && !filepath.IsAbs(ref.Value) {
+			continue
+		}
+
+		replacements = append(replacements, replacement{ref, serverPath})
+	}
+
+	// Replace from the end so that earlier offsets remain valid
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].ref.Start > replacements[j].ref.Start
+	})
+	for _, r := range replacements {
+		// Server paths may contain spaces, so an unquoted value is quoted
+		rewritten := make([]byte, 0, len(content)+len(r.serverPath)+2)
+		rewritten = append(rewritten, content[:r.ref.Start]...)
+		if r.ref.Quote == 0 {
+			rewritten = append(append(append(rewritten, '"'), r.serverPath...), '"')
+		} else {
+			rewritten = append(rewritten, r.serverPath...)
+		}
+		rewritten = append(rewritten, content[r.ref.End:]...)
+		content = rewritten
+
+		file.Rewrites = append(file.Rewrites, fmt.Sprintf("%s: %s -> %s", r.ref.Key, r.ref.Value, r.serverPath))
+		logger.Verbose("Replaced %s reference %s with %s in %s", r.ref.Key, r.ref.Value, r.serverPath, filePath)
+	}
+
+	return content, nil
+}
+
+// resolveReference returns the local path of a referenced file, relative
+// paths are resolved against the directory of the referencing file. If the
+// file doesn't exist, an additional file with the same name is used instead.
+// An empty string is returned if the reference doesn't name a local file.
+func resolveReference(fromFile, value string) string {
+	candidate := value
+	if !filepath.IsAbs(candidate) {
+		candidate = filepath.Join(filepath.Dir(fromFile), candidate)
+	}
+	if utils.FileExists(candidate) {
+		if fileKey(candidate) == fileKey(fromFile) {
+			return ""
+		}
+		return candidate
+	}
+
+	// Additional files may be referenced by name only
+	if clientConfig != nil {
+		for _, additionalFile := range clientConfig.AdditionalFiles {
+			if filepath.Base(additionalFile) == filepath.Base(value) && utils.FileExists(additionalFile) {
+				return additionalFile
+			}
+		}
+	}
+
+	return ""
+}
+
+// fileKey normalises a path so that the same file is only transferred once
+func fileKey(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return filepath.Clean(abs)
+	}
+	return filepath.Clean(filePath)
+}
+
+// transferInputFile sends the primary input file and, unless discovery is
+// disabled, every file it references
+func transferInputFile(inFilePath string) (string, error) {
+	if clientConfig.Discovery.Disabled {
+		if serverPath, ok := bundledServerPath(inFilePath); ok {
+			return serverPath, nil
+		}
+		if serverPath, ok := sendMappedFile(inFilePath); ok {
+			return serverPath, flushUploads()
+		}
+		serverPath, err := sendFileToServer(inFilePath)
+		if err != nil {
+			return "", err
+		}
+		return serverPath, flushUploads()
+	}
+
+	walker := newReferenceWalker(clientConfig.Discovery.MaxDepth, sendFileContent, sendBundledFile)
+	serverPath, err := walker.transfer(inFilePath, 0)
+	if err != nil {
+		return "", err
+	}
+	if err := flushUploads(); err != nil {
+		return "", err
+	}
+
+	for _, file := range walker.files {
+		if fileKey(file.LocalPath) != fileKey(inFilePath) {
+			logger.Debug("Discovered referenced file %s, transferred to %s", file.LocalPath, file.ServerPath)
+		}
+	}
+
+	return serverPath, nil
+}
+
+// discoveryReport returns a description of the files that would be sent for
+// the primary input file without transferring anything
+func discoveryReport(inFilePath string) (string, int, error) {
+	var report strings.Builder
+	count := 0
+
+	// Additional files are sent first, followed by the input file and its references
+	// The session directory only exists on the server, a placeholder stands in
+	// for it without touching the state of a real session
+	const session = "<session>"
+	dryRunSender := func(filePath string, content []byte) (string, error) {
+		serverPath := utils.GenerateServerFilePath(content, filePath)
+		if cacheEnabled() {
+			serverPath = path.Join(session, serverPath)
+		}
+		return serverPath, nil
+	}
+
+	// The bundled files are collected in a set of the dry run's own, bundledFiles
+	// only holds the files a real session placed on the server
+	bundled := make(map[string]string)
+	dryRunBundledPath := func(filePath string) (string, bool) {
+		rel, ok := bundled[fileKey(filePath)]
+		return path.Join(session, rel), ok
+	}
+	dryRunBundledSender := func(filePath string, content []byte) (string, error) {
+		serverPath, _ := dryRunBundledPath(filePath)
+		return serverPath, nil
+	}
+	walker := newReferenceWalker(clientConfig.Discovery.MaxDepth, dryRunSender, dryRunBundledSender)
+	walker.bundled = bundled
+	walker.bundledPath = dryRunBundledPath
+
+	fmt.Fprintf(&report, "%s dry run, files that would be sent to %s:\n", program, clientConfig.ServerAddr)
+
+	// The bundle is unpacked into a session directory which only exists on the server
+	if root := bundleRoot(); root != "" {
+		files, err := utils.CollectBundleFiles(root, clientConfig.Bundle.Include, clientConfig.Bundle.Exclude)
+		if err != nil {
+			return "", 0, err
+		}
+		var size int64
+		for _, file := range files {
+			size += file.Size
+		}
+		fmt.Fprintf(&report, "  %s (bundle of %d files, %d bytes) -> %s\n", root, len(files), size, session)
+		for _, file := range files {
+			bundled[fileKey(file.Path)] = file.Name
+			fmt.Fprintf(&report, "      %s\n", file.Name)
+		}
+		count += len(files)
+	}
+
+	for _, filePath := range clientConfig.AdditionalFiles {
+		if _, ok := bundled[fileKey(filePath)]; ok {
+			continue
+		}
+		content, err := readTransferFile(filePath, bundled)
+		if err != nil {
+			return "", 0, fmt.Errorf("additional file %s: %w", filePath, err)
+		}
+		serverPath, _ := dryRunSender(filePath, content)
+		walker.done[fileKey(filePath)] = serverPath
+		fmt.Fprintf(&report, "  %s (%d bytes, additional file) -> %s\n", filePath, len(content), serverPath)
+		if transforms := appliedTransforms[fileKey(filePath)]; transforms != "" {
+			fmt.Fprintf(&report, "      transform %s\n", transforms)
+		}
+		count++
+	}
+
+	if inFilePath != "" && utils.FileExists(inFilePath) {
+		if serverPath, ok := dryRunBundledPath(inFilePath); ok && clientConfig.Discovery.Disabled {
+			walker.files = append(walker.files, &discoveredFile{LocalPath: inFilePath, ServerPath: serverPath})
+		} else if serverPath, ok := mapServerPath(inFilePath); ok && clientConfig.Discovery.Disabled {
+			walker.files = append(walker.files, &discoveredFile{LocalPath: inFilePath, ServerPath: serverPath, Mapped: true})
+		} else if clientConfig.Discovery.Disabled {
+			content, err := readTransferFile(inFilePath, bundled)
+			if err != nil {
+				return "", 0, err
+			}
+			serverPath, _ := dryRunSender(inFilePath, content)
+			walker.files = append(walker.files, &discoveredFile{LocalPath: inFilePath, ServerPath: serverPath, Size: len(content)})
+		} else if _, err := walker.transfer(inFilePath, 0); err != nil {
+			return "", 0, err
+		}
+	}
+
+	for _, file := range walker.files {
+		kind := "referenced file"
+		if fileKey(file.LocalPath) == fileKey(inFilePath) {
+			kind = "input file"
+		}
+		if _, ok := bundled[fileKey(file.LocalPath)]; ok {
+			if len(file.Rewrites) == 0 {
+				continue
+			}
+			kind += ", replaced in bundle"
+		}
+		if file.Mapped {
+			fmt.Fprintf(&report, "  %s (%s, mapped, not sent) -> %s\n", file.LocalPath, kind, file.ServerPath)
+			continue
+		}
+		fmt.Fprintf(&report, "  %s (%d bytes, %s) -> %s\n", file.LocalPath, file.Size, kind, file.ServerPath)
+		if transforms := appliedTransforms[fileKey(file.LocalPath)]; transforms != "" {
+			fmt.Fprintf(&report, "      transform %s\n", transforms)
+		}
+		for _, rewrite := range file.Rewrites {
+			fmt.Fprintf(&report, "      rewrite %s\n", rewrite)
+		}
+		count++
+	}
+
+	return report.String(), count, nil
+}
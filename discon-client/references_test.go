@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+)
+
+func TestReferenceWalker(t *testing.T) {
+	logger = utils.NewDebugLogger(0, "discon-client")
+	clientConfig = client.NewDefaultConfig()
+
+	// Create an input file referencing a table in a sub-directory
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "tables"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"! Controller parameters",
+		"1                   ! LoggingLevel  - (0: write no debug files)",
+		`"tables/Cp.txt"     ! PerfFileName  - File containing Cp.txt tables`,
+		`"unused"            ! OL_Filename   - Not a file`,
+		"tables/Ct.txt       ! CtFileName    - Unquoted file name",
+		"",
+	}, "\n")
+	inFile := filepath.Join(dir, "DISCON.IN")
+	if err := os.WriteFile(inFile, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"Cp.txt", "Ct.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, "tables", table), []byte("0.1 0.2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Record the content sent for each file instead of transferring it
+	sent := make(map[string]string)
+	walker := newReferenceWalker(0, func(filePath string, content []byte) (string, error) {
+		sent[filepath.Base(filePath)] = string(content)
+		return "server dir/" + filepath.Base(filePath), nil
+	}, nil)
+
+	serverPath, err := walker.transfer(inFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverPath != "server dir/DISCON.IN" {
+		t.Errorf("Expected server path 'server dir/DISCON.IN', got '%s'", serverPath)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 files to be sent, got %d", len(sent))
+	}
+
+	// Only the value token should be rewritten, not the description, and
+	// unquoted values are quoted as the server path may contain spaces
+	expected := strings.Replace(input, `"tables/Cp.txt"`, `"server dir/Cp.txt"`, 1)
+	expected = strings.Replace(expected, `tables/Ct.txt`, `"server dir/Ct.txt"`, 1)
+	if sent["DISCON.IN"] != expected {
+		t.Errorf("Unexpected rewritten input file:\n%s", sent["DISCON.IN"])
+	}
+}
+
+func TestDiscoveryReportBundle(t *testing.T) {
+	logger = utils.NewDebugLogger(0, "discon-client")
+	clientConfig = client.NewDefaultConfig()
+
+	// Bundle a directory holding the input file and the table it references
+	dir := t.TempDir()
+	input := `"Cp.txt"    ! PerfFileName  - File containing Cp.txt tables` + "\n"
+	inFile := filepath.Join(dir, "DISCON.IN")
+	if err := os.WriteFile(inFile, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Cp.txt"), []byte("0.1 0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.Bundle.Root = dir
+
+	report, count, err := discoveryReport(inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 files in the report, got %d:\n%s", count, report)
+	}
+
+	// The dry run must not leave bundled files behind for a real session
+	if len(bundledFiles) != 0 {
+		t.Errorf("Expected the dry run to leave bundledFiles empty, got %v", bundledFiles)
+	}
+}
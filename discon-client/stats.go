@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+
+	"discon-wrapper/client"
+)
+
+// Durations are counted in buckets whose bounds grow by statsBucketGrowth from
+// one microsecond, so the percentiles are within 2% of the measured durations
+// however long the simulation runs. The last bucket holds everything above
+// about five hours.
+const (
+	statsBuckets      = 1200
+	statsBucketGrowth = 1.02
+)
+
+// durationStats collects the durations of one kind of request
+type durationStats struct {
+	count   int
+	total   time.Duration
+	max     time.Duration
+	buckets [statsBuckets]int
+}
+
+func (s *durationStats) add(d time.Duration) {
+	s.count++
+	s.total += d
+	s.max = max(s.max, d)
+	s.buckets[statsBucket(d)]++
+}
+
+// statsBucket returns the bucket of a duration, bucket i > 0 holds the
+// durations below the bound of bucket i and at or above that of bucket i-1
+func statsBucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	i := 1 + int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(statsBucketGrowth))
+	return min(i, statsBuckets-1)
+}
+
+// statsBucketBound returns the upper bound of a bucket
+func statsBucketBound(i int) time.Duration {
+	return time.Duration(float64(time.Microsecond) * math.Pow(statsBucketGrowth, float64(i)))
+}
+
+// statsSummary summarises durations in milliseconds
+type statsSummary struct {
+	Count   int     `json:"count"`
+	TotalMs float64 `json:"total_ms"`
+	MeanMs  float64 `json:"mean_ms"`
+	P50Ms   float64 `json:"p50_ms"`
+	P95Ms   float64 `json:"p95_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+// summary returns the count, total, mean, nearest-rank percentiles and maximum.
+// A percentile is the upper bound of its bucket, limited to the maximum, or the
+// maximum for the last bucket.
+func (s *durationStats) summary() statsSummary {
+	n := s.count
+	if n == 0 {
+		return statsSummary{}
+	}
+	percentile := func(p float64) float64 {
+		rank := max(int(math.Ceil(p/100*float64(n))), 1)
+		for i, count := range s.buckets {
+			if rank -= count; rank <= 0 && i < statsBuckets-1 {
+				return milliseconds(min(statsBucketBound(i), s.max))
+			}
+		}
+		return milliseconds(s.max)
+	}
+	return statsSummary{
+		Count:   n,
+		TotalMs: milliseconds(s.total),
+		MeanMs:  milliseconds(s.total) / float64(n),
+		P50Ms:   percentile(50),
+		P95Ms:   percentile(95),
+		P99Ms:   percentile(99),
+		MaxMs:   milliseconds(s.max),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// statsReport is the summary written to the log and the statistics file
+type statsReport struct {
+	Program       string       `json:"program"`
+	Version       string       `json:"version"`
+	RoundTrip     statsSummary `json:"round_trip"` // Controller calls as seen by the client
+	Controller    statsSummary `json:"controller"` // Time spent in the controller on the server
+	Overhead      statsSummary `json:"overhead"`   // Round trip minus controller time, added by the wrapper
+	Transfer      statsSummary `json:"transfer"`   // File transfer and other control requests
+	BytesSent     int64        `json:"bytes_sent"`
+	BytesReceived int64        `json:"bytes_received"`
+}
+
+// The statistics file is rewritten at this interval during the run, so that it
+// holds the statistics up to then should the process exit without ending the run
+const statsWriteInterval = 10 * time.Second
+
+// Statistics of the calls and transfers of this process
+var (
+	roundTripStats  durationStats
+	controllerStats durationStats
+	overheadStats   durationStats
+	transferStats   durationStats
+	bytesSent       int64
+	bytesReceived   int64
+	statsWritten    time.Time // When the statistics file was last written
+)
+
+// recordCall adds the round trip of a controller call and the time the
+// server reported for the controller itself
+func recordCall(roundTrip time.Duration, controller time.Duration) {
+	roundTripStats.add(roundTrip)
+	controllerStats.add(controller)
+	overheadStats.add(max(roundTrip-controller, 0))
+
+	if time.Since(statsWritten) >= statsWriteInterval {
+		writeStatsFile(newStatsReport())
+	}
+}
+
+// recordExchange counts the bytes of every request to the server and adds the
+// round trip of control requests, controller calls are timed by DISCON
+func recordExchange(e client.Exchange) {
+	bytesSent += int64(e.Sent)
+	bytesReceived += int64(e.Received)
+	if e.Control {
+		transferStats.add(e.Duration)
+	}
+}
+
+// newStatsReport summarises the statistics collected so far
+func newStatsReport() statsReport {
+	return statsReport{
+		Program:       program,
+		Version:       version,
+		RoundTrip:     roundTripStats.summary(),
+		Controller:    controllerStats.summary(),
+		Overhead:      overheadStats.summary(),
+		Transfer:      transferStats.summary(),
+		BytesSent:     bytesSent,
+		BytesReceived: bytesReceived,
+	}
+}
+
+// reportStats logs the statistics summary and writes it to the statistics
+// file, if one is configured
+func reportStats() {
+	if roundTripStats.count == 0 && transferStats.count == 0 {
+		return
+	}
+	report := newStatsReport()
+
+	for _, line := range []struct {
+		name    string
+		summary statsSummary
+	}{
+		{"round trip", report.RoundTrip},
+		{"controller", report.Controller},
+		{"overhead", report.Overhead},
+		{"transfer", report.Transfer},
+	} {
+		s := line.summary
+		logger.LogAtLevel(0, "Stats %-10s: %d, total %.1f ms, mean %.3f ms, p50 %.3f ms, p95 %.3f ms, p99 %.3f ms, max %.3f ms",
+			line.name, s.Count, s.TotalMs, s.MeanMs, s.P50Ms, s.P95Ms, s.P99Ms, s.MaxMs)
+	}
+	logger.LogAtLevel(0, "Stats bytes     : %d sent, %d received", bytesSent, bytesReceived)
+
+	writeStatsFile(report)
+}
+
+// writeStatsFile replaces the statistics file, if one is configured, through a
+// temporary file so that a reader never sees a partly written file
+func writeStatsFile(report statsReport) {
+	file := clientConfig.Logging.StatsFile
+	if file == "" {
+		return
+	}
+	statsWritten = time.Now()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(file+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		logger.Error("Error writing statistics file %s: %v", file, err)
+	}
+}
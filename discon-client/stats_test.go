@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+)
+
+func TestDurationStatsSummary(t *testing.T) {
+	var stats durationStats
+	for i := 100; i >= 1; i-- {
+		stats.add(time.Duration(i) * time.Millisecond)
+	}
+
+	// Percentiles are bucketed, within 2% above the nearest-rank value
+	summary := stats.summary()
+	expected := statsSummary{Count: 100, TotalMs: 5050, MeanMs: 50.5, P50Ms: 50, P95Ms: 95, P99Ms: 99, MaxMs: 100}
+	percentiles := [][2]float64{{summary.P50Ms, expected.P50Ms}, {summary.P95Ms, expected.P95Ms}, {summary.P99Ms, expected.P99Ms}}
+	for _, p := range percentiles {
+		if p[0] < p[1] || p[0] > p[1]*statsBucketGrowth {
+			t.Errorf("Expected percentile within 2%% above %g ms, got %g ms", p[1], p[0])
+		}
+	}
+	summary.P50Ms, summary.P95Ms, summary.P99Ms = expected.P50Ms, expected.P95Ms, expected.P99Ms
+	if summary != expected {
+		t.Errorf("Expected %+v, got %+v", expected, summary)
+	}
+
+	if empty := (&durationStats{}).summary(); empty != (statsSummary{}) {
+		t.Errorf("Expected empty summary, got %+v", empty)
+	}
+}
+
+func TestDurationStatsBuckets(t *testing.T) {
+	var stats durationStats
+	stats.add(0)
+	stats.add(500 * time.Nanosecond)
+	stats.add(24 * time.Hour)
+
+	// Durations outside the bucket range are kept in the first and last bucket,
+	// the maximum stays exact
+	if stats.buckets[0] != 2 || stats.buckets[statsBuckets-1] != 1 {
+		t.Errorf("Expected 2 durations in the first and 1 in the last bucket, got %d and %d", stats.buckets[0], stats.buckets[statsBuckets-1])
+	}
+	summary := stats.summary()
+	if summary.MaxMs != milliseconds(24*time.Hour) || summary.P99Ms != summary.MaxMs {
+		t.Errorf("Expected p99 and maximum of %g ms, got %+v", milliseconds(24*time.Hour), summary)
+	}
+
+	for _, d := range []time.Duration{time.Microsecond, 3 * time.Millisecond, 7 * time.Second} {
+		bound := statsBucketBound(statsBucket(d))
+		if bound <= d || float64(bound) > float64(d)*statsBucketGrowth+1 {
+			t.Errorf("Expected bucket bound of %s within 2%% above it, got %s", d, bound)
+		}
+	}
+}
+
+func TestStatsFileWrittenDuringRun(t *testing.T) {
+	logger = utils.NewDebugLogger(0, "discon-client")
+	clientConfig = client.NewDefaultConfig()
+	clientConfig.Logging.StatsFile = filepath.Join(t.TempDir(), "stats.json")
+	t.Cleanup(func() {
+		roundTripStats, controllerStats, overheadStats = durationStats{}, durationStats{}, durationStats{}
+		statsWritten = time.Time{}
+	})
+
+	// The file is written on the first call, without waiting for the end of the run
+	recordCall(2*time.Millisecond, time.Millisecond)
+	readCount := func() int {
+		data, err := os.ReadFile(clientConfig.Logging.StatsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report statsReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		return report.RoundTrip.Count
+	}
+	if count := readCount(); count != 1 {
+		t.Errorf("Expected 1 call in the statistics file, got %d", count)
+	}
+
+	// Later calls are written once the interval has passed
+	recordCall(2*time.Millisecond, time.Millisecond)
+	if count := readCount(); count != 1 {
+		t.Errorf("Expected the statistics file to be rewritten after the interval, got %d calls", count)
+	}
+	statsWritten = time.Now().Add(-statsWriteInterval)
+	recordCall(2*time.Millisecond, time.Millisecond)
+	if count := readCount(); count != 3 {
+		t.Errorf("Expected 3 calls in the statistics file, got %d", count)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/trace"
+)
+
+// Trace written when debugging is enabled without a trace file being named
+const defaultTraceFile = "discon_trace" + trace.FileExt
+
+// Writer of the trace of the controller calls, nil if tracing is disabled
+var traceWriter *trace.Writer
+
+// openTrace creates the trace file named in the configuration or, if debugging
+// is enabled, the default trace file
+func openTrace(config *client.Config) error {
+	path := config.Logging.Trace
+	if path == "" && config.Logging.Level > 0 {
+		path = defaultTraceFile
+	}
+	if path == "" {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	meta := trace.Metadata{
+		Program:           program,
+		Version:           version,
+		Source:            "client",
+		Created:           time.Now(),
+		Host:              host,
+		PID:               os.Getpid(),
+		ServerAddr:        config.ServerAddr,
+		LibPath:           config.LibPath,
+		LibProc:           config.LibProc,
+		ControllerID:      config.ControllerID,
+		ControllerVersion: config.ControllerVersion,
+		Profile:           config.ProfileName,
+	}
+	var err error
+	traceWriter, err = trace.Create(path, meta)
+	if err != nil {
+		return fmt.Errorf("error creating trace file: %w", err)
+	}
+	logger.Debug("Writing trace of the controller calls to %s", path)
+	return nil
+}
+
+// recordTrace appends a controller call to the trace, tracing is stopped if
+// the trace can't be written so that the simulation isn't affected
+func recordTrace(call trace.Call) {
+	if traceWriter == nil {
+		return
+	}
+	if err := traceWriter.Write(call); err != nil {
+		logger.Error("Error writing trace, tracing stopped: %v", err)
+		closeTrace()
+	}
+}
+
+// closeTrace closes the trace file after the last call
+func closeTrace() {
+	if traceWriter == nil {
+		return
+	}
+	logger.Debug("Trace closed after %d calls", traceWriter.Calls())
+	traceWriter.Close()
+	traceWriter = nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Transforms applied to each file while it was read, by file key, so that
+// they can be recorded by the server
+var appliedTransforms = make(map[string]string)
+
+// UTF-8 byte order mark written by some Windows editors
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// transformFor returns the first transform rule whose globs match the file name
+func transformFor(name string) *client.TransformConfig {
+	if clientConfig == nil {
+		return nil
+	}
+	for i := range clientConfig.Transforms {
+		if utils.MatchAnyGlob(clientConfig.Transforms[i].Match, name) {
+			return &clientConfig.Transforms[i]
+		}
+	}
+	return nil
+}
+
+// readTransferFile reads a file which is transferred to the server, applying
+// the transform rule matching its path. Bundled files are matched by their
+// path in the given set of bundled files, as when the bundle was sent.
+func readTransferFile(filePath string, bundled map[string]string) ([]byte, error) {
+	content, err := utils.ReadFileContents(filePath)
+	if err != nil {
+		return nil, err
+	}
+	name, inBundle := bundled[fileKey(filePath)]
+	if !inBundle {
+		name = filepath.ToSlash(filePath)
+	}
+	return transformFile(filePath, name, content)
+}
+
+// transformFile applies the transform rule matching the name to the content
+// of a file and remembers the transforms which changed it
+func transformFile(filePath, name string, content []byte) ([]byte, error) {
+	rule := transformFor(name)
+	if rule == nil {
+		return content, nil
+	}
+
+	content, applied, err := applyTransform(rule, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform %s: %w", filePath, err)
+	}
+	if len(applied) > 0 {
+		appliedTransforms[fileKey(filePath)] = strings.Join(applied, ",")
+		logger.Debug("Transformed %s: %s", filePath, strings.Join(applied, ", "))
+	}
+	return content, nil
+}
+
+// applyTransform converts the content from the declared charset to UTF-8,
+// strips a byte order mark and converts the line endings, in that order. The
+// names of the transforms which changed the content are returned.
+func applyTransform(rule *client.TransformConfig, content []byte) ([]byte, []string, error) {
+	var applied []string
+
+	if rule.Charset != "" {
+		encoding, err := ianaindex.IANA.Encoding(rule.Charset)
+		if err != nil || encoding == nil {
+			return nil, nil, fmt.Errorf("unsupported charset %q", rule.Charset)
+		}
+		decoded, err := encoding.NewDecoder().Bytes(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("content is not valid %s: %w", rule.Charset, err)
+		}
+		if !bytes.Equal(decoded, content) {
+			applied = append(applied, "charset="+rule.Charset)
+		}
+		content = decoded
+	}
+
+	if rule.StripBOM && bytes.HasPrefix(content, utf8BOM) {
+		content = content[len(utf8BOM):]
+		applied = append(applied, "strip-bom")
+	}
+
+	switch rule.LineEndings {
+	case "lf":
+		if bytes.Contains(content, []byte("\r\n")) {
+			content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+			applied = append(applied, "crlf-to-lf")
+		}
+	case "crlf":
+		converted := bytes.ReplaceAll(bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+		if !bytes.Equal(converted, content) {
+			content = converted
+			applied = append(applied, "lf-to-crlf")
+		}
+	}
+
+	return content, applied, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"discon-wrapper/client"
+)
+
+func TestApplyTransform(t *testing.T) {
+	tests := []struct {
+		rule     client.TransformConfig
+		content  string
+		expected string
+		applied  string
+	}{
+		{client.TransformConfig{LineEndings: "lf", StripBOM: true}, "\xEF\xBB\xBF1 ! a\r\n2 ! b\r\n", "1 ! a\n2 ! b\n", "strip-bom,crlf-to-lf"},
+		{client.TransformConfig{LineEndings: "crlf"}, "1\n2\r\n", "1\r\n2\r\n", "lf-to-crlf"},
+		{client.TransformConfig{LineEndings: "lf"}, "1\n2\n", "1\n2\n", ""},
+		{client.TransformConfig{Charset: "windows-1252"}, "\"Tabelle \xe4\" ! file\n", "\"Tabelle ä\" ! file\n", "charset=windows-1252"},
+		{client.TransformConfig{Charset: "utf-16", StripBOM: true}, "\xff\xfea\x00\r\x00\n\x00", "a\r\n", "charset=utf-16"},
+	}
+	for _, test := range tests {
+		content, applied, err := applyTransform(&test.rule, []byte(test.content))
+		if err != nil {
+			t.Fatalf("applyTransform(%+v) failed: %v", test.rule, err)
+		}
+		if string(content) != test.expected || strings.Join(applied, ",") != test.applied {
+			t.Errorf("applyTransform(%+v, %q) = %q, %v, expected %q, %s", test.rule, test.content, content, applied, test.expected, test.applied)
+		}
+	}
+}
@@ -444,7 +444,7 @@ func (ah *AdminHandler) testController(controller *Controller) (bool, string) {
 	testClientID := "test-" + uuid.New().String()
 
 	// Start the container for testing
-	containerInfo, err := ah.manager.dockerController.StartContainer(ah.manager.ctx, controller, testClientID)
+	containerInfo, err := ah.manager.dockerController.StartContainer(ah.manager.ctx, controller, testClientID, nil)
 	if err != nil {
 		return false, fmt.Sprintf("Error starting container: %v", err)
 	}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -125,16 +126,46 @@ func (db *ControllerDatabase) GetControllerByVersion(version string) (*Controlle
 	return nil, false
 }
 
-// ListControllers returns a list of all controllers
+// GetControllerByIDAndVersion returns the controller with the given ID and version.
+// Versioned controllers are registered with IDs such as "rosco-v2_9_4", so if
+// the controller with the exact ID has a different version, controllers whose
+// ID starts with "<id>-" are searched for the requested version.
+func (db *ControllerDatabase) GetControllerByIDAndVersion(id, version string) (*Controller, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if controller, ok := db.controllers[id]; ok && controller.Version == version {
+		return controller, true
+	}
+
+	for _, controller := range db.sortedControllers() {
+		if controller.Version == version && strings.HasPrefix(controller.ID, id+"-") {
+			return controller, true
+		}
+	}
+
+	return nil, false
+}
+
+// ListControllers returns a list of all controllers sorted by ID
 func (db *ControllerDatabase) ListControllers() []*Controller {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 
+	return db.sortedControllers()
+}
+
+// sortedControllers returns the controllers sorted by ID, the caller must hold the mutex
+func (db *ControllerDatabase) sortedControllers() []*Controller {
 	controllers := make([]*Controller, 0, len(db.controllers))
 	for _, controller := range db.controllers {
 		controllers = append(controllers, controller)
 	}
 
+	sort.Slice(controllers, func(i, j int) bool {
+		return controllers[i].ID < controllers[j].ID
+	})
+
 	return controllers
 }
 
@@ -166,8 +166,9 @@ func (dc *DockerController) ensureContainerDoesNotExist(ctx context.Context, con
 	return nil
 }
 
-// StartContainer starts a container for the given controller configuration
-func (dc *DockerController) StartContainer(ctx context.Context, controller *Controller, clientID string) (*ContainerInfo, error) {
+// StartContainer starts a container for the given controller configuration,
+// with the environment variables forwarded by the client added to its Env
+func (dc *DockerController) StartContainer(ctx context.Context, controller *Controller, clientID string, env []string) (*ContainerInfo, error) {
 	dc.mutex.Lock()
 	defer dc.mutex.Unlock()
 
@@ -188,9 +189,9 @@ func (dc *DockerController) StartContainer(ctx context.Context, controller *Cont
 		ExposedPorts: nat.PortSet{
 			nat.Port(fmt.Sprintf("%d/tcp", controller.Ports.Internal)): struct{}{},
 		},
-		Env: []string{
+		Env: append([]string{
 			"DEBUG_LEVEL=1",
-		},
+		}, env...),
 	}
 
 	// Create host configuration with resource limits
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -47,6 +48,7 @@ type ClientConnection struct {
 	ControllerID   string
 	ControllerPath string
 	ProcName       string
+	Console        bool // Forward the controller's console output to the client
 	WS             *websocket.Conn
 	ProxyCloseCh   chan struct{}
 	manager        *Manager
@@ -261,23 +263,42 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	procName := params.Get("proc")
 	controllerID := params.Get("controller")
 	controllerVersion := params.Get("version")
+	console := params.Get(utils.ConsoleParam) != ""
+
+	// Reject clients writing payloads of another layout
+	if err := utils.CheckProtocol("discon-client", params.Get(utils.ProtocolParam)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Error("Rejected connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
 
 	logger.Debug("New connection from %s requesting controller %s (path: %s, proc: %s, version: %s)",
 		r.RemoteAddr, controllerID, controllerPath, procName, controllerVersion)
 
 	// Find controller by using the following priority:
-	// 1. If controllerID is provided, use that
+	// 1. If controllerID is provided, use that (with controllerVersion if also provided)
 	// 2. If controllerPath is provided and matches a controller ID, use that
 	// 3. If controllerVersion is provided, use that
 	// 4. Use the first controller as default
 	var controller *Controller
 
-	if controllerID != "" {
-		// Method 1: By explicit controller ID
+	if controllerID != "" && controllerVersion != "" {
+		// Method 1a: By explicit controller ID and version
+		var ok bool
+		controller, ok = m.database.GetControllerByIDAndVersion(controllerID, controllerVersion)
+		if !ok {
+			msg := fmt.Sprintf("Controller not found: %s version %s", controllerID, controllerVersion)
+			http.Error(w, msg+"\n"+m.availableControllersMessage(), http.StatusBadRequest)
+			logger.Error("%s", msg)
+			return
+		}
+		logger.Debug("Selected controller by ID and version: %s (%s)", controller.ID, controllerVersion)
+	} else if controllerID != "" {
+		// Method 1b: By explicit controller ID
 		var ok bool
 		controller, ok = m.database.GetController(controllerID)
 		if !ok {
-			http.Error(w, "Controller not found: "+controllerID, http.StatusBadRequest)
+			http.Error(w, "Controller not found: "+controllerID+"\n"+m.availableControllersMessage(), http.StatusBadRequest)
 			logger.Error("Controller not found: %s", controllerID)
 			return
 		}
@@ -308,7 +329,7 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		var ok bool
 		controller, ok = m.database.GetControllerByVersion(controllerVersion)
 		if !ok {
-			http.Error(w, "Controller version not found: "+controllerVersion, http.StatusBadRequest)
+			http.Error(w, "Controller version not found: "+controllerVersion+"\n"+m.availableControllersMessage(), http.StatusBadRequest)
 			logger.Error("Controller version not found: %s", controllerVersion)
 			return
 		}
@@ -338,8 +359,19 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	logger.Debug("Using controller library path: %s and proc: %s", controller.LibraryPath, controller.ProcName)
 
+	// Environment variables forwarded by the client for the controller
+	env, err := utils.ParseEnvHeader(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Error("Rejected environment variables: %v", err)
+		return
+	}
+	if len(env) > 0 {
+		logger.Debug("Forwarding %d environment variables to the controller container", len(env))
+	}
+
 	// Start container for this controller
-	containerInfo, err := m.dockerController.StartContainer(m.ctx, controller, connID)
+	containerInfo, err := m.dockerController.StartContainer(m.ctx, controller, connID, env)
 	if err != nil {
 		http.Error(w, "Error starting controller container: "+err.Error(), http.StatusInternalServerError)
 		logger.Error("Error starting controller container: %v", err)
@@ -349,7 +381,7 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("Started container: %s with IP %s", containerInfo.Name, containerInfo.ContainerIP)
 
 	// Upgrade connection to WebSocket
-	clientConn, err := m.upgrader.Upgrade(w, r, nil)
+	clientConn, err := m.upgrader.Upgrade(w, r, utils.ProtocolResponseHeader())
 	if err != nil {
 		logger.Error("Error upgrading to WebSocket: %v", err)
 		go func() {
@@ -372,6 +404,7 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		ControllerID:   controller.ID,
 		ControllerPath: controller.LibraryPath,
 		ProcName:       controller.ProcName,
+		Console:        console,
 		WS:             clientConn,
 		ProxyCloseCh:   make(chan struct{}),
 		manager:        m,
@@ -387,6 +420,21 @@ func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go conn.proxyConnectionToContainer()
 }
 
+// availableControllersMessage lists the registered controllers so that clients
+// requesting an unknown controller can report the valid choices
+func (m *Manager) availableControllersMessage() string {
+	controllers := m.database.ListControllers()
+	if len(controllers) == 0 {
+		return "No controllers available"
+	}
+
+	entries := make([]string, 0, len(controllers))
+	for _, controller := range controllers {
+		entries = append(entries, fmt.Sprintf("%s (version %s)", controller.ID, controller.Version))
+	}
+	return "Available controllers: " + strings.Join(entries, ", ")
+}
+
 // HandleHealth handles health check requests
 func (m *Manager) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	// Basic health check implementation
@@ -455,6 +503,10 @@ func (cc *ClientConnection) proxyConnectionToContainer() {
 	cc.logger.Debug("Using controller library path: %s and proc: %s", cc.ControllerPath, cc.ProcName)
 	q.Add("path", cc.ControllerPath)
 	q.Add("proc", cc.ProcName)
+	if cc.Console {
+		q.Add(utils.ConsoleParam, "1")
+	}
+	q.Add(utils.ProtocolParam, strconv.Itoa(int(dw.ProtocolVersion)))
 	u.RawQuery = q.Encode()
 
 	cc.logger.Debug("Connecting to container WebSocket at %s", u.String())
@@ -533,6 +585,7 @@ func (cc *ClientConnection) proxyConnectionToContainer() {
 	// Add retry logic
 	maxRetries := 12 // Allow up to 30 seconds for container startup
 	var serverConn *websocket.Conn
+	var serverResp *http.Response
 	var dialErr error
 	var backoffDuration time.Duration = 500 * time.Millisecond
 
@@ -554,7 +607,7 @@ func (cc *ClientConnection) proxyConnectionToContainer() {
 		ipURL := *u // Make a copy of the URL
 		ipURL.Host = fmt.Sprintf("%s:%d", cc.ContainerInfo.ContainerIP, cc.ContainerInfo.Port)
 
-		serverConn, _, dialErr = dialer.DialContext(ctx, ipURL.String(), nil)
+		serverConn, serverResp, dialErr = dialer.DialContext(ctx, ipURL.String(), nil)
 		cancel() // Cancel the timeout context
 
 		if dialErr == nil {
@@ -617,6 +670,13 @@ func (cc *ClientConnection) proxyConnectionToContainer() {
 
 	defer serverConn.Close()
 
+	// The image of the container may be of another release than the client
+	if err := utils.CheckProtocol("discon-server in the container", serverResp.Header.Get(utils.ProtocolHeader)); err != nil {
+		cc.logger.Error("Error connecting to container WebSocket: %v", err)
+		cc.Close()
+		return
+	}
+
 	cc.logger.Debug("Connected to container WebSocket, starting proxy")
 
 	// Create done channel for goroutine synchronization
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"discon-wrapper/client"
+	"discon-wrapper/shared/utils"
+)
+
+// dial opens a session with the server of the scenario, directly or through a
+// discon-manager, with the addressing rules of discon-client
+func dial(ctx context.Context, s *Scenario, connectTimeout, callTimeout time.Duration) (*client.Session, error) {
+	return client.Dial(ctx, client.Options{
+		ServerAddr:        s.ServerAddr,
+		LibPath:           s.LibPath,
+		LibProc:           s.LibProc,
+		ControllerID:      s.ControllerID,
+		ControllerVersion: s.ControllerVersion,
+		TLS:               s.TLS,
+		ConnectTimeout:    connectTimeout,
+		CallTimeout:       callTimeout,
+		TransferTimeout:   callTimeout,
+	})
+}
+
+// sendFiles places the files in the session directory with their paths
+// relative to the directory of the input file, so that relative references
+// between them still resolve. Files the server has cached aren't uploaded. It
+// returns the server path of every file.
+func sendFiles(ctx context.Context, session *client.Session, inFile string, files []string) (map[string]string, error) {
+	baseDir := filepath.Dir(inFile)
+	serverPaths := make(map[string]string, len(files))
+	for _, file := range files {
+		content, err := utils.ReadFileContents(file)
+		if err != nil {
+			return nil, err
+		}
+		name, err := filepath.Rel(baseDir, file)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = filepath.Base(file)
+		}
+		serverPaths[file], err = session.UploadFile(ctx, utils.NewFileEntry(filepath.ToSlash(name), content), content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", file, err)
+		}
+	}
+	return serverPaths, nil
+}
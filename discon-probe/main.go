@@ -0,0 +1,318 @@
+// discon-probe sends a scenario of synthetic avrSWAP states to a controller on
+// a discon-server or discon-manager and reports its responses and timings,
+// to check a controller deployment without running a simulation
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+const program = "discon-probe"
+const version = "v0.2.0"
+
+// Entries printed for every call unless the scenario lists its outputs: the
+// pitch and generator torque demands
+var defaultOutputs = []int{45, 47}
+
+func main() {
+	server := flag.String("server", "", "Address of the discon-server or discon-manager (default: scenario server_addr or DISCON_SERVER_ADDR)")
+	path := flag.String("path", "", "Controller library path on the server (default: scenario lib_path or DISCON_LIB_PATH)")
+	proc := flag.String("proc", "", "Controller procedure (default: scenario lib_proc or DISCON_LIB_PROC)")
+	controller := flag.String("controller", "", "Controller ID on a discon-manager (default: scenario controller_id or DISCON_CONTROLLER_ID)")
+	controllerVersion := flag.String("version", "", "Controller version on a discon-manager")
+	inFile := flag.String("infile", "", "Controller input file to transfer instead of the scenario infile")
+	steps := flag.Int("steps", -1, "Number of steps between the init and final call, replacing the scenario steps")
+	jsonFile := flag.String("json", "", "Write the responses and timings as JSON to this file, '-' for standard output")
+	every := flag.Int("every", 1, "Print every n-th step")
+	connectTimeout := flag.Duration("connect-timeout", 10*time.Second, "WebSocket handshake timeout")
+	callTimeout := flag.Duration("timeout", 60*time.Second, "Time to wait for the server to respond to a request")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s\n\nUsage: %s [options] SCENARIO\n\n", program, version, program)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *every < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	scenario, err := LoadScenario(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(2)
+	}
+
+	// Flags override the environment, which overrides the scenario as in discon-client
+	scenario.applyEnvOverrides()
+	setIfNotEmpty(&scenario.ServerAddr, *server)
+	setIfNotEmpty(&scenario.LibPath, *path)
+	setIfNotEmpty(&scenario.LibProc, *proc)
+	setIfNotEmpty(&scenario.ControllerID, *controller)
+	setIfNotEmpty(&scenario.ControllerVersion, *controllerVersion)
+	if *inFile != "" {
+		// Relative to the working directory rather than the scenario
+		scenario.InFile, _ = filepath.Abs(*inFile)
+	}
+	if *steps >= 0 {
+		scenario.Steps, scenario.Sequence = *steps, nil
+	}
+	if err := scenario.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(2)
+	}
+
+	rep, err := probe(scenario, *connectTimeout, *callTimeout, *jsonFile != "-", *every)
+	if rep != nil && *jsonFile != "" {
+		if jsonErr := writeJSON(*jsonFile, rep); jsonErr != nil {
+			fmt.Fprintf(os.Stderr, "%s: error writing JSON: %v\n", program, jsonErr)
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(1)
+	}
+	if rep.Failed {
+		os.Exit(1)
+	}
+}
+
+func setIfNotEmpty(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
+
+// report holds the responses and timings of a probe, as exported to JSON
+type report struct {
+	Scenario          string            `json:"scenario"`
+	ServerAddr        string            `json:"server_addr"`
+	LibPath           string            `json:"lib_path,omitempty"`
+	LibProc           string            `json:"lib_proc,omitempty"`
+	ControllerID      string            `json:"controller_id,omitempty"`
+	ControllerVersion string            `json:"controller_version,omitempty"`
+	Files             map[string]string `json:"files,omitempty"` // Server paths of the transferred files
+	Calls             []callResult      `json:"calls"`
+	RoundTrip         timing            `json:"round_trip"`
+	Controller        timing            `json:"controller"`
+	Failed            bool              `json:"failed"`
+}
+
+// callResult is the response of the controller to one call
+type callResult struct {
+	Call         int                 `json:"call"`
+	Status       int                 `json:"status"`
+	Time         float32             `json:"time"`
+	Fail         int32               `json:"fail"`
+	Msg          string              `json:"msg,omitempty"`
+	RoundTripMs  float64             `json:"round_trip_ms"`
+	ControllerMs float64             `json:"controller_ms"`
+	Outputs      map[string]*float64 `json:"outputs"` // Listed and changed avrSWAP entries, null if not finite
+}
+
+// timing summarizes the durations of the calls in milliseconds
+type timing struct {
+	MinMs  float64 `json:"min_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	MaxMs  float64 `json:"max_ms"`
+
+	total time.Duration
+	count int
+}
+
+func (t *timing) add(d time.Duration) {
+	ms := milliseconds(d)
+	if t.count == 0 || ms < t.MinMs {
+		t.MinMs = ms
+	}
+	t.MaxMs = max(t.MaxMs, ms)
+	t.total += d
+	t.count++
+	t.MeanMs = milliseconds(t.total / time.Duration(t.count))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// probe connects to the server, transfers the files and sends the calls of
+// the scenario, stopping at the first call the controller fails. The report
+// holds the calls made before an error.
+func probe(s *Scenario, connectTimeout, callTimeout time.Duration, print bool, every int) (*report, error) {
+	rep := &report{
+		Scenario: s.Name, ServerAddr: s.ServerAddr, LibPath: s.LibPath, LibProc: s.LibProc,
+		ControllerID: s.ControllerID, ControllerVersion: s.ControllerVersion, Calls: []callResult{},
+	}
+	out := io.Discard
+	if print {
+		out = os.Stdout
+	}
+
+	ctx := context.Background()
+	session, err := dial(ctx, s, connectTimeout, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	fmt.Fprintf(out, "Connected to %s, scenario '%s'\n", s.ServerAddr, s.Name)
+
+	inFile := s.Path(s.InFile)
+	if inFile != "" {
+		files := []string{inFile}
+		for _, file := range s.AdditionalFiles {
+			files = append(files, s.Path(file))
+		}
+		rep.Files, err = sendFiles(ctx, session, inFile, files)
+		if err != nil {
+			return rep, fmt.Errorf("file transfer failed: %w", err)
+		}
+		for _, file := range files {
+			fmt.Fprintf(out, "Transferred %s to %s\n", file, rep.Files[file])
+		}
+		inFile = rep.Files[inFile]
+	}
+
+	outputs := s.Outputs
+	if len(outputs) == 0 {
+		outputs = defaultOutputs
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"call", "status", "time", "fail", "round trip ms", "controller ms"}
+	for _, index := range outputs {
+		header = append(header, trace.SwapName(index-1))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\tmessage\t")
+
+	states := s.States()
+	for i, state := range states {
+		// The response replaces the call's payload, the request is kept to find the changed entries
+		request, response := s.payload(state, inFile), s.payload(state, inFile)
+		start := time.Now()
+		if err := session.Call(ctx, response); err != nil {
+			w.Flush()
+			return rep, fmt.Errorf("call %d failed: %w", i, err)
+		}
+		roundTrip := time.Since(start)
+		controllerTime := time.Duration(response.CallTime)
+		rep.RoundTrip.add(roundTrip)
+		rep.Controller.add(controllerTime)
+
+		result := callResult{
+			Call: i, Status: state.Status, Time: state.Swap[swapTime], Fail: response.Fail,
+			Msg:          utils.ExtractStringFromBytes(response.Msg),
+			RoundTripMs:  milliseconds(roundTrip),
+			ControllerMs: milliseconds(controllerTime),
+			Outputs:      changedOutputs(request.Swap, response.Swap, outputs),
+		}
+		rep.Calls = append(rep.Calls, result)
+
+		if state.Status != statusStep || i%every == 0 || response.Fail < 0 {
+			row := []string{
+				fmt.Sprint(i), fmt.Sprint(state.Status), fmt.Sprintf("%.4f", result.Time), fmt.Sprint(result.Fail),
+				fmt.Sprintf("%.3f", result.RoundTripMs), fmt.Sprintf("%.3f", result.ControllerMs),
+			}
+			for _, index := range outputs {
+				row = append(row, fmt.Sprintf("%g", swapValue(response.Swap, index-1)))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t")+"\t"+result.Msg+"\t")
+		}
+
+		// A simulation stops when the controller fails a call
+		if response.Fail < 0 {
+			rep.Failed = true
+			break
+		}
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "%d of %d calls made, round trip %.3f/%.3f/%.3f ms, controller %.3f/%.3f/%.3f ms (min/mean/max)\n",
+		len(rep.Calls), len(states), rep.RoundTrip.MinMs, rep.RoundTrip.MeanMs, rep.RoundTrip.MaxMs,
+		rep.Controller.MinMs, rep.Controller.MeanMs, rep.Controller.MaxMs)
+	if rep.Failed {
+		last := rep.Calls[len(rep.Calls)-1]
+		fmt.Fprintf(out, "Controller failed call %d (fail=%d): %s\n", last.Call, last.Fail, last.Msg)
+	}
+	return rep, nil
+}
+
+// payload creates the controller call of a state, with the buffer sizes a
+// simulation would pass
+func (s *Scenario) payload(state State, inFile string) *dw.Payload {
+	swap := append([]float32(nil), state.Swap...)
+	swap[swapMsgSize] = float32(s.MsgSize)
+	swap[swapInFileSize] = float32(len(inFile) + 1)
+	swap[swapOutNameSize] = float32(len(s.OutName) + 1)
+	return &dw.Payload{
+		Swap:    swap,
+		InFile:  []byte(inFile + "\x00"),
+		OutName: []byte(s.OutName + "\x00"),
+		Msg:     make([]byte, s.MsgSize),
+	}
+}
+
+// changedOutputs returns the listed avrSWAP entries and those the controller
+// changed, by name
+func changedOutputs(input, output []float32, listed []int) map[string]*float64 {
+	outputs := make(map[string]*float64)
+	add := func(index int) {
+		value := float64(swapValue(output, index))
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			outputs[trace.SwapName(index)] = nil
+		} else {
+			outputs[trace.SwapName(index)] = &value
+		}
+	}
+	for _, index := range listed {
+		add(index - 1)
+	}
+	for i, value := range output {
+		if i >= len(input) || !sameValue(value, input[i]) {
+			add(i)
+		}
+	}
+	return outputs
+}
+
+// sameValue reports whether two avrSWAP values are equal, treating NaN as equal to NaN
+func sameValue(a, b float32) bool {
+	return a == b || (math.IsNaN(float64(a)) && math.IsNaN(float64(b)))
+}
+
+// swapValue returns an avrSWAP entry by 0-based index, NaN if it's missing
+func swapValue(swap []float32, index int) float32 {
+	if index < len(swap) {
+		return swap[index]
+	}
+	return float32(math.NaN())
+}
+
+// writeJSON writes the report to a file or to standard output
+func writeJSON(path string, rep *report) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rep)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"discon-wrapper/shared/utils"
+
+	"github.com/spf13/viper"
+)
+
+// Size of avrSWAP sent to the controller unless the scenario sets one, large
+// enough for the entries of the Bladed interface up to the torque table
+const defaultSwapSize = 300
+
+// Size of the avcMSG buffer unless the scenario sets one
+const defaultMsgSize = 1024
+
+// avrSWAP entries set for every call, by 0-based index
+const (
+	swapStatus       = 0
+	swapTime         = 1
+	swapCommInterval = 2
+	swapMsgSize      = 48
+	swapInFileSize   = 49
+	swapOutNameSize  = 63
+	swapSize         = 128
+)
+
+// Scenario describes the controller to probe and the calls to send to it
+type Scenario struct {
+	Name              string          `mapstructure:"name"`
+	ServerAddr        string          `mapstructure:"server_addr"`
+	LibPath           string          `mapstructure:"lib_path"`
+	LibProc           string          `mapstructure:"lib_proc"`
+	ControllerID      string          `mapstructure:"controller_id"`      // Controller ID on a discon-manager
+	ControllerVersion string          `mapstructure:"controller_version"` // Controller version on a discon-manager
+	TLS               utils.TLSConfig `mapstructure:"tls"`
+
+	InFile          string   `mapstructure:"infile"`           // Controller input file transferred to the server
+	AdditionalFiles []string `mapstructure:"additional_files"` // Files transferred next to the input file
+	OutName         string   `mapstructure:"outname"`
+
+	SwapSize  int             `mapstructure:"swap_size"`
+	MsgSize   int             `mapstructure:"msg_size"`
+	StartTime float64         `mapstructure:"start_time"`
+	Dt        float64         `mapstructure:"dt"`
+	Swap      map[int]float64 `mapstructure:"swap"`     // Values of every call by 1-based avrSWAP index
+	Steps     int             `mapstructure:"steps"`    // Steps between the init and final call without a sequence
+	Sequence  []Segment       `mapstructure:"sequence"` // Steps between the init and final call
+	Outputs   []int           `mapstructure:"outputs"`  // 1-based avrSWAP entries printed for every call
+
+	// Directory relative file paths are resolved in (not part of the file)
+	Dir string `mapstructure:"-"`
+}
+
+// Segment is a number of steps during which avrSWAP entries are held or ramped
+type Segment struct {
+	Steps  int             `mapstructure:"steps"`
+	Swap   map[int]float64 `mapstructure:"swap"`    // Values set at the first step of the segment
+	RampTo map[int]float64 `mapstructure:"ramp_to"` // Values reached linearly at the last step
+}
+
+// Status of a controller call in avrSWAP(1)
+const (
+	statusInit  = 0
+	statusStep  = 1
+	statusFinal = -1
+)
+
+// State is the avrSWAP sent to the controller in one call
+type State struct {
+	Status int
+	Swap   []float32
+}
+
+// LoadScenario reads a scenario from a YAML or JSON file and applies the defaults
+func LoadScenario(path string) (*Scenario, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading scenario: %w", err)
+	}
+
+	s := &Scenario{}
+	if err := v.Unmarshal(s); err != nil {
+		return nil, fmt.Errorf("error unmarshaling scenario: %w", err)
+	}
+	if s.Name == "" {
+		s.Name = filepath.Base(path)
+	}
+	s.Dir = filepath.Dir(path)
+	if s.SwapSize == 0 {
+		s.SwapSize = defaultSwapSize
+	}
+	if s.MsgSize == 0 {
+		s.MsgSize = defaultMsgSize
+	}
+	if s.OutName == "" {
+		s.OutName = "discon-probe"
+	}
+	return s, nil
+}
+
+// applyEnvOverrides applies the addressing environment variables of discon-client
+func (s *Scenario) applyEnvOverrides() {
+	if value, found := os.LookupEnv("DISCON_SERVER_ADDR"); found {
+		s.ServerAddr = value
+	}
+	if value, found := os.LookupEnv("DISCON_LIB_PATH"); found {
+		s.LibPath = value
+	}
+	if value, found := os.LookupEnv("DISCON_LIB_PROC"); found {
+		s.LibProc = value
+	}
+	if value, found := os.LookupEnv("DISCON_CONTROLLER_ID"); found {
+		s.ControllerID = value
+	}
+	if value, found := os.LookupEnv("DISCON_CONTROLLER_VERSION"); found {
+		s.ControllerVersion = value
+	}
+}
+
+// Validate checks that the scenario can be run
+func (s *Scenario) Validate() error {
+	if s.ServerAddr == "" {
+		return fmt.Errorf("server address is not set (server_addr, DISCON_SERVER_ADDR or -server)")
+	}
+	if s.ControllerID == "" && (s.LibPath == "" || s.LibProc == "") {
+		return fmt.Errorf("library path and procedure, or a controller ID, are required")
+	}
+	if s.SwapSize <= swapSize {
+		return fmt.Errorf("swap_size must be at least %d, got %d", swapSize+1, s.SwapSize)
+	}
+	if s.MsgSize < 1 {
+		return fmt.Errorf("msg_size must be positive, got %d", s.MsgSize)
+	}
+	if s.Dt <= 0 {
+		return fmt.Errorf("dt must be positive, got %g", s.Dt)
+	}
+	if s.Steps < 0 {
+		return fmt.Errorf("steps must not be negative, got %d", s.Steps)
+	}
+	for i, segment := range s.Sequence {
+		if segment.Steps < 1 {
+			return fmt.Errorf("segment %d of the sequence has no steps", i+1)
+		}
+		if err := s.checkIndices(segment.Swap); err != nil {
+			return fmt.Errorf("segment %d of the sequence: %w", i+1, err)
+		}
+		if err := s.checkIndices(segment.RampTo); err != nil {
+			return fmt.Errorf("segment %d of the sequence: %w", i+1, err)
+		}
+	}
+	for _, index := range s.Outputs {
+		if index < 1 || index > s.SwapSize {
+			return fmt.Errorf("output avrSWAP index %d is out of range 1-%d", index, s.SwapSize)
+		}
+	}
+	return s.checkIndices(s.Swap)
+}
+
+// checkIndices checks that the 1-based avrSWAP indices exist and aren't set by the probe
+func (s *Scenario) checkIndices(values map[int]float64) error {
+	for index := range values {
+		if index < 1 || index > s.SwapSize {
+			return fmt.Errorf("avrSWAP index %d is out of range 1-%d", index, s.SwapSize)
+		}
+		switch index - 1 {
+		case swapStatus, swapTime, swapCommInterval, swapMsgSize, swapInFileSize, swapOutNameSize, swapSize:
+			return fmt.Errorf("avrSWAP(%d) is set by discon-probe", index)
+		}
+	}
+	return nil
+}
+
+// Path resolves a file path of the scenario relative to the scenario file
+func (s *Scenario) Path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.Dir, name)
+}
+
+// States returns the avrSWAP of every call: the init call, the steps of the
+// sequence and the final call. The buffer size entries are set by the caller
+// once the server path of the input file is known.
+func (s *Scenario) States() []State {
+	sequence := s.Sequence
+	if len(sequence) == 0 {
+		sequence = []Segment{{Steps: s.Steps}}
+	}
+
+	values := make(map[int]float64, len(s.Swap))
+	for index, value := range s.Swap {
+		values[index] = value
+	}
+
+	step := 0
+	states := []State{s.state(statusInit, step, values)}
+	for _, segment := range sequence {
+		for index, value := range segment.Swap {
+			values[index] = value
+		}
+		from := make(map[int]float64, len(segment.RampTo))
+		for index := range segment.RampTo {
+			from[index] = values[index]
+		}
+
+		for i := 1; i <= segment.Steps; i++ {
+			for index, to := range segment.RampTo {
+				values[index] = from[index] + (to-from[index])*float64(i)/float64(segment.Steps)
+			}
+			step++
+			states = append(states, s.state(statusStep, step, values))
+		}
+	}
+	return append(states, s.state(statusFinal, step, values))
+}
+
+// state creates the avrSWAP of a call at a step of the sequence
+func (s *Scenario) state(status, step int, values map[int]float64) State {
+	swap := make([]float32, s.SwapSize)
+	for index, value := range values {
+		swap[index-1] = float32(value)
+	}
+	swap[swapStatus] = float32(status)
+	swap[swapTime] = float32(s.StartTime + float64(step)*s.Dt)
+	swap[swapCommInterval] = float32(s.Dt)
+	swap[swapSize] = float32(s.SwapSize)
+	return State{Status: status, Swap: swap}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScenarioStates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ramp.yaml")
+	os.WriteFile(path, []byte(`
+server_addr: localhost:8080
+lib_path: libdiscon.so
+lib_proc: DISCON
+dt: 0.5
+swap:
+  27: 4
+sequence:
+  - steps: 2
+  - steps: 4
+    swap: {20: 100}
+    ramp_to: {27: 12}
+`), 0644)
+
+	s, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	states := s.States()
+	if len(states) != 8 {
+		t.Fatalf("Expected init, 6 steps and final call, got %d calls", len(states))
+	}
+	if states[0].Status != statusInit || states[1].Status != statusStep || states[7].Status != statusFinal {
+		t.Errorf("Unexpected statuses %d, %d and %d", states[0].Status, states[1].Status, states[7].Status)
+	}
+	if len(states[0].Swap) != defaultSwapSize || states[0].Swap[swapSize] != defaultSwapSize {
+		t.Errorf("Expected avrSWAP of size %d, got %d", defaultSwapSize, len(states[0].Swap))
+	}
+
+	// Held for the first segment, then ramped to 12 over 4 steps
+	for i, want := range []float32{4, 4, 4, 6, 8, 10, 12, 12} {
+		if got := states[i].Swap[26]; got != want {
+			t.Errorf("Call %d: expected wind speed %g, got %g", i, want, got)
+		}
+	}
+	if states[2].Swap[19] != 0 || states[3].Swap[19] != 100 {
+		t.Errorf("Expected generator speed set at the second segment")
+	}
+	if got := states[6].Swap[swapTime]; math.Abs(float64(got)-3) > 1e-6 {
+		t.Errorf("Expected time 3 at step 6, got %g", got)
+	}
+
+	s.Sequence[1].Swap[1] = 1
+	if err := s.Validate(); err == nil {
+		t.Error("Expected avrSWAP(1) to be rejected")
+	}
+}
@@ -0,0 +1,162 @@
+// discon-replay feeds the inputs recorded in a call trace to a controller,
+// either loaded locally or by a discon-server, and compares its outputs with
+// the recorded ones to reproduce a controller problem without the simulation
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"discon-wrapper/shared/trace"
+)
+
+const program = "discon-replay"
+const version = "v0.2.0"
+
+// Maximum number of differing avrSWAP entries listed for a call
+const maxListedDifferences = 20
+
+func main() {
+	lib := flag.String("lib", "", "Controller library to load locally")
+	server := flag.String("server", "", "Address of a discon-server to replay the calls on, e.g. localhost:8080")
+	path := flag.String("path", "", "Controller library path on the server (default: the path recorded in the trace)")
+	proc := flag.String("proc", "", "Controller procedure (default: the procedure recorded in the trace)")
+	inFile := flag.String("infile", "", "Input file passed to the controller instead of the recorded accINFILE")
+	tol := flag.String("tol", "0", "Absolute tolerances of the avrSWAP outputs, e.g. '1e-6,47=0.01,42-44=1e-3,60=inf'")
+	calls := flag.Int("calls", 0, "Number of calls to replay, 0 replays all calls")
+	all := flag.Bool("all", false, "Continue after the first divergence and report every diverging call")
+	timeout := flag.Duration("timeout", 60*time.Second, "Time to wait for the server to respond to a call")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s\n\nUsage: %s [-lib LIBRARY | -server ADDR] [options] TRACE\n\n", program, version, program)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (*lib == "") == (*server == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	tolerances, err := trace.ParseTolerances(*tol)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(2)
+	}
+	tolerances.IgnoreBufferSizes()
+
+	diverged, err := replay(flag.Arg(0), options{
+		lib: *lib, server: *server, path: *path, proc: *proc, inFile: *inFile,
+		tolerances: tolerances, calls: *calls, all: *all, timeout: *timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(1)
+	}
+	if diverged {
+		os.Exit(1)
+	}
+}
+
+// options of a replay
+type options struct {
+	lib, server, path, proc, inFile string
+	tolerances                      trace.Tolerances
+	calls                           int
+	all                             bool
+	timeout                         time.Duration
+}
+
+// replay replays the calls of a trace and reports whether the outputs diverged
+func replay(tracePath string, opts options) (bool, error) {
+	r, err := trace.Open(tracePath)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	meta := r.Metadata
+	if opts.proc == "" {
+		opts.proc = meta.LibProc
+	}
+	if opts.path == "" {
+		opts.path = meta.LibPath
+	}
+
+	var t target
+	if opts.lib != "" {
+		fmt.Printf("Replaying %s (recorded by %s %s) on library %s (%s)\n", tracePath, meta.Program, meta.Version, opts.lib, opts.proc)
+		t, err = newLocalTarget(opts.lib, opts.proc)
+	} else {
+		fmt.Printf("Replaying %s (recorded by %s %s) on %s, library %s (%s)\n", tracePath, meta.Program, meta.Version, opts.server, opts.path, opts.proc)
+		t, err = newRemoteTarget(opts.server, opts.path, opts.proc, opts.timeout)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer t.Close()
+
+	replayed, diverging := 0, 0
+	for opts.calls == 0 || replayed < opts.calls {
+		call, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+
+		args := newCallArgs(call.Input, opts.inFile)
+		if err := t.Call(args); err != nil {
+			return diverging > 0, fmt.Errorf("call %d: %w", call.Index, err)
+		}
+		replayed++
+
+		if report := compareOutputs(call, args.Output(), opts.tolerances); report != "" {
+			diverging++
+			if diverging == 1 {
+				fmt.Printf("First divergence at call %d (t=%g s):\n%s", call.Index, call.Input.SwapValue(1), report)
+			} else {
+				fmt.Printf("Divergence at call %d (t=%g s):\n%s", call.Index, call.Input.SwapValue(1), report)
+			}
+			if !opts.all {
+				break
+			}
+		}
+	}
+
+	if diverging > 0 {
+		fmt.Printf("Replayed %d calls, %d diverged\n", replayed, diverging)
+		return true, nil
+	}
+	fmt.Printf("Replayed %d calls, outputs match\n", replayed)
+	return false, nil
+}
+
+// compareOutputs returns a description of the differences between the recorded
+// and replayed outputs of a call, or an empty string if they match
+func compareOutputs(call trace.Call, got trace.Step, tolerances trace.Tolerances) string {
+	want := call.Output
+	var report string
+	if got.Fail != want.Fail {
+		report += fmt.Sprintf("  aviFAIL: expected %d, got %d\n", want.Fail, got.Fail)
+	}
+	diffs := tolerances.Compare(want.Swap, got.Swap)
+	for i, diff := range diffs {
+		if i == maxListedDifferences {
+			report += fmt.Sprintf("  ... and %d more avrSWAP entries\n", len(diffs)-i)
+			break
+		}
+		report += "  " + diff.String() + "\n"
+	}
+	if report != "" && got.Msg != want.Msg {
+		report += fmt.Sprintf("  avcMSG: expected %q, got %q\n", want.Msg, got.Msg)
+	}
+	return report
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/client"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/trace"
+)
+
+// target runs the controller the recorded inputs are replayed to
+type target interface {
+	// Call passes the arguments to the controller, which updates them in place
+	Call(args *callArgs) error
+	Close()
+}
+
+// callArgs are the DISCON arguments of a replayed call as C-style buffers
+type callArgs struct {
+	Swap    []float32
+	Fail    int32
+	InFile  []byte
+	OutName []byte
+	Msg     []byte
+}
+
+// newCallArgs creates the arguments of a call from its recorded inputs. The
+// strings are copied into buffers of the sizes given in avrSWAP, enlarged if
+// needed. A non-empty inFile replaces the recorded input file.
+func newCallArgs(step trace.Step, inFile string) *callArgs {
+	args := &callArgs{
+		Swap: append([]float32(nil), step.Swap...),
+		Fail: step.Fail,
+	}
+	if inFile != "" {
+		step.InFile = inFile
+		if len(args.Swap) > 49 {
+			args.Swap[49] = float32(len(inFile) + 1)
+		}
+	}
+	args.Msg = cBuffer(step.Msg, swapSize(args.Swap, 48))
+	args.InFile = cBuffer(step.InFile, swapSize(args.Swap, 49))
+	args.OutName = cBuffer(step.OutName, swapSize(args.Swap, 63))
+	return args
+}
+
+// swapSize returns the buffer size held in an avrSWAP entry, zero if it's missing
+func swapSize(swap []float32, index int) int {
+	if index < len(swap) {
+		return int(swap[index])
+	}
+	return 0
+}
+
+// cBuffer returns a null-terminated buffer holding s of at least the given
+// size, with a spare byte for controllers which terminate a full buffer
+func cBuffer(s string, size int) []byte {
+	buf := make([]byte, max(size, len(s))+1)
+	copy(buf, s)
+	return buf
+}
+
+// Output returns the arguments after the call
+func (a *callArgs) Output() trace.Step {
+	return trace.NewStep(a.Swap, a.Fail, a.InFile, a.OutName, a.Msg)
+}
+
+// localTarget calls a controller library loaded into this process
+type localTarget struct {
+	lib *library.Library
+}
+
+func newLocalTarget(path, proc string) (*localTarget, error) {
+	lib, err := library.Load(0, path, proc)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s' (procedure '%s')", err, path, proc)
+	}
+	return &localTarget{lib: lib}, nil
+}
+
+func (t *localTarget) Call(args *callArgs) error {
+	return t.lib.Call(args.Swap, &args.Fail, args.InFile, args.OutName, args.Msg)
+}
+
+func (t *localTarget) Close() {
+	t.lib.Unload()
+}
+
+// remoteTarget calls a controller loaded by a discon-server
+type remoteTarget struct {
+	session *client.Session
+	payload dw.Payload
+}
+
+func newRemoteTarget(addr, path, proc string, timeout time.Duration) (*remoteTarget, error) {
+	session, err := client.Dial(context.Background(), client.Options{
+		ServerAddr:     addr,
+		LibPath:        path,
+		LibProc:        proc,
+		ConnectTimeout: timeout,
+		CallTimeout:    timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &remoteTarget{session: session}, nil
+}
+
+func (t *remoteTarget) Call(args *callArgs) error {
+	t.payload = dw.Payload{Swap: args.Swap, Fail: args.Fail, InFile: args.InFile, OutName: args.OutName, Msg: args.Msg}
+	if err := t.session.Call(context.Background(), &t.payload); err != nil {
+		return err
+	}
+	args.Swap, args.Fail, args.InFile, args.OutName, args.Msg =
+		t.payload.Swap, t.payload.Fail, t.payload.InFile, t.payload.OutName, t.payload.Msg
+	return nil
+}
+
+func (t *remoteTarget) Close() {
+	t.session.Close()
+}
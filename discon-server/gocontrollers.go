@@ -0,0 +1,9 @@
+package main
+
+// Go controllers served for library paths of the form "go:<alias>" are
+// registered by importing their package into the server, e.g.
+//
+//	import _ "example.com/turbines/pid"
+//
+// The example controller of the sdk is only included when the server is built
+// with the "example" build tag, see gocontrollers_example.go.
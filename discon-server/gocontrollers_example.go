@@ -0,0 +1,8 @@
+//go:build example
+
+package main
+
+import (
+	// Demo controller served as "go:example", kept out of production builds
+	_ "discon-wrapper/sdk/example"
+)
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"discon-wrapper/sdk"
+	"discon-wrapper/server"
+	"discon-wrapper/shared/library"
 	"discon-wrapper/shared/utils"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-const program = "discon-server"
-const version = "v0.2.0"
+const program = server.Program
+const version = server.Version
 
 // GH-Cp gen: Using an int for debug levels instead of boolean
 var debugLevel int = 0
@@ -28,6 +34,19 @@ func main() {
 	flag.IntVar(&port, "port", 8080, "Port to listen on")
 	// GH-Cp gen: Updated to use debug levels
 	flag.IntVar(&debugLevel, "debug", 0, "Debug level: 0=disabled, 1=basic info, 2=verbose with payloads")
+	cacheDir := flag.String("cache-dir", "discon-cache", "Directory of the file cache shared by all connections, relative to the working directory unless absolute")
+	cacheSize := flag.Int64("cache-size", 1024, "Maximum size of the file cache in MB, 0 disables the cache")
+	recordDir := flag.String("record-dir", "", "Directory to write a recording of each connection's calls and transferred files to")
+	recordAddr := flag.String("record-addr", "", "Address to serve the recordings at /recordings/ on, e.g. localhost:8081, not served if empty")
+	recordMaxSize := flag.Int64("record-max-size", 100, "Size in MB a recording is stopped at, 0 for no limit")
+	recordMaxTotal := flag.Int64("record-max-total", 1024, "Total size of the recordings in MB, the oldest are removed first, 0 for no limit")
+	recordMaxAge := flag.Duration("record-max-age", 7*24*time.Hour, "Age recordings are removed at, 0 to keep them")
+	captureOutput := flag.Bool("capture-output", false, "Capture the controllers' standard output and error and forward it to the clients which ask for it")
+	crashDir := flag.String("crash-dir", "discon-crashes", "Directory to save the reports of controller crashes in, relative to the working directory unless absolute")
+	sharedRoots := flag.String("shared-roots", "", "Directories of shared drives clients may map files to, separated by the OS path list separator")
+	replayPath := flag.String("replay", "", "Answer the calls with the outputs recorded in a trace instead of loading controllers")
+	replayTol := flag.String("replay-tol", "", "Check the inputs of replayed calls with these avrSWAP tolerances, e.g. '1e-4,2=1e-6'")
+	replayStrictFlag := flag.Bool("replay-strict", false, "Fail replayed calls whose inputs drift from the trace")
 	flag.Parse()
 	
 	// Create server-wide logger for non-connection-specific logs
@@ -36,10 +55,81 @@ func main() {
 	serverLogger.Debug("Server initialized with debug level %d", debugLevel)
 	serverLogger.Debug("Hostname: %s", getHostname())
 
+	srv := &server.Server{DebugLevel: debugLevel, CrashDir: *crashDir}
+
+	for _, root := range filepath.SplitList(*sharedRoots) {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			log.Fatal("Shared root: ", err)
+		}
+		srv.SharedRoots = append(srv.SharedRoots, abs)
+		serverLogger.Debug("Clients may map files to %s", abs)
+	}
+
+	// Contain controller crashes so that they fail the calls of their session
+	// rather than stopping the server
+	if err := library.CaptureCrashes(); err != nil {
+		serverLogger.LogAtLevel(0, "Controller crashes will stop the server: %v", err)
+	}
+
+	if *captureOutput {
+		var err error
+		srv.Console, err = server.CaptureConsole()
+		if err != nil {
+			serverLogger.LogAtLevel(0, "Controller output will not be forwarded to clients: %v", err)
+		}
+	}
+
+	if *cacheSize > 0 {
+		var err error
+		srv.Cache, err = server.NewFileCache(*cacheDir, *cacheSize*1024*1024, serverLogger)
+		if err != nil {
+			log.Fatal("File cache: ", err)
+		}
+	}
+
+	if *replayPath != "" {
+		var err error
+		srv.Replay, err = server.LoadReplay(*replayPath, *replayTol, *replayStrictFlag, serverLogger)
+		if err != nil {
+			log.Fatal("Replay trace: ", err)
+		}
+	}
+
+	if aliases := sdk.Aliases(); len(aliases) > 0 {
+		serverLogger.Debug("Go controllers: %s", strings.Join(aliases, ", "))
+	}
+
+	if *recordDir != "" {
+		var err error
+		srv.Recorder, err = server.NewRecorder(*recordDir, *recordMaxSize*1024*1024, *recordMaxTotal*1024*1024, *recordMaxAge, serverLogger)
+		if err != nil {
+			log.Fatal("Recording directory: ", err)
+		}
+	}
+
+	// The recordings hold the inputs of the simulations, so they are served on
+	// a listener of their own rather than the port the clients connect to
+	if *recordAddr != "" {
+		if *recordDir == "" {
+			log.Fatal("-record-addr requires -record-dir")
+		}
+		listener, err := net.Listen("tcp", *recordAddr)
+		if err != nil {
+			log.Fatal("Recordings listener: ", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/recordings/", http.StripPrefix("/recordings/", srv.Recorder))
+		serverLogger.Debug("Serving recordings at http://%s/recordings/", listener.Addr())
+		go func() {
+			log.Fatal("Recordings listener: ", http.Serve(listener, mux))
+		}()
+	}
+
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serverLogger.Debug("New connection request from %s", r.RemoteAddr)
 		start := time.Now()
-		ServeWs(w, r, debugLevel)
+		srv.ServeWs(w, r)
 		connectionDuration := time.Since(start)
 		serverLogger.Debug("Connection from %s closed after %v", r.RemoteAddr, connectionDuration)
 	})
@@ -0,0 +1,165 @@
+// discon-trace inspects the call traces written by discon-client and
+// discon-server and converts them to CSV
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"discon-wrapper/shared/trace"
+)
+
+const program = "discon-trace"
+const version = "v0.2.0"
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "%s %s\n\n", program, version)
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s info TRACE           Show the session metadata and a summary of the calls\n", program)
+	fmt.Fprintf(os.Stderr, "  %s csv [-o FILE] TRACE  Convert the calls to CSV with named columns\n", program)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "info":
+		err = runInfo(os.Args[2:])
+	case "csv":
+		err = runCSV(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		os.Exit(1)
+	}
+}
+
+// runInfo prints the metadata of a trace and a summary of its calls
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("info expects a single trace file")
+	}
+
+	r, err := trace.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	meta := r.Metadata
+	fmt.Printf("Recorded by:   %s %s (%s)\n", meta.Program, meta.Version, meta.Source)
+	fmt.Printf("Created:       %s\n", meta.Created.Format(time.RFC3339))
+	fmt.Printf("Host:          %s (PID %d)\n", meta.Host, meta.PID)
+	if meta.ServerAddr != "" {
+		fmt.Printf("Server:        %s\n", meta.ServerAddr)
+	}
+	fmt.Printf("Controller:    %s (%s)\n", meta.LibPath, meta.LibProc)
+	if meta.ControllerID != "" || meta.ControllerVersion != "" {
+		fmt.Printf("Controller ID: %s %s\n", meta.ControllerID, meta.ControllerVersion)
+	}
+	if meta.Profile != "" {
+		fmt.Printf("Profile:       %s\n", meta.Profile)
+	}
+	for key, value := range meta.Extra {
+		fmt.Printf("%-14s %s\n", key+":", value)
+	}
+
+	var count int
+	var first, last trace.Call
+	var total, controller time.Duration
+	var failed int
+	for {
+		call, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			break
+		}
+		if count == 0 {
+			first = call
+		}
+		last = call
+		count++
+		total += call.Duration
+		controller += call.ControllerTime
+		if call.Output.Fail < 0 {
+			failed++
+		}
+	}
+
+	fmt.Printf("Calls:         %d\n", count)
+	if count > 0 {
+		fmt.Printf("Simulation:    t=%g s to t=%g s\n", first.Input.SwapValue(1), last.Input.SwapValue(1))
+		fmt.Printf("Wall clock:    %s\n", last.Start.Add(last.Duration).Sub(first.Start).Round(time.Millisecond))
+		fmt.Printf("Call time:     %.3f ms total, %.3f ms in the controller\n", milliseconds(total), milliseconds(controller))
+		fmt.Printf("Failed calls:  %d\n", failed)
+	}
+	if len(r.Files) > 0 {
+		fmt.Printf("Files:         %d\n", len(r.Files))
+		for _, file := range r.Files {
+			fmt.Printf("  %-9s %s (%d bytes, sha256 %s)", file.Direction, file.Path, file.Size, file.Hash)
+			if file.Transforms != "" {
+				fmt.Printf(", transformed: %s", file.Transforms)
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// runCSV converts a trace to CSV
+func runCSV(args []string) error {
+	flags := flag.NewFlagSet("csv", flag.ExitOnError)
+	output := flags.String("o", "", "CSV file to write, standard output if not set")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("csv expects a single trace file")
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := trace.WriteCSV(w, flags.Arg(0))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// The calls before the incomplete record of an interrupted run are still useful
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Converted %d calls to %s\n", count, *output)
+	}
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,142 @@
+// Package discontest runs a discon-server in tests of tools built on
+// discon-wrapper. The server listens on an ephemeral port of the loopback
+// interface, answers the calls with a Go function or a controller library and
+// records them, so that a test can check what its tool sent without a fixed
+// port or a server of its own.
+package discontest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"discon-wrapper/client"
+	"discon-wrapper/sdk"
+	"discon-wrapper/server"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/trace"
+)
+
+// Alias of the Go controller of a test server, requested with the library
+// path "go:discontest"
+const alias = "discontest"
+
+// Controller answers the calls of a test server, either a Go controller or a
+// controller library
+type Controller struct {
+	path    string
+	proc    string
+	factory sdk.Factory
+}
+
+// Func answers every call with f. Unlike the methods of an sdk.Controller, f
+// is called for the first and last call as well, r.Status() tells them apart.
+func Func(f func(r *sdk.SwapRecord) error) Controller {
+	return Go(func() sdk.Controller { return funcController(f) })
+}
+
+// Go answers the calls of each connection with a new controller from factory
+func Go(factory sdk.Factory) Controller {
+	return Controller{path: sdk.PathPrefix + alias, factory: factory}
+}
+
+// Library answers the calls with the procedure of a controller library, loaded
+// for each connection like by discon-server. A crash of the library fails the
+// calls of its connection rather than the test, except on Windows.
+func Library(path, proc string) Controller {
+	library.CaptureCrashes()
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return Controller{path: path, proc: proc}
+}
+
+// funcController calls the same function for all calls
+type funcController func(r *sdk.SwapRecord) error
+
+func (f funcController) Init(r *sdk.SwapRecord) error  { return f(r) }
+func (f funcController) Step(r *sdk.SwapRecord) error  { return f(r) }
+func (f funcController) Final(r *sdk.SwapRecord) error { return f(r) }
+
+// Call is the record of a call answered by a test server
+type Call struct {
+	Session int32 // Connection the call was made on, unique for the server
+	trace.Call
+}
+
+// Server is a discon-server answering the calls of a single test
+type Server struct {
+	URL     string // WebSocket URL, e.g. "ws://127.0.0.1:41234/ws"
+	Addr    string // Host and port, the server address of discon-client
+	LibPath string // Library path the clients request
+	LibProc string // Procedure the clients request
+
+	http   *httptest.Server
+	server *server.Server
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewServer starts a server answering the calls with c. The server is closed
+// and its session directories removed when the test finishes.
+func NewServer(t testing.TB, c Controller) *Server {
+	t.Helper()
+
+	s := &Server{LibPath: c.path, LibProc: c.proc}
+	s.server = &server.Server{
+		SessionDir: t.TempDir(),
+		GoController: func(name string) (sdk.Factory, bool) {
+			return c.factory, c.factory != nil && name == alias
+		},
+		OnCall: s.record,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.server.ServeWs)
+	s.http = httptest.NewServer(mux)
+	s.Addr = strings.TrimPrefix(s.http.URL, "http://")
+	s.URL = fmt.Sprintf("ws://%s/ws", s.Addr)
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) record(session int32, call trace.Call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Session: session, Call: call})
+}
+
+// Calls returns the calls answered so far in the order they were made. A call
+// is recorded before its response is sent.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Options returns the options of a client session with the server
+func (s *Server) Options() client.Options {
+	return client.Options{ServerAddr: s.Addr, LibPath: s.LibPath, LibProc: s.LibProc}
+}
+
+// Env returns the environment variables which connect discon-client to the
+// server, e.g. for the environment of a simulation started by the test
+func (s *Server) Env() []string {
+	return []string{
+		"DISCON_SERVER_ADDR=" + s.Addr,
+		"DISCON_LIB_PATH=" + s.LibPath,
+		"DISCON_LIB_PROC=" + s.LibProc,
+	}
+}
+
+// Close ends the open connections and stops the server
+func (s *Server) Close() {
+	s.http.Close()
+	s.server.Close()
+}
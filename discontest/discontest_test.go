@@ -0,0 +1,143 @@
+package discontest
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	dw "discon-wrapper"
+	"discon-wrapper/client"
+	"discon-wrapper/sdk"
+	"discon-wrapper/shared/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// counter sets the torque demand to the number of calls of its connection
+type counter struct{ calls float32 }
+
+func (c *counter) Init(r *sdk.SwapRecord) error  { return c.Step(r) }
+func (c *counter) Final(r *sdk.SwapRecord) error { return c.Step(r) }
+func (c *counter) Step(r *sdk.SwapRecord) error {
+	c.calls++
+	r.Set(sdk.SwapTorqueDemand, c.calls)
+	return nil
+}
+
+func newPayload(status, time float32, inFile string) *dw.Payload {
+	payload := &dw.Payload{
+		Swap:   make([]float32, 130),
+		InFile: append([]byte(inFile), 0),
+		Msg:    make([]byte, 64),
+	}
+	payload.Swap[0], payload.Swap[1] = status, time
+	return payload
+}
+
+func TestFunc(t *testing.T) {
+	var settings string
+	s := NewServer(t, Func(func(r *sdk.SwapRecord) error {
+		if r.Status() == sdk.StatusInit {
+			content, err := os.ReadFile(r.InFile)
+			settings = string(content)
+			return err
+		}
+		r.Set(sdk.SwapTorqueDemand, float32(r.Time())*10)
+		return nil
+	}))
+
+	ctx := context.Background()
+	session, err := client.Dial(ctx, s.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Files are placed in a session directory on the server
+	content := []byte("2.5 gain")
+	inFile, err := session.UploadFile(ctx, utils.NewFileEntry("DISCON.IN", content), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, status := range []float32{sdk.StatusInit, sdk.StatusStep, sdk.StatusFinal} {
+		payload := newPayload(status, float32(i), inFile)
+		if err := session.Call(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Fail != 0 {
+			t.Fatalf("Call %d failed: %s", i, utils.ExtractStringFromBytes(payload.Msg))
+		}
+	}
+	if settings != "2.5 gain" {
+		t.Errorf("Expected the controller to read the uploaded file, got %q", settings)
+	}
+
+	calls := s.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 recorded calls, got %d", len(calls))
+	}
+	step := calls[1]
+	if step.Index != 1 || step.Input.InFile != inFile || step.Output.Swap[sdk.SwapTorqueDemand-1] != 10 {
+		t.Errorf("Unexpected record of call %d: input file %q, torque demand %g",
+			step.Index, step.Input.InFile, step.Output.Swap[sdk.SwapTorqueDemand-1])
+	}
+}
+
+func TestGo(t *testing.T) {
+	s := NewServer(t, Go(func() sdk.Controller { return &counter{} }))
+
+	// Each connection gets its own controller
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		session, err := client.Dial(ctx, s.Options())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for step := 0; step < 2; step++ {
+			payload := newPayload(sdk.StatusStep, float32(step), "")
+			if err := session.Call(ctx, payload); err != nil {
+				t.Fatal(err)
+			}
+			if torque := payload.Swap[sdk.SwapTorqueDemand-1]; torque != float32(step+1) {
+				t.Errorf("Expected torque demand %d on connection %d, got %g", step+1, i, torque)
+			}
+		}
+		session.Close()
+	}
+
+	calls := s.Calls()
+	if len(calls) != 4 || calls[0].Session == calls[2].Session {
+		t.Errorf("Expected two calls on each of two connections, got %v", calls)
+	}
+
+	// Only the controller of the test is served
+	options := s.Options()
+	options.LibPath = sdk.PathPrefix + "example"
+	if _, err := client.Dial(ctx, options); err == nil {
+		t.Error("Expected a registered Go controller not to be served")
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	s := NewServer(t, Go(func() sdk.Controller { return &counter{} }))
+
+	// A client of a release without the protocol version is rejected before
+	// its payloads are read
+	options := s.Options()
+	params := utils.ControllerParams(options.LibPath, options.LibProc, "", "")
+	params.Del(utils.ProtocolParam)
+	u, err := utils.ServerURL(options.ServerAddr, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected the connection to be rejected, got %v", err)
+	}
+	if reason := utils.HandshakeRejectionReason(resp); !strings.Contains(reason, "protocol version") {
+		t.Errorf("Unexpected rejection reason %q", reason)
+	}
+}
@@ -3,9 +3,23 @@ package ctrl
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// ProtocolVersion is the version of the payload layout. It's written at the
+// start of every payload after payloadMagic and exchanged in the WebSocket
+// handshake, so peers of different versions reject each other instead of
+// misreading the fields.
+const ProtocolVersion uint16 = 2
+
+// payloadMagic starts every payload, payloads of releases before the protocol
+// version was added start with the length of avrSWAP instead
+var payloadMagic = [4]byte{'D', 'W', 'P', 'L'}
+
+// ErrProtocolVersion is returned when decoding a payload of another protocol version
+var ErrProtocolVersion = errors.New("payload protocol version mismatch")
+
 type Payload struct {
 	Swap    []float32
 	Fail    int32
@@ -15,12 +29,19 @@ type Payload struct {
 	// GH-Cp gen: Added fields for file transfer
 	FileContent    []byte // Content of the controller input file
 	ServerFilePath []byte // Path where the file should be stored on server
+	Control        []byte // JSON-encoded control message for requests which are not controller calls
+	CallTime       int64  // Time the server spent in the controller call, in nanoseconds
 	buffer         bytes.Buffer
 }
 
 func (p *Payload) MarshalBinary() ([]byte, error) {
 	p.buffer.Reset()
-	err := binary.Write(&p.buffer, binary.LittleEndian, uint32(len(p.Swap)))
+	p.buffer.Write(payloadMagic[:])
+	err := binary.Write(&p.buffer, binary.LittleEndian, ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&p.buffer, binary.LittleEndian, uint32(len(p.Swap)))
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +66,10 @@ func (p *Payload) MarshalBinary() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = binary.Write(&p.buffer, binary.LittleEndian, uint32(len(p.Control)))
+	if err != nil {
+		return nil, err
+	}
 	err = binary.Write(&p.buffer, binary.LittleEndian, p.Swap)
 	if err != nil {
 		return nil, err
@@ -74,6 +99,14 @@ func (p *Payload) MarshalBinary() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = binary.Write(&p.buffer, binary.LittleEndian, p.Control)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&p.buffer, binary.LittleEndian, p.CallTime)
+	if err != nil {
+		return nil, err
+	}
 	return p.buffer.Bytes(), nil
 }
 
@@ -86,8 +119,27 @@ func (p *Payload) UnmarshalBinary(data []byte) error {
 	var swapLen, inFileLen, outNameLen, msgLen uint32
 	// GH-Cp gen: Added variables for FileContent and ServerFilePath lengths
 	var fileContentLen, serverFilePathLen uint32
+	var controlLen uint32
+
+	// Check the payload was written with the same layout
+	var magic [4]byte
+	var version uint16
+	err := binary.Read(r, binary.LittleEndian, &magic)
+	if err != nil {
+		return err
+	}
+	if magic != payloadMagic {
+		return fmt.Errorf("%w: payload of a release without protocol version", ErrProtocolVersion)
+	}
+	err = binary.Read(r, binary.LittleEndian, &version)
+	if err != nil {
+		return err
+	}
+	if version != ProtocolVersion {
+		return fmt.Errorf("%w: got version %d, expected %d", ErrProtocolVersion, version, ProtocolVersion)
+	}
 
-	err := binary.Read(r, binary.LittleEndian, &swapLen)
+	err = binary.Read(r, binary.LittleEndian, &swapLen)
 	if err != nil {
 		return err
 	}
@@ -112,6 +164,10 @@ func (p *Payload) UnmarshalBinary(data []byte) error {
 	if err != nil {
 		return err
 	}
+	err = binary.Read(r, binary.LittleEndian, &controlLen)
+	if err != nil {
+		return err
+	}
 
 	// Allocate slices of the appropriate size if they don't match
 	if len(p.Swap) != int(swapLen) {
@@ -133,6 +189,9 @@ func (p *Payload) UnmarshalBinary(data []byte) error {
 	if len(p.ServerFilePath) != int(serverFilePathLen) {
 		p.ServerFilePath = make([]byte, serverFilePathLen)
 	}
+	if len(p.Control) != int(controlLen) {
+		p.Control = make([]byte, controlLen)
+	}
 
 	// Read the fields from the buffer
 	err = binary.Read(r, binary.LittleEndian, &p.Swap)
@@ -164,6 +223,14 @@ func (p *Payload) UnmarshalBinary(data []byte) error {
 	if err != nil {
 		return err
 	}
+	err = binary.Read(r, binary.LittleEndian, &p.Control)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(r, binary.LittleEndian, &p.CallTime)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -193,12 +260,14 @@ func (p Payload) String() string {
 		"avcOUTNAME: '%s'\n"+
 		"avcMSG:     '%s'\n"+
 		"ServerFilePath: '%s'\n"+
-		"FileContent: [%d bytes]\n",
+		"FileContent: [%d bytes]\n"+
+		"Control: '%s'\n",
 		p.Swap[:129],
 		p.Fail,
 		p.InFile[:i0InFile],
 		p.OutName[:i0OutName],
 		p.Msg[:i0Msg],
 		p.ServerFilePath[:i0ServerFilePath],
-		len(p.FileContent))
+		len(p.FileContent),
+		p.Control)
 }
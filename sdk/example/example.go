@@ -0,0 +1,97 @@
+// Package example registers the "example" Go controller, a variable speed
+// controller which sets the generator torque proportional to the square of
+// the generator speed and holds the blades at fine pitch. It shows the
+// structure of a Go controller and is not meant to control a turbine.
+package example
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"discon-wrapper/sdk"
+)
+
+func init() {
+	sdk.Register("example", func() sdk.Controller { return &Controller{} })
+}
+
+// Default settings, those of the NREL 5-MW reference turbine
+const (
+	defaultGain      = 2.332287 // Torque gain [Nm/(rad/s)^2]
+	defaultMaxTorque = 47402.91 // Maximum generator torque [Nm]
+	defaultFinePitch = 0        // Pitch angle held [rad]
+)
+
+// Controller holds the settings and state of one simulation
+type Controller struct {
+	Gain      float64
+	MaxTorque float64
+	FinePitch float64
+
+	steps int
+}
+
+// Init reads the settings from the input file, if one is given. The file
+// holds lines of a value and its name, e.g. "2.33 gain", in the style of
+// ROSCO input files.
+func (c *Controller) Init(r *sdk.SwapRecord) error {
+	c.Gain, c.MaxTorque, c.FinePitch = defaultGain, defaultMaxTorque, defaultFinePitch
+	if r.InFile != "" {
+		if err := c.readSettings(r.InFile); err != nil {
+			return err
+		}
+	}
+	if r.Get(sdk.SwapPitchControl) != 0 {
+		return fmt.Errorf("example controller only supports collective pitch")
+	}
+	return c.Step(r)
+}
+
+func (c *Controller) readSettings(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	settings := map[string]*float64{"gain": &c.Gain, "max_torque": &c.MaxTorque, "fine_pitch": &c.FinePitch}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if setting, ok := settings[strings.ToLower(fields[1])]; ok {
+			value, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return fmt.Errorf("%s: invalid %s %q", path, fields[1], fields[0])
+			}
+			*setting = value
+		}
+	}
+	return scanner.Err()
+}
+
+// Step sets the torque and pitch demands
+func (c *Controller) Step(r *sdk.SwapRecord) error {
+	speed := float64(r.Get(sdk.SwapGeneratorSpeed))
+	torque := min(c.Gain*speed*speed, c.MaxTorque)
+
+	r.Set(sdk.SwapTorqueDemand, float32(torque))
+	r.Set(sdk.SwapPitchDemand, float32(c.FinePitch))
+	r.Set(sdk.SwapContactor, 1)
+	r.Set(sdk.SwapShaftBrake, 0)
+	r.Set(sdk.SwapPitchOverride, 0)
+	r.Set(sdk.SwapTorqueOverride, 0)
+	c.steps++
+	return nil
+}
+
+// Final reports the number of calls
+func (c *Controller) Final(r *sdk.SwapRecord) error {
+	r.Message = fmt.Sprintf("example controller called %d times", c.steps+1)
+	return nil
+}
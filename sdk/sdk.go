@@ -0,0 +1,134 @@
+// Package sdk implements controllers in Go which discon-server serves like
+// controller libraries. A controller registers a factory under an alias, and
+// clients address it with the library path "go:<alias>". Each connection gets
+// its own controller instance, called in order like the DISCON procedure.
+package sdk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/utils"
+)
+
+// Controller is called with the arguments of every DISCON call of a
+// simulation. Init is called for the first call (avrSWAP(1) = 0), Final for
+// the last (avrSWAP(1) = -1) and Step for all others. Returning an error fails
+// the call with the error as message, which stops the simulation.
+type Controller interface {
+	Init(r *SwapRecord) error
+	Step(r *SwapRecord) error
+	Final(r *SwapRecord) error
+}
+
+// Factory creates a controller instance for a new connection
+type Factory func() Controller
+
+// PathPrefix marks a library path naming a registered Go controller
+const PathPrefix = "go:"
+
+// Controllers registered by alias
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+// Register makes a controller available under an alias, usually from the
+// init function of the package implementing it. It panics if the alias is
+// empty or already registered.
+func Register(alias string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if alias == "" || factory == nil {
+		panic("sdk: Register needs an alias and a factory")
+	}
+	if _, exists := registry[alias]; exists {
+		panic(fmt.Sprintf("sdk: controller %q registered twice", alias))
+	}
+	registry[alias] = factory
+}
+
+// Lookup returns the factory of the controller registered under an alias
+func Lookup(alias string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[alias]
+	return factory, ok
+}
+
+// Aliases returns the aliases of the registered controllers in order
+func Aliases() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	aliases := make([]string, 0, len(registry))
+	for alias := range registry {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// AliasFromPath returns the alias of a library path naming a Go controller
+func AliasFromPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, PathPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(path, PathPrefix), true
+}
+
+// Dispatch calls the controller with the arguments in the payload, which are
+// updated in place. A panic in the controller fails the call rather than the
+// server.
+func Dispatch(c Controller, payload *dw.Payload) {
+	r := &SwapRecord{
+		Swap:    payload.Swap,
+		InFile:  utils.ExtractStringFromBytes(payload.InFile),
+		OutName: utils.ExtractStringFromBytes(payload.OutName),
+	}
+
+	err := call(c, r)
+	switch {
+	case err != nil:
+		payload.Fail = -1
+		setMsg(payload.Msg, err.Error())
+	case r.Message != "":
+		payload.Fail = 1
+		setMsg(payload.Msg, r.Message)
+	default:
+		payload.Fail = 0
+		setMsg(payload.Msg, "")
+	}
+}
+
+// call dispatches a call by its status, recovering from a panic
+func call(c Controller, r *SwapRecord) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("controller panicked: %v", p)
+		}
+	}()
+
+	if len(r.Swap) == 0 {
+		return fmt.Errorf("avrSWAP is empty")
+	}
+	switch r.Status() {
+	case StatusInit:
+		return c.Init(r)
+	case StatusFinal:
+		return c.Final(r)
+	default:
+		return c.Step(r)
+	}
+}
+
+// setMsg copies a message into the null-terminated avcMSG buffer, truncating it if needed
+func setMsg(buf []byte, msg string) {
+	if len(buf) == 0 {
+		return
+	}
+	n := copy(buf[:len(buf)-1], msg)
+	buf[n] = 0
+}
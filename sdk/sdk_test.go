@@ -0,0 +1,135 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+// recorder counts the calls by kind and fails or panics at a given time
+type recorder struct {
+	calls  []string
+	failAt float64
+}
+
+func (c *recorder) Init(r *SwapRecord) error {
+	c.calls = append(c.calls, "init:"+r.InFile)
+	return nil
+}
+
+func (c *recorder) Step(r *SwapRecord) error {
+	c.calls = append(c.calls, "step")
+	switch r.Time() {
+	case c.failAt:
+		return errors.New("speed limit exceeded")
+	case -c.failAt:
+		r.Set(400, 1)
+	}
+	r.Set(SwapTorqueDemand, 100)
+	return nil
+}
+
+func (c *recorder) Final(r *SwapRecord) error {
+	c.calls = append(c.calls, "final")
+	r.Message = "done"
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	c := &recorder{failAt: 3}
+	call := func(status, time float32) *dw.Payload {
+		payload := &dw.Payload{
+			Swap:   make([]float32, 130),
+			InFile: []byte("DISCON.IN\x00"),
+			Msg:    make([]byte, 16),
+		}
+		payload.Swap[0], payload.Swap[1] = status, time
+		Dispatch(c, payload)
+		return payload
+	}
+
+	if p := call(StatusInit, 0); p.Fail != 0 {
+		t.Errorf("Init failed with %d", p.Fail)
+	}
+	if p := call(StatusStep, 1); p.Fail != 0 || p.Swap[46] != 100 {
+		t.Errorf("Expected the torque demand to be set, got fail %d and %g", p.Fail, p.Swap[46])
+	}
+	if p := call(StatusStep, 3); p.Fail != -1 || utils.ExtractStringFromBytes(p.Msg) != "speed limit exc" {
+		t.Errorf("Expected the error as truncated message, got fail %d and %q", p.Fail, p.Msg)
+	}
+	if p := call(StatusStep, -3); p.Fail != -1 {
+		t.Errorf("Expected an index beyond avrSWAP to fail the call, got %d", p.Fail)
+	}
+	if p := call(StatusFinal, 4); p.Fail != 1 || utils.ExtractStringFromBytes(p.Msg) != "done" {
+		t.Errorf("Expected the message as warning, got fail %d and %q", p.Fail, p.Msg)
+	}
+
+	want := []string{"init:DISCON.IN", "step", "step", "step", "final"}
+	if len(c.calls) != len(want) {
+		t.Fatalf("Expected calls %v, got %v", want, c.calls)
+	}
+	for i := range want {
+		if c.calls[i] != want[i] {
+			t.Errorf("Expected calls %v, got %v", want, c.calls)
+			break
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("recorder", func() Controller { return &recorder{} })
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "recorder")
+		registryMu.Unlock()
+	}()
+
+	alias, ok := AliasFromPath("go:recorder")
+	if !ok || alias != "recorder" {
+		t.Fatalf("Expected alias recorder, got %q", alias)
+	}
+	if _, ok := Lookup(alias); !ok {
+		t.Error("Expected the controller to be registered")
+	}
+	if _, ok := AliasFromPath("/controllers/libdiscon.so"); ok {
+		t.Error("Expected a library path not to name a Go controller")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering an alias twice to panic")
+		}
+	}()
+	Register("recorder", func() Controller { return &recorder{} })
+}
+
+func TestSwapIndices(t *testing.T) {
+	// The indices name the same entries as the columns of a trace
+	for index, name := range map[int]string{
+		SwapStatus:          "status",
+		SwapTime:            "time",
+		SwapCommInterval:    "comm_interval",
+		SwapBlade1Pitch:     "blade1_pitch",
+		SwapShaftPower:      "shaft_power",
+		SwapElectricalPower: "electrical_power",
+		SwapGeneratorSpeed:  "gen_speed",
+		SwapRotorSpeed:      "rotor_speed",
+		SwapGeneratorTorque: "gen_torque",
+		SwapWindSpeed:       "hub_wind_speed",
+		SwapPitchControl:    "pitch_control_type",
+		SwapContactor:       "gen_contactor",
+		SwapShaftBrake:      "shaft_brake",
+		SwapPitchDemand:     "collective_pitch_demand",
+		SwapTorqueDemand:    "gen_torque_demand",
+		SwapPitchOverride:   "pitch_override",
+		SwapTorqueOverride:  "torque_override",
+	} {
+		if expected := fmt.Sprintf("%d_%s", index, name); trace.SwapName(index-1) != expected {
+			t.Errorf("Expected avrSWAP index %d to be named %s, got %s", index, expected, trace.SwapName(index-1))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package sdk
+
+// Values of the status flag avrSWAP(1)
+const (
+	StatusInit  = 0  // First call of the simulation
+	StatusStep  = 1  // Call at a time step
+	StatusFinal = -1 // Last call of the simulation
+)
+
+// Bladed avrSWAP indices, 1-based as in the controller interface
+// documentation, of the entries most controllers use
+const (
+	SwapStatus          = 1  // Status flag
+	SwapTime            = 2  // Current time [s]
+	SwapCommInterval    = 3  // Communication interval [s]
+	SwapBlade1Pitch     = 4  // Blade 1 pitch angle [rad]
+	SwapShaftPower      = 14 // Measured shaft power [W]
+	SwapElectricalPower = 15 // Measured electrical power output [W]
+	SwapGeneratorSpeed  = 20 // Measured generator speed [rad/s]
+	SwapRotorSpeed      = 21 // Measured rotor speed [rad/s]
+	SwapGeneratorTorque = 23 // Measured generator torque [Nm]
+	SwapWindSpeed       = 27 // Hub wind speed [m/s]
+	SwapPitchControl    = 28 // Pitch control: 0 = collective, 1 = individual
+	SwapContactor       = 35 // Generator contactor status
+	SwapShaftBrake      = 36 // Shaft brake status
+	SwapPitchDemand     = 45 // Demanded collective pitch angle [rad]
+	SwapTorqueDemand    = 47 // Demanded generator torque [Nm]
+	SwapPitchOverride   = 55 // Pitch override
+	SwapTorqueOverride  = 56 // Torque override
+)
+
+// SwapRecord holds the arguments of a DISCON call. The avrSWAP array is read
+// and written in place with the 1-based indices of Get and Set, an index
+// beyond the array panics, which fails the call.
+type SwapRecord struct {
+	Swap    []float32 // avrSWAP, 0-based
+	InFile  string    // Controller input file (accINFILE), a path on the server
+	OutName string    // Root name of the simulation output files (avcOUTNAME)
+
+	// Returned in avcMSG. If the call returns no error the message is passed
+	// to the simulation as a warning.
+	Message string
+}
+
+// Get returns the avrSWAP entry at a 1-based index
+func (r *SwapRecord) Get(index int) float32 {
+	return r.Swap[index-1]
+}
+
+// Set sets the avrSWAP entry at a 1-based index
+func (r *SwapRecord) Set(index int, value float32) {
+	r.Swap[index-1] = value
+}
+
+// Status returns the status flag of the call
+func (r *SwapRecord) Status() int {
+	return int(r.Get(SwapStatus))
+}
+
+// Time returns the simulation time of the call in seconds
+func (r *SwapRecord) Time() float64 {
+	return float64(r.Get(SwapTime))
+}
+
+// CommInterval returns the communication interval, the time between calls in seconds
+func (r *SwapRecord) CommInterval() float64 {
+	return float64(r.Get(SwapCommInterval))
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"discon-wrapper/shared/utils"
+)
+
+// FileCache stores transferred files by the SHA-256 hash of their content so
+// that they are uploaded once and shared by all sessions. Sessions get a copy
+// of a cached file, so a controller modifying its input files can't change the
+// cache. The total size is bounded, the least recently used files are evicted
+// first.
+type FileCache struct {
+	dir     string
+	maxSize int64
+	size    int64
+	lru     *list.List               // Front is the most recently used entry
+	entries map[string]*list.Element // Keyed by hash
+	mutex   sync.Mutex
+	logger  *utils.DebugLogger
+}
+
+// Incomplete uploads older than this are removed when the server starts
+const partialUploadAge = 24 * time.Hour
+
+type cacheEntry struct {
+	hash string
+	size int64
+}
+
+// NewFileCache opens the cache directory, creating it if needed, and indexes
+// the files left by a previous run ordered by their modification time
+func NewFileCache(dir string, maxSize int64, logger *utils.DebugLogger) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %w", err)
+	}
+
+	c := &FileCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		logger:  logger,
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory: %w", err)
+	}
+
+	type existingFile struct {
+		hash    string
+		size    int64
+		modTime time.Time
+	}
+	var existing []existingFile
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			// Left over from an interrupted write
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if strings.HasPrefix(name, ".part-") {
+			// Keep incomplete uploads for a day so that they can be resumed
+			if info, err := dirEntry.Info(); err == nil && time.Since(info.ModTime()) > partialUploadAge {
+				os.Remove(filepath.Join(dir, name))
+			}
+			continue
+		}
+		if !dirEntry.Type().IsRegular() || validateHash(name) != nil {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, existingFile{name, info.Size(), info.ModTime()})
+	}
+
+	// Oldest first, so the most recently used file ends up at the front
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.Before(existing[j].modTime) })
+	for _, file := range existing {
+		c.entries[file.hash] = c.lru.PushFront(&cacheEntry{file.hash, file.size})
+		c.size += file.size
+	}
+
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+
+	logger.Debug("File cache %s holds %d files (%d of %d bytes)", dir, len(c.entries), c.size, c.maxSize)
+	return c, nil
+}
+
+// validateHash checks that a hash is a hex-encoded SHA-256 digest
+func validateHash(hash string) error {
+	if len(hash) != 64 {
+		return fmt.Errorf("invalid hash %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil || strings.ToLower(hash) != hash {
+		return fmt.Errorf("invalid hash %q", hash)
+	}
+	return nil
+}
+
+func (c *FileCache) path(hash string) string {
+	return filepath.Join(c.dir, hash)
+}
+
+// Place copies the cached file with the given hash to target and marks it as
+// recently used. It returns false if the file is not in the cache.
+func (c *FileCache) Place(hash, target string) (bool, error) {
+	source := c.open(hash)
+	if source == nil {
+		return false, nil
+	}
+	defer source.Close()
+
+	// Copied without holding the lock, the open file keeps its content if
+	// it's evicted meanwhile
+	if err := copyFile(source, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// open opens the cached file with the given hash and marks it as recently
+// used. It returns nil if the file is not in the cache.
+func (c *FileCache) open(hash string) *os.File {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[hash]
+	if !ok {
+		return nil
+	}
+
+	// Drop the entry if the file was removed or modified outside the cache
+	entry := element.Value.(*cacheEntry)
+	info, err := os.Stat(c.path(hash))
+	if err != nil || info.Size() != entry.size {
+		c.logger.Error("Cached file %s is missing or modified, removing it from the cache", hash[:8])
+		c.remove(element)
+		return nil
+	}
+	file, err := os.Open(c.path(hash))
+	if err != nil {
+		c.logger.Error("Failed to open cached file %s: %v", hash[:8], err)
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+	now := time.Now()
+	os.Chtimes(c.path(hash), now, now)
+	return file
+}
+
+// Store moves a complete upload, already verified against its hash, into the
+// cache and places it at target. Files larger than the cache are only moved to target.
+func (c *FileCache) Store(hash, filePath, target string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.maxSize {
+		return moveFile(filePath, target)
+	}
+
+	if err := c.add(hash, filePath, info.Size()); err != nil {
+		return err
+	}
+	found, err := c.Place(hash, target)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("file %s was evicted from the cache", hash[:8])
+	}
+	return nil
+}
+
+// add moves a file into the cache, unless the content is cached already
+func (c *FileCache) add(hash, filePath string, size int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[hash]; ok {
+		// Uploaded by another session in the meantime
+		os.Remove(filePath)
+		return nil
+	}
+	if err := os.Chmod(filePath, 0444); err != nil {
+		return err
+	}
+	if err := os.Rename(filePath, c.path(hash)); err != nil {
+		return fmt.Errorf("error adding file to cache: %w", err)
+	}
+	c.entries[hash] = c.lru.PushFront(&cacheEntry{hash, size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// PartialPath returns the path an incomplete upload of the file with the given
+// hash is stored at, so that it can be resumed by a later connection
+func (c *FileCache) PartialPath(hash string) string {
+	return c.path(".part-" + hash)
+}
+
+// AddFile adds an existing file, e.g. one unpacked from a bundle, to the cache.
+// The file is copied to a temporary file in the cache directory while it's
+// hashed, and renamed once the hash is known.
+func (c *FileCache) AddFile(filePath string) error {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.maxSize {
+		return nil
+	}
+
+	// Copy rather than link, the session file may be replaced or modified
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), source)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	if element, ok := c.entries[key]; ok {
+		os.Remove(tmp.Name())
+		c.lru.MoveToFront(element)
+		return nil
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits its size limit.
+// Sessions are unaffected as they hold copies of the files.
+func (c *FileCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		element := c.lru.Back()
+		c.logger.Debug("Evicting %s from the file cache", element.Value.(*cacheEntry).hash[:8])
+		c.remove(element)
+	}
+}
+
+func (c *FileCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.hash)
+	c.size -= entry.size
+	if err := os.Remove(c.path(entry.hash)); err != nil && !os.IsNotExist(err) {
+		c.logger.Error("Failed to remove cached file %s: %v", entry.hash[:8], err)
+	}
+}
+
+// copyFile replaces target with a copy of the content read from source
+func copyFile(source io.Reader, target string) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, source); err != nil {
+		dst.Close()
+		os.Remove(target)
+		return err
+	}
+	return dst.Close()
+}
+
+// moveFile replaces target with the file
+func moveFile(filePath, target string) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(filePath, target)
+}
+
+// writeNewFile replaces target with the content
+func writeNewFile(target string, content []byte) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"discon-wrapper/shared/utils"
+)
+
+func TestFileCacheEviction(t *testing.T) {
+	logger := utils.NewDebugLogger(0, "discon-server")
+	dir := t.TempDir()
+	session := t.TempDir()
+
+	// Room for two of the three files
+	cache, err := NewFileCache(filepath.Join(dir, "cache"), 20, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := [][]byte{[]byte("first file"), []byte("second fil"), []byte("third file")}
+	hashes := make([]string, len(contents))
+	for i, content := range contents {
+		hashes[i] = utils.ComputeFileHash(content)
+	}
+
+	// Stores an upload of the content as if it was received from a client
+	store := func(i int) error {
+		upload := filepath.Join(dir, "upload")
+		if err := os.WriteFile(upload, contents[i], 0644); err != nil {
+			t.Fatal(err)
+		}
+		return cache.Store(hashes[i], upload, filepath.Join(session, hashes[i]))
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Use the first file so that the second is the least recently used
+	if found, err := cache.Place(hashes[0], filepath.Join(session, "first")); !found || err != nil {
+		t.Fatalf("Expected first file to be cached, got %v, %v", found, err)
+	}
+	if err := store(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := cache.Place(hashes[1], filepath.Join(session, "second")); found {
+		t.Error("Expected second file to be evicted")
+	}
+	if found, _ := cache.Place(hashes[0], filepath.Join(session, "first")); !found {
+		t.Error("Expected first file to remain cached")
+	}
+
+	// Sessions get copies, modifying them doesn't change the cached file
+	if err := os.WriteFile(filepath.Join(session, "first"), []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.Place(hashes[0], filepath.Join(session, "again"))
+	if content, _ := os.ReadFile(filepath.Join(session, "again")); string(content) != "first file" {
+		t.Errorf("Expected the cached file to be unchanged, got %q", content)
+	}
+
+	// Files placed in a session survive eviction
+	if content, err := os.ReadFile(filepath.Join(session, hashes[1])); err != nil || string(content) != "second fil" {
+		t.Errorf("Expected evicted file to remain in the session, got %q, %v", content, err)
+	}
+}
+
+func TestFileCacheAddFile(t *testing.T) {
+	logger := utils.NewDebugLogger(0, "discon-server")
+	dir := t.TempDir()
+	session := t.TempDir()
+
+	cache, err := NewFileCache(filepath.Join(dir, "cache"), 20, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a bundled file twice, and one larger than the cache
+	content := []byte("bundled file")
+	bundled := filepath.Join(session, "bundled.txt")
+	large := filepath.Join(session, "large.txt")
+	if err := os.WriteFile(bundled, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(large, make([]byte, 21), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{bundled, bundled, large} {
+		if err := cache.AddFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the bundled file is cached, without temporary files left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := utils.ComputeFileHash(content)
+	if len(entries) != 1 || entries[0].Name() != hash {
+		t.Fatalf("Expected only %s in the cache, got %v", hash[:8], entries)
+	}
+	if found, err := cache.Place(hash, filepath.Join(session, "placed.txt")); !found || err != nil {
+		t.Fatalf("Expected bundled file to be cached, got %v, %v", found, err)
+	}
+	placed, err := os.ReadFile(filepath.Join(session, "placed.txt"))
+	if err != nil || string(placed) != string(content) {
+		t.Errorf("Expected placed file to hold %q, got %q, %v", content, placed, err)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/utils"
+)
+
+// Marks the end of a call's output in a captured stream, followed by its number
+const consoleMark = "\x00discon-console-mark "
+
+// Time to wait for the output of a call to be read from the pipes
+const consoleDrainTimeout = time.Second
+
+// Console captures the standard output and error of the controllers, which
+// share the server process, and forwards the lines printed during a call to
+// the client of the session. The streams are shared, so lines printed while
+// calls of several sessions run can't be attributed to one of them and are
+// not forwarded; the sessions receiving the output are sent a notice of the
+// lines they missed. All output is still written to the server's console.
+type Console struct {
+	streams []*library.Output
+
+	mu       sync.Mutex
+	calls    map[int32]*consoleSession // Sessions in a controller call
+	marks    map[uint64]chan struct{}  // Closed once the mark was read
+	nextMark uint64
+}
+
+// Number of lines of a call queued for the client, further lines are dropped
+const consoleQueueSize = 1024
+
+// consoleSession forwards the output of the controller calls of one session.
+// The readers of the streams queue the lines of a call, which the session
+// sends to its client once the call ended, so that no lock is held while the
+// lines are written to the connection.
+type consoleSession struct {
+	console *Console
+	id      int32
+	send    func(line utils.ConsoleLine) // nil if the client doesn't receive the output
+	queue   chan utils.ConsoleLine
+
+	// Guarded by the console's mutex
+	time         float32 // Simulation time of the current call
+	overflow     int     // Lines of the current call which didn't fit the queue
+	unattributed int     // Lines printed while calls of other sessions ran
+}
+
+// CaptureConsole redirects the standard output and error of the process to
+// the console. The logs of the server keep going to the original streams.
+func CaptureConsole() (*Console, error) {
+	stdout, stderr, err := library.CaptureOutput()
+	if err != nil {
+		return nil, err
+	}
+	os.Stdout, os.Stderr = stdout.Original, stderr.Original
+	log.SetOutput(os.Stderr)
+
+	c := &Console{
+		streams: []*library.Output{stdout, stderr},
+		calls:   make(map[int32]*consoleSession),
+		marks:   make(map[uint64]chan struct{}),
+	}
+	for _, stream := range c.streams {
+		go c.read(stream)
+	}
+	return c, nil
+}
+
+// read passes the lines of a captured stream on until the pipe is closed
+func (c *Console) read(stream *library.Output) {
+	reader := bufio.NewReader(stream.Pipe)
+	for {
+		line, err := reader.ReadString('\n')
+		text, mark, marked := strings.Cut(line, consoleMark)
+		if text != "" {
+			stream.Original.WriteString(text)
+			if marked && !strings.HasSuffix(text, "\n") {
+				stream.Original.WriteString("\n")
+			}
+			c.forward(stream.Name, strings.TrimSuffix(text, "\n"))
+		}
+		if marked {
+			c.reached(strings.TrimSuffix(mark, "\n"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// forward queues a line for the only session in a call. Lines printed while
+// several sessions are in a call are counted for those receiving the output.
+func (c *Console) forward(stream, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, session := range c.calls {
+		if session.send == nil {
+			continue
+		}
+		if len(c.calls) != 1 {
+			session.unattributed++
+			continue
+		}
+		select {
+		case session.queue <- utils.ConsoleLine{Stream: stream, Time: session.time, Text: text}:
+		default:
+			session.overflow++
+		}
+	}
+}
+
+// reached signals that the output before a mark was read
+func (c *Console) reached(mark string) {
+	n, err := strconv.ParseUint(mark, 10, 64)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if done, ok := c.marks[n]; ok {
+		close(done)
+		delete(c.marks, n)
+	}
+}
+
+// session returns the console of a session, whose calls send the output
+// printed during them with send. Calls of sessions which don't receive the
+// output, with a nil send, are only tracked so that the output of concurrent
+// calls isn't attributed to another session.
+func (c *Console) session(id int32, send func(line utils.ConsoleLine)) *consoleSession {
+	s := &consoleSession{console: c, id: id, send: send}
+	if send != nil {
+		s.queue = make(chan utils.ConsoleLine, consoleQueueSize)
+	}
+	return s
+}
+
+// begin marks the start of a controller call at a simulation time. The
+// returned function marks its end and sends the output printed during the
+// call. Calls of sessions which don't receive the output end without
+// flushing the output.
+func (s *consoleSession) begin(time float32) (end func()) {
+	c := s.console
+	c.mu.Lock()
+	s.time = time
+	c.calls[s.id] = s
+	c.mu.Unlock()
+
+	return func() {
+		if s.send != nil {
+			library.FlushOutput()
+			c.drain()
+		}
+
+		c.mu.Lock()
+		delete(c.calls, s.id)
+		overflow, unattributed := s.overflow, s.unattributed
+		s.overflow, s.unattributed = 0, 0
+		c.mu.Unlock()
+
+		if s.send == nil {
+			return
+		}
+
+		// No line is queued once the call was removed, so the queue is emptied
+		// without holding the lock
+		for len(s.queue) > 0 {
+			s.send(<-s.queue)
+		}
+		if overflow > 0 {
+			s.notice(fmt.Sprintf("%d more lines printed during the call were not forwarded, see the console of the server", overflow))
+		}
+		if unattributed > 0 {
+			s.notice(fmt.Sprintf("%d lines printed while controllers of other connections were in a call were not forwarded, see the console of the server", unattributed))
+		}
+	}
+}
+
+// notice sends a message of the server about the output of the current call
+func (s *consoleSession) notice(text string) {
+	s.send(utils.ConsoleLine{Stream: "stderr", Time: s.time, Text: "discon-server: " + text})
+}
+
+// callTime returns the simulation time of a call, avrSWAP(2)
+func callTime(payload *dw.Payload) float32 {
+	if len(payload.Swap) < 2 {
+		return 0
+	}
+	return payload.Swap[1]
+}
+
+// drain waits until the output written so far was read from the pipes
+func (c *Console) drain() {
+	marks := make([]uint64, len(c.streams))
+	pending := make([]chan struct{}, len(c.streams))
+	c.mu.Lock()
+	for i := range c.streams {
+		marks[i], pending[i] = c.nextMark, make(chan struct{})
+		c.marks[marks[i]] = pending[i]
+		c.nextMark++
+	}
+	c.mu.Unlock()
+
+	// Written without holding the lock, which the readers need to empty the pipes
+	for i, stream := range c.streams {
+		fmt.Fprintf(stream.Stream, "%s%d\n", consoleMark, marks[i])
+	}
+
+	timeout := time.After(consoleDrainTimeout)
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-timeout:
+			return
+		}
+	}
+}
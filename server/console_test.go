@@ -0,0 +1,74 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/utils"
+)
+
+func TestConsole(t *testing.T) {
+	// Console reading a pipe in place of the redirected standard output
+	pipe, stream, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	original, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+	c := &Console{
+		streams: []*library.Output{{Name: "stdout", Pipe: pipe, Stream: stream, Original: original}},
+		calls:   make(map[int32]*consoleSession),
+		marks:   make(map[uint64]chan struct{}),
+	}
+	go c.read(c.streams[0])
+
+	// The output of a call is forwarded once it ends
+	var lines []utils.ConsoleLine
+	session := c.session(1, func(line utils.ConsoleLine) { lines = append(lines, line) })
+	end := session.begin(0.5)
+	stream.WriteString("first\n")
+	end()
+	if len(lines) != 1 || lines[0] != (utils.ConsoleLine{Stream: "stdout", Time: 0.5, Text: "first"}) {
+		t.Errorf("Unexpected lines %v", lines)
+	}
+
+	// Output printed while calls of two sessions run can't be attributed, the
+	// session receiving the output gets a notice instead, and the call of a
+	// session which doesn't receive the output isn't drained
+	lines = nil
+	endOther := c.session(2, nil).begin(0)
+	end = session.begin(1)
+	stream.WriteString("second\n")
+	end()
+	marks := c.nextMark
+	endOther()
+	if c.nextMark != marks {
+		t.Error("Expected a call without console output to end without draining")
+	}
+	if len(lines) != 1 || lines[0].Stream != "stderr" || !strings.Contains(lines[0].Text, "1 lines printed while controllers of other connections") {
+		t.Errorf("Expected a notice of the unattributed line, got %v", lines)
+	}
+
+	// Lines beyond the queue are dropped with a notice
+	lines = nil
+	end = session.begin(2)
+	for i := 0; i <= consoleQueueSize; i++ {
+		stream.WriteString("third\n")
+	}
+	end()
+	if len(lines) != consoleQueueSize+1 || !strings.Contains(lines[consoleQueueSize].Text, "1 more lines printed during the call") {
+		t.Errorf("Expected %d lines and a notice of the dropped line, got %d lines", consoleQueueSize, len(lines))
+	}
+
+	// Everything is written to the original stream
+	content, _ := os.ReadFile(original.Name())
+	if expected := "first\nsecond\n" + strings.Repeat("third\n", consoleQueueSize+1); string(content) != expected {
+		t.Errorf("Unexpected console output %q", content)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+
+	dw "discon-wrapper"
+	"discon-wrapper/sdk"
+	"discon-wrapper/shared/library"
+)
+
+// controller answers the controller calls of a connection
+type controller interface {
+	// Call calls the controller with the arguments in the payload, which are updated in place
+	Call(payload *dw.Payload)
+}
+
+// libraryController calls a controller library loaded by the server. After a
+// crash, the calls fail with the summary of the crash report.
+type libraryController struct {
+	lib   *library.Library
+	calls uint64
+	crash *CrashReport
+
+	// crashed completes and saves the report of a crash
+	crashed func(report *CrashReport)
+}
+
+func (c *libraryController) Call(payload *dw.Payload) {
+	if c.crash != nil {
+		payload.Fail = -1
+		setPayloadMsg(payload, "discon-server: "+c.crash.Summary())
+		return
+	}
+
+	var status, simTime float32
+	if len(payload.Swap) > 1 {
+		status, simTime = payload.Swap[0], payload.Swap[1]
+	}
+	err := c.lib.Call(payload.Swap, &payload.Fail, payload.InFile, payload.OutName, payload.Msg)
+	c.calls++
+
+	var crash *library.Crash
+	if !errors.As(err, &crash) {
+		return
+	}
+	c.crash = newCrashReport(crash, c.calls-1, status, simTime, payload.Swap, payload.InFile, payload.OutName)
+	c.crashed(c.crash)
+	payload.Fail = -1
+	setPayloadMsg(payload, "discon-server: "+c.crash.Summary())
+}
+
+// goController calls a controller implemented in Go and registered with the sdk package
+type goController struct {
+	ctrl sdk.Controller
+}
+
+func (c goController) Call(payload *dw.Payload) {
+	sdk.Dispatch(c.ctrl, payload)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/utils"
+)
+
+// CrashReport describes a crash of a controller library during a call. The
+// client receives a summary in avcMSG, the full report is saved on the server.
+type CrashReport struct {
+	Time       time.Time `json:"time"`
+	Program    string    `json:"program"`
+	Version    string    `json:"version"`
+	Host       string    `json:"host,omitempty"`
+	PID        int       `json:"pid"`
+	Session    int32     `json:"session"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	LibPath    string    `json:"lib_path"`
+	LibProc    string    `json:"lib_proc"`
+
+	Error   string `json:"error"`            // Description of the crash
+	Signal  string `json:"signal"`           // Name of the signal, e.g. SIGSEGV
+	Code    int    `json:"code"`             // Signal code
+	Reason  string `json:"reason,omitempty"` // Description of the signal code
+	Address string `json:"address"`          // Faulting address
+
+	Call    uint64    `json:"call"`     // Position of the call in the session, starting at 0
+	SimTime float32   `json:"sim_time"` // avrSWAP(2) of the call
+	Status  float32   `json:"status"`   // avrSWAP(1) of the call
+	InFile  string    `json:"in_file"`
+	OutName string    `json:"out_name"`
+	Swap    []float32 `json:"swap"` // avrSWAP when the controller crashed, possibly partially updated
+}
+
+// Summary describes the crash in a single line
+func (r *CrashReport) Summary() string {
+	return fmt.Sprintf("%s in call %d (t=%g s, status %g)", r.Error, r.Call, r.SimTime, r.Status)
+}
+
+// newCrashReport creates the report of a crash from the arguments of the call
+// and the status and time it was called with
+func newCrashReport(crash *library.Crash, call uint64, status, simTime float32, swap []float32, inFile, outName []byte) *CrashReport {
+	host, _ := os.Hostname()
+	return &CrashReport{
+		Time:    time.Now(),
+		Program: Program,
+		Version: Version,
+		Host:    host,
+		PID:     os.Getpid(),
+		Error:   crash.Error(),
+		Signal:  crash.Name(),
+		Code:    crash.Code,
+		Reason:  crash.Reason(),
+		Address: fmt.Sprintf("%#x", crash.Addr),
+		Call:    call,
+		SimTime: simTime,
+		Status:  status,
+		InFile:  utils.ExtractStringFromBytes(inFile),
+		OutName: utils.ExtractStringFromBytes(outName),
+		Swap:    append([]float32(nil), swap...),
+	}
+}
+
+// reportCrash logs the crash of a session's controller and saves the report in
+// the crash directory
+func (s *Server) reportCrash(report *CrashReport, logger *utils.DebugLogger) {
+	logger.Error("%s", report.Summary())
+	if s.CrashDir == "" {
+		return
+	}
+	path, err := saveCrashReport(s.CrashDir, report)
+	if err != nil {
+		logger.Error("Failed to save crash report: %v", err)
+		return
+	}
+	logger.LogAtLevel(0, "Crash report saved to %s", path)
+}
+
+// saveCrashReport writes a crash report as JSON file into a directory and
+// returns its path
+func saveCrashReport(dir string, r *CrashReport) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating crash directory: %w", err)
+	}
+	name := fmt.Sprintf("discon-crash-%s-%03d.json", r.Time.Format("20060102-150405"), r.Session)
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("error writing crash report: %w", err)
+	}
+	return path, nil
+}
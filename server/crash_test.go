@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/utils"
+)
+
+func TestLibraryControllerCrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("capturing crashes is not supported on Windows")
+	}
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		t.Skipf("no C compiler to build the controller: %v", err)
+	}
+
+	// Controller which writes to an unmapped address in its second call
+	dir := t.TempDir()
+	src := filepath.Join(dir, "controller.c")
+	os.WriteFile(src, []byte(`
+void crash(float *avrSWAP, int *aviFAIL, char *accINFILE, char *avcOUTNAME, char *avcMSG)
+{
+    if (avrSWAP[1] > 0)
+    {
+        *(volatile int *)0x10 = 1;
+    }
+}
+`), 0644)
+	path := filepath.Join(dir, "libcontroller.so")
+	if out, err := exec.Command(cc, "-shared", "-fPIC", "-o", path, src).CombinedOutput(); err != nil {
+		t.Fatalf("Building the controller failed: %v\n%s", err, out)
+	}
+
+	if err := library.CaptureCrashes(); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := library.Load(100, path, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Unload()
+
+	s := &Server{CrashDir: filepath.Join(dir, "crashes")}
+	logger := utils.NewDebugLogger(0, "discon-server")
+	var reported *CrashReport
+	ctrl := &libraryController{lib: lib, crashed: func(report *CrashReport) {
+		report.Session, report.LibPath, report.LibProc = 100, path, "crash"
+		reported = report
+		s.reportCrash(report, logger)
+	}}
+
+	call := func(simTime float32) *dw.Payload {
+		payload := &dw.Payload{
+			Swap:    []float32{1, simTime, 0},
+			InFile:  []byte("DISCON.IN\x00"),
+			OutName: []byte("out\x00"),
+			Msg:     make([]byte, 256),
+		}
+		ctrl.Call(payload)
+		return payload
+	}
+
+	if payload := call(0); payload.Fail != 0 || reported != nil {
+		t.Fatalf("Expected the first call to succeed, got fail %d", payload.Fail)
+	}
+
+	// The crash fails the call with its summary and is saved
+	payload := call(0.25)
+	summary := "discon-server: controller crashed with SIGSEGV (address not mapped) at address 0x10 in call 1 (t=0.25 s, status 1)"
+	if msg := utils.ExtractStringFromBytes(payload.Msg); payload.Fail != -1 || msg != summary {
+		t.Errorf("Expected the call to fail with %q, got fail %d and %q", summary, payload.Fail, msg)
+	}
+	if reported == nil {
+		t.Fatal("Expected the crash to be reported")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(s.CrashDir, "discon-crash-*-100.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected a saved crash report, got %v", files)
+	}
+	content, _ := os.ReadFile(files[0])
+	var saved CrashReport
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Signal != "SIGSEGV" || saved.Address != "0x10" || saved.Call != 1 || saved.SimTime != 0.25 ||
+		saved.InFile != "DISCON.IN" || saved.OutName != "out" || saved.LibPath != path || len(saved.Swap) != 3 {
+		t.Errorf("Unexpected crash report %+v", saved)
+	}
+
+	// Later calls fail with the same summary without calling the controller
+	if payload := call(0.5); payload.Fail != -1 || utils.ExtractStringFromBytes(payload.Msg) != summary {
+		t.Errorf("Expected later calls to fail with the crash, got %q", payload.Msg)
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkControllerEnv rejects the variables forwarded by a client. Controllers
+// share the server process, so setting them would change the environment of
+// every controller and of the server itself. discon-manager sets them in the
+// container of the connection instead, which runs its own server process.
+func checkControllerEnv(env []string) error {
+	if len(env) == 0 {
+		return nil
+	}
+	names := make([]string, len(env))
+	for i, pair := range env {
+		names[i], _, _ = strings.Cut(pair, "=")
+	}
+	return fmt.Errorf("environment variables (%s) can't be forwarded to a controller sharing the discon-server process, "+
+		"use discon-manager to run the controller in its own container with them", strings.Join(names, ", "))
+}
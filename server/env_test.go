@@ -0,0 +1,27 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckControllerEnv(t *testing.T) {
+	os.Unsetenv("DISCON_TEST_NEW")
+
+	if err := checkControllerEnv(nil); err != nil {
+		t.Errorf("Expected a connection without forwarded variables to be accepted, got %v", err)
+	}
+
+	// Forwarded variables are refused with their names, and never set
+	err := checkControllerEnv([]string{"DISCON_TEST_NEW=1", "LM_LICENSE_FILE=27000@license"})
+	if err == nil {
+		t.Fatal("Expected forwarded variables to be rejected")
+	}
+	if !strings.Contains(err.Error(), "DISCON_TEST_NEW, LM_LICENSE_FILE") || strings.Contains(err.Error(), "27000") {
+		t.Errorf("Expected the error to name the variables without their values, got %v", err)
+	}
+	if _, found := os.LookupEnv("DISCON_TEST_NEW"); found {
+		t.Error("Expected the forwarded variable not to be set")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/utils"
+)
+
+// handleOutputs lists the files created or modified by the controller in the session directory
+func handleOutputs(session *Session, msg *utils.ControlMessage, logger *utils.DebugLogger) (*dw.Payload, error) {
+	outputs, err := session.Outputs()
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to list output files: %v", err)
+		return utils.CreateControlResponse(msg, false, errMsg), err
+	}
+
+	logger.Debug("Session directory holds %d output files", len(outputs))
+
+	response := &utils.ControlMessage{Type: msg.Type, Entries: outputs}
+	return utils.CreateControlResponse(response, true, fmt.Sprintf("%d output files", len(outputs))), nil
+}
+
+// handleGet sends a chunk of a file in the session directory. The response holds
+// the size of the file, and its hash with the last chunk so that the client can
+// verify the complete file.
+func handleGet(session *Session, msg *utils.ControlMessage, logger *utils.DebugLogger) (*dw.Payload, error) {
+	if session.dir == "" || len(msg.Entries) != 1 {
+		err := fmt.Errorf("invalid get request")
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	entry := msg.Entries[0]
+
+	target, err := utils.BundleTarget(session.dir, entry.Path)
+	if err != nil {
+		errMsg := fmt.Sprintf("Security error: %v", err)
+		return utils.CreateControlResponse(msg, false, errMsg), err
+	}
+
+	length := msg.Length
+	if length <= 0 || length > utils.MaxChunkSize {
+		length = utils.MaxChunkSize
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	if msg.Offset < 0 || msg.Offset > info.Size() {
+		err := fmt.Errorf("invalid offset %d in %s", msg.Offset, entry.Path)
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	chunk := make([]byte, min(length, info.Size()-msg.Offset))
+	if _, err := file.ReadAt(chunk, msg.Offset); err != nil && err != io.EOF {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	logger.Verbose("Sending chunk of %s at offset %d (size: %d bytes)", entry.Path, msg.Offset, len(chunk))
+
+	result := utils.FileEntry{Path: entry.Path, Size: info.Size()}
+	if msg.Offset+int64(len(chunk)) == info.Size() {
+		if result.Hash, err = utils.HashFile(target); err != nil {
+			return utils.CreateControlResponse(msg, false, err.Error()), err
+		}
+		session.recordFile("download", result)
+	}
+
+	response := &utils.ControlMessage{
+		Type:      msg.Type,
+		Entries:   []utils.FileEntry{result},
+		Offset:    msg.Offset,
+		ChunkHash: utils.ComputeFileHash(chunk),
+	}
+	payload := utils.CreateControlResponse(response, true, fmt.Sprintf("Sending %s", entry.Path))
+	payload.FileContent = chunk
+	return payload, nil
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+// Recorder writes a recording of each connection into a directory, a trace of
+// the controller calls and the files transferred in the session which can be
+// read with discon-trace and replayed with discon-replay. Each recording is
+// capped in size, and the oldest recordings are removed once they exceed the
+// total size or age limit.
+type Recorder struct {
+	dir      string
+	maxSize  int64         // Size a recording is stopped at, 0 for no limit
+	maxTotal int64         // Total size of the recordings, 0 for no limit
+	maxAge   time.Duration // Age recordings are removed at, 0 for no limit
+	logger   *utils.DebugLogger
+
+	mu     sync.Mutex
+	active map[string]bool // Names of the recordings being written
+}
+
+// RecordingInfo describes a recording in the listing of the recordings
+type RecordingInfo struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Active     bool      `json:"active"` // The connection is still open
+	Session    string    `json:"session,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	LibPath    string    `json:"lib_path,omitempty"`
+	LibProc    string    `json:"lib_proc,omitempty"`
+}
+
+// NewRecorder opens the recording directory, creating it if needed, and
+// removes the recordings of a previous run beyond the limits. A limit of 0
+// disables it.
+func NewRecorder(dir string, maxSize, maxTotal int64, maxAge time.Duration, logger *utils.DebugLogger) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating recording directory: %w", err)
+	}
+	r := &Recorder{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxTotal: maxTotal,
+		maxAge:   maxAge,
+		logger:   logger,
+		active:   make(map[string]bool),
+	}
+	r.prune()
+	return r, nil
+}
+
+// recording is the recording of a single connection
+type recording struct {
+	recorder *Recorder
+	name     string
+	writer   *trace.Writer // nil once the recording stopped
+	logger   *utils.DebugLogger
+}
+
+// open creates the recording of a connection. Recording is skipped if the
+// recorder is nil or the file can't be created.
+func (r *Recorder) open(connID int32, libPath, libProc, remoteAddr string, logger *utils.DebugLogger) *recording {
+	if r == nil {
+		return nil
+	}
+	r.prune()
+
+	created := time.Now()
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("discon-%s-%03d%s", created.Format("20060102-150405"), connID, trace.FileExt)
+	meta := trace.Metadata{
+		Program: Program,
+		Version: Version,
+		Source:  "server",
+		Created: created,
+		Host:    host,
+		PID:     os.Getpid(),
+		LibPath: libPath,
+		LibProc: libProc,
+		Extra:   map[string]string{"remote_addr": remoteAddr, "session": strconv.Itoa(int(connID))},
+	}
+
+	// Marked as active first so that it isn't pruned by another connection
+	r.mu.Lock()
+	r.active[name] = true
+	r.mu.Unlock()
+	writer, err := trace.Create(filepath.Join(r.dir, name), meta)
+	if err != nil {
+		logger.Error("Failed to create recording: %v", err)
+		r.mu.Lock()
+		delete(r.active, name)
+		r.mu.Unlock()
+		return nil
+	}
+	logger.Debug("Recording the session to %s", filepath.Join(r.dir, name))
+	return &recording{recorder: r, name: name, writer: writer, logger: logger}
+}
+
+// call adds a controller call to the recording
+func (rec *recording) call(call trace.Call) {
+	rec.write(func(w *trace.Writer) error { return w.Write(call) })
+}
+
+// file adds a transferred file to the recording
+func (rec *recording) file(file trace.File) {
+	rec.write(func(w *trace.Writer) error { return w.WriteFile(file) })
+}
+
+// write adds a record and stops the recording on errors or once it reached
+// its size limit
+func (rec *recording) write(add func(w *trace.Writer) error) {
+	if rec == nil || rec.writer == nil {
+		return
+	}
+	if err := add(rec.writer); err != nil {
+		rec.logger.Error("Failed to write recording, recording stopped: %v", err)
+		rec.stop()
+		return
+	}
+	if limit := rec.recorder.maxSize; limit > 0 && rec.writer.Size() >= limit {
+		rec.logger.LogAtLevel(0, "Recording %s reached its size limit of %d bytes after %d calls, recording stopped",
+			rec.name, limit, rec.writer.Calls())
+		rec.stop()
+	}
+}
+
+func (rec *recording) stop() {
+	if rec.writer != nil {
+		rec.writer.Close()
+		rec.writer = nil
+	}
+}
+
+// close ends the recording and applies the retention limits
+func (rec *recording) close() {
+	if rec == nil {
+		return
+	}
+	rec.stop()
+	r := rec.recorder
+	r.mu.Lock()
+	delete(r.active, rec.name)
+	r.mu.Unlock()
+	r.prune()
+}
+
+// prune removes the recordings older than the age limit, then the oldest
+// recordings until the total size is within its limit. Recordings being
+// written are kept.
+func (r *Recorder) prune() {
+	if r.maxTotal <= 0 && r.maxAge <= 0 {
+		return
+	}
+	infos, err := r.list(false)
+	if err != nil {
+		r.logger.Error("Failed to list recordings: %v", err)
+		return
+	}
+
+	var total int64
+	for _, info := range infos {
+		total += info.Size
+	}
+	for _, info := range infos {
+		expired := r.maxAge > 0 && time.Since(info.Modified) > r.maxAge
+		if info.Active || !expired && (r.maxTotal <= 0 || total <= r.maxTotal) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, info.Name)); err != nil && !os.IsNotExist(err) {
+			r.logger.Error("Failed to remove recording %s: %v", info.Name, err)
+			continue
+		}
+		r.logger.Debug("Removed recording %s (size: %d bytes)", info.Name, info.Size)
+		total -= info.Size
+	}
+}
+
+// list returns the recordings ordered by their modification time, oldest
+// first, optionally with the session details from their metadata
+func (r *Recorder) list(details bool) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var infos []RecordingInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), trace.FileExt) {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		info := RecordingInfo{
+			Name:     entry.Name(),
+			Size:     fileInfo.Size(),
+			Modified: fileInfo.ModTime(),
+			Active:   r.active[entry.Name()],
+		}
+		if details {
+			if tr, err := trace.Open(filepath.Join(r.dir, entry.Name())); err == nil {
+				info.Session = tr.Metadata.Extra["session"]
+				info.RemoteAddr = tr.Metadata.Extra["remote_addr"]
+				info.LibPath, info.LibProc = tr.Metadata.LibPath, tr.Metadata.LibProc
+				tr.Close()
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Modified.Before(infos[j].Modified) })
+	return infos, nil
+}
+
+// ServeHTTP lists the recordings as JSON for the root path and downloads the
+// recording named by the path otherwise. It's mounted with the prefix removed,
+// e.g. with http.StripPrefix("/recordings/", recorder).
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := req.URL.Path
+	if name == "" {
+		infos, err := r.list(true)
+		if err != nil {
+			http.Error(w, "Error listing recordings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if infos == nil {
+			infos = []RecordingInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	}
+
+	// Only files directly in the recording directory are served
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) || !strings.HasSuffix(name, trace.FileExt) {
+		http.NotFound(w, req)
+		return
+	}
+	path := filepath.Join(r.dir, name)
+	if !utils.FileExists(path) {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, req, path)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+func TestRecorder(t *testing.T) {
+	logger := utils.NewDebugLogger(0, "discon-server")
+	dir := t.TempDir()
+
+	// Recordings of a previous run, one expired and two within the age limit
+	old := time.Now().Add(-48 * time.Hour)
+	for i, name := range []string{"expired.dtr", "oldest.dtr", "older.dtr"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, make([]byte, 400), 0644)
+		modified := time.Now().Add(time.Duration(i-3) * time.Minute)
+		if i == 0 {
+			modified = old
+		}
+		os.Chtimes(path, modified, modified)
+	}
+
+	recorder, err := NewRecorder(dir, 600, 1200, 24*time.Hour, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utils.FileExists(filepath.Join(dir, "expired.dtr")) || !utils.FileExists(filepath.Join(dir, "oldest.dtr")) {
+		t.Error("Expected only the expired recording to be removed")
+	}
+
+	// The recording stops once it reaches its size limit
+	rec := recorder.open(7, "discon.so", "DISCON", "127.0.0.1:4000", logger)
+	if rec == nil {
+		t.Fatal("Recording not created")
+	}
+	rec.file(trace.File{Direction: "upload", Path: "DISCON.IN", Size: 10, Hash: "abc", Transforms: "lf"})
+	rec.call(trace.Call{Input: trace.NewStep(make([]float32, 10), 0, nil, nil, nil)})
+	calls := rec.writer.Calls()
+	for i := 0; i < 10; i++ {
+		rec.call(trace.Call{Input: trace.NewStep(make([]float32, 10), 0, nil, nil, nil)})
+	}
+	if rec.writer != nil || calls == 0 {
+		t.Errorf("Expected the recording to stop at its size limit after the first calls, %d calls recorded", calls)
+	}
+
+	// Recordings being written are listed as active and not removed
+	infos, _ := recorder.list(true)
+	if len(infos) != 3 || !infos[2].Active || infos[2].Session != "7" || infos[2].LibPath != "discon.so" {
+		t.Errorf("Unexpected recordings %+v", infos)
+	}
+
+	// Closing the recording brings the total size over the limit
+	rec.close()
+	if utils.FileExists(filepath.Join(dir, "oldest.dtr")) || !utils.FileExists(filepath.Join(dir, "older.dtr")) {
+		t.Error("Expected the oldest recording to be removed")
+	}
+
+	r, err := trace.Open(filepath.Join(dir, rec.name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Next()
+	if len(r.Files) != 1 || r.Files[0].Hash != "abc" || r.Files[0].Transforms != "lf" {
+		t.Errorf("Expected the file record, got %+v", r.Files)
+	}
+}
+
+func TestRecorderHTTP(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, 0, 0, 0, utils.NewDebugLogger(0, "discon-server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "discon-1.dtr"), []byte("recording"), 0644)
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.dtr"), []byte("secret"), 0644)
+
+	server := httptest.NewServer(http.StripPrefix("/recordings/", recorder))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/recordings/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var infos []RecordingInfo
+	json.NewDecoder(resp.Body).Decode(&infos)
+	resp.Body.Close()
+	if len(infos) != 1 || infos[0].Name != "discon-1.dtr" || infos[0].Size != 9 {
+		t.Errorf("Unexpected listing %+v", infos)
+	}
+
+	for path, status := range map[string]int{
+		"discon-1.dtr":       http.StatusOK,
+		"missing.dtr":        http.StatusNotFound,
+		"..%2Fsecret.dtr":    http.StatusNotFound,
+		"discon-1.dtr/../..": http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + "/recordings/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+// Drifting calls logged individually, later ones are only counted
+const maxLoggedDrifts = 10
+
+// Replay holds the settings of the replay mode, in which calls are answered
+// from a trace instead of a controller
+type Replay struct {
+	Calls      []trace.Call
+	Tolerances *trace.Tolerances // Tolerances the inputs are checked with, nil if not checked
+	Strict     bool              // Fail calls whose inputs drift from the trace
+}
+
+// LoadReplay reads the trace the calls are answered from. The inputs are only
+// checked if tolerances are given.
+func LoadReplay(path, tolerances string, strict bool, logger *utils.DebugLogger) (*Replay, error) {
+	meta, calls, err := trace.ReadAll(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("%s holds no calls", path)
+	}
+	replay := &Replay{Calls: calls, Strict: strict}
+	if tolerances != "" {
+		t, err := trace.ParseTolerances(tolerances)
+		if err != nil {
+			return nil, err
+		}
+		t.IgnoreBufferSizes()
+		replay.Tolerances = &t
+	}
+
+	logger.LogAtLevel(0, "Replaying %d calls of %s recorded by %s %s for %s (%s)",
+		len(calls), path, meta.Program, meta.Version, meta.LibPath, meta.LibProc)
+	return replay, nil
+}
+
+// replayController answers the calls of a connection with the recorded outputs
+// of the calls at the same position in the trace
+type replayController struct {
+	*Replay
+	next   int
+	logger *utils.DebugLogger
+
+	drifted       int     // Calls whose inputs are outside the tolerances
+	maxDrift      float64 // Largest difference of an avrSWAP input
+	maxDriftCall  int
+	maxDriftIndex int
+}
+
+func newReplayController(replay *Replay, logger *utils.DebugLogger) *replayController {
+	logger.Debug("Answering calls from the replay trace")
+	return &replayController{Replay: replay, logger: logger}
+}
+
+func (c *replayController) Call(payload *dw.Payload) {
+	if c.next >= len(c.Calls) {
+		msg := fmt.Sprintf("discon-server: the replay trace ends after %d calls", len(c.Calls))
+		c.logger.Error("%s", msg)
+		payload.Fail = -1
+		setPayloadMsg(payload, msg)
+		return
+	}
+	call := c.Calls[c.next]
+	c.next++
+
+	if drift := c.checkInputs(call, payload); drift != "" && c.Strict {
+		payload.Fail = -1
+		setPayloadMsg(payload, fmt.Sprintf("discon-server: inputs of call %d drifted from the replay trace, %s", call.Index, drift))
+		return
+	}
+
+	// Answer with the recorded outputs, keeping the caller's buffer sizes
+	for i, v := range call.Output.Swap {
+		if i < len(payload.Swap) && !isBufferSize(i) {
+			payload.Swap[i] = v
+		}
+	}
+	payload.Fail = call.Output.Fail
+	setPayloadMsg(payload, call.Output.Msg)
+}
+
+// checkInputs compares the inputs of a call with the recorded ones and returns
+// a description of the first difference, or an empty string if they match
+func (c *replayController) checkInputs(call trace.Call, payload *dw.Payload) string {
+	if c.Tolerances == nil {
+		return ""
+	}
+
+	var drift string
+	diffs := c.Tolerances.Compare(call.Input.Swap, payload.Swap)
+	if len(payload.Swap) < len(call.Input.Swap) {
+		drift = fmt.Sprintf("avrSWAP: expected %d entries, got %d", len(call.Input.Swap), len(payload.Swap))
+	} else if payload.Fail != call.Input.Fail {
+		drift = fmt.Sprintf("aviFAIL: expected %d, got %d", call.Input.Fail, payload.Fail)
+	} else if len(diffs) > 0 {
+		drift = diffs[0].String()
+	}
+	for _, diff := range diffs {
+		if d := math.Abs(float64(diff.Got) - float64(diff.Want)); d > c.maxDrift {
+			c.maxDrift, c.maxDriftCall, c.maxDriftIndex = d, int(call.Index), diff.Index
+		}
+	}
+	if drift == "" {
+		return ""
+	}
+
+	c.drifted++
+	if c.drifted <= maxLoggedDrifts {
+		if len(diffs) > 1 {
+			drift += fmt.Sprintf(" and %d more avrSWAP entries", len(diffs)-1)
+		}
+		c.logger.LogAtLevel(0, "Inputs of call %d (t=%g s) drifted from the replay trace: %s", call.Index, callTime(payload), drift)
+		if c.drifted == maxLoggedDrifts {
+			c.logger.LogAtLevel(0, "Further drifting calls are only counted")
+		}
+	}
+	return drift
+}
+
+// Report logs the number of calls answered and the drift of their inputs
+func (c *replayController) Report() {
+	c.logger.LogAtLevel(0, "Replayed %d of %d recorded calls", c.next, len(c.Calls))
+	if c.Tolerances == nil {
+		return
+	}
+	if c.drifted == 0 {
+		c.logger.LogAtLevel(0, "Inputs of all replayed calls within tolerance")
+		return
+	}
+	c.logger.LogAtLevel(0, "Inputs of %d calls drifted from the replay trace, the largest drift was %g of %s at call %d",
+		c.drifted, c.maxDrift, trace.SwapLabel(c.maxDriftIndex), c.maxDriftCall)
+}
+
+// isBufferSize reports whether an avrSWAP entry holds the size of a string buffer
+func isBufferSize(index int) bool {
+	for _, i := range trace.BufferSizeIndices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// setPayloadMsg copies a message into the null-terminated avcMSG buffer, truncating it if needed
+func setPayloadMsg(payload *dw.Payload, msg string) {
+	if len(payload.Msg) == 0 {
+		return
+	}
+	n := copy(payload.Msg[:len(payload.Msg)-1], msg)
+	payload.Msg[n] = 0
+}
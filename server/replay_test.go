@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+func TestReplayController(t *testing.T) {
+	logger := utils.NewDebugLogger(0, "discon-server")
+	calls := []trace.Call{{
+		Input:  trace.Step{Swap: []float32{0, 0.5, 0.01}},
+		Output: trace.Step{Swap: []float32{0, 0.5, 0.01}, Fail: 1, Msg: "recorded"},
+	}}
+	tolerances, _ := trace.ParseTolerances("1e-3")
+	replay := &Replay{Calls: calls, Tolerances: &tolerances, Strict: true}
+
+	// Outputs are answered from the trace, the buffer sizes are left as sent
+	swap := make([]float32, 64)
+	swap[1], swap[2], swap[48] = 0.5001, 0.01, 64
+	payload := dw.Payload{Swap: swap, Msg: make([]byte, 256)}
+	c := newReplayController(replay, logger)
+	c.Call(&payload)
+	if payload.Fail != 1 || utils.ExtractStringFromBytes(payload.Msg) != "recorded" || payload.Swap[48] != 64 {
+		t.Errorf("Expected the recorded outputs, got fail %d, message %q", payload.Fail, payload.Msg)
+	}
+
+	// Inputs outside the tolerance fail the call in strict mode
+	swap[1] = 0.6
+	payload.Fail = 0
+	c = newReplayController(replay, logger)
+	c.Call(&payload)
+	if payload.Fail != -1 || c.drifted != 1 {
+		t.Errorf("Expected drifting call to fail, got fail %d after %d drifted calls", payload.Fail, c.drifted)
+	}
+
+	msg := utils.ExtractStringFromBytes(payload.Msg)
+	if !strings.Contains(msg, "avrSWAP[2] (time): expected 0.5, got 0.6") {
+		t.Errorf("Expected the drift to name avrSWAP[2] (time), got %q", msg)
+	}
+
+	// A call with fewer avrSWAP entries than recorded drifts instead of failing the server
+	payload = dw.Payload{Swap: []float32{0}, Msg: make([]byte, 256)}
+	c = newReplayController(replay, logger)
+	c.Call(&payload)
+	if msg := utils.ExtractStringFromBytes(payload.Msg); payload.Fail != -1 || !strings.Contains(msg, "expected 3 entries, got 1") {
+		t.Errorf("Expected short avrSWAP to drift, got fail %d, message %q", payload.Fail, msg)
+	}
+
+	// Calls beyond the end of the trace fail
+	payload.Fail = 0
+	c.Call(&payload)
+	if payload.Fail != -1 {
+		t.Errorf("Expected call beyond the end of the trace to fail, got %d", payload.Fail)
+	}
+}
@@ -0,0 +1,83 @@
+// Package server implements discon-server, which loads controller libraries
+// and answers the DISCON calls forwarded by discon-client over WebSocket
+// connections. The discon-server command configures a Server from its flags,
+// the discontest package runs one in tests.
+package server
+
+import (
+	"sync"
+
+	"discon-wrapper/sdk"
+	"discon-wrapper/shared/trace"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	Program = "discon-server"
+	Version = "v0.2.0"
+)
+
+// Server holds the settings shared by all connections. The fields must not be
+// changed once the server is serving connections.
+type Server struct {
+	DebugLevel int
+
+	Cache      *FileCache // File cache shared by all connections, nil if disabled
+	Recorder   *Recorder  // Writes a recording of each connection's calls and files, nil if disabled
+	Replay     *Replay    // Trace the calls are answered from instead of loading controllers, nil if disabled
+	SessionDir string     // Directory the session directories are created in, the working directory if empty
+	CrashDir   string     // Directory the reports of controller crashes are saved in, empty if not saved
+	Console    *Console   // Captured output of the controllers, nil if not captured
+
+	// Directories of shared drives clients may refer to files in by their
+	// server path, see DISCON_PATH_MAP of discon-client
+	SharedRoots []string
+
+	// GoController returns the factory of the Go controller served for the
+	// library path "go:<alias>", sdk.Lookup if nil
+	GoController func(alias string) (sdk.Factory, bool)
+
+	// OnCall is called with the record of every controller call, before the
+	// response is sent to the client
+	OnCall func(session int32, call trace.Call)
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// lookupGoController returns the factory of a Go controller
+func (s *Server) lookupGoController(alias string) (sdk.Factory, bool) {
+	if s.GoController != nil {
+		return s.GoController(alias)
+	}
+	return sdk.Lookup(alias)
+}
+
+// track registers an open connection so that Close can end it
+func (s *Server) track(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*websocket.Conn]bool)
+	}
+	s.conns[ws] = true
+}
+
+func (s *Server) untrack(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, ws)
+}
+
+// Close ends the open connections and waits until their controllers are
+// unloaded and their session directories removed
+func (s *Server) Close() {
+	s.mu.Lock()
+	for ws := range s.conns {
+		ws.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
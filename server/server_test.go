@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	dw "discon-wrapper"
@@ -6,8 +6,11 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
 
+	"discon-wrapper/shared/utils"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -16,8 +19,9 @@ func TestServeWs(t *testing.T) {
 	const port = 18080
 
 	// connect handler to websocket function
+	server := &Server{DebugLevel: 1}
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ServeWs(w, r, 1)
+		server.ServeWs(w, r)
 	})
 
 	// Start server in separate go routine
@@ -40,6 +44,7 @@ func TestServeWs(t *testing.T) {
 	q := u.Query()
 	q.Add("path", "../build/test-discon.dll")
 	q.Add("proc", "discon")
+	q.Add(utils.ProtocolParam, strconv.Itoa(int(dw.ProtocolVersion)))
 	u.RawQuery = q.Encode()
 
 	// Connect to websocket server
@@ -125,5 +130,5 @@ func TestServeWs(t *testing.T) {
 	ws.Close()
 
 	// Wait for server to finish
-	wg.Wait()
+	server.Close()
 }
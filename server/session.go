@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+)
+
+// Session holds the server-side state of a single client connection
+type Session struct {
+	ID     int32
+	parent string // Directory the session directory is created in
+	dir    string
+	cache  *FileCache           // Shared file cache, nil if disabled
+	shared []string             // Directories of shared drives the client may look up files in
+	placed map[string]fileState // Files placed by the client, keyed by relative path
+	onFile func(trace.File)     // Records the transferred files, nil if not recorded
+	logger *utils.DebugLogger
+}
+
+// fileState is used to detect files modified by the controller
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewSession creates the state for a new client connection. The session
+// directory is created in parent, or the working directory if it's empty.
+func NewSession(connID int32, parent string, cache *FileCache, logger *utils.DebugLogger) *Session {
+	if parent == "" {
+		parent = "."
+	}
+	return &Session{
+		ID:     connID,
+		parent: parent,
+		cache:  cache,
+		placed: make(map[string]fileState),
+		logger: logger,
+	}
+}
+
+// Dir returns the absolute path of the session directory, creating it on first use
+func (s *Session) Dir() (string, error) {
+	if s.dir != "" {
+		return s.dir, nil
+	}
+
+	dir, err := os.MkdirTemp(s.parent, fmt.Sprintf("discon-session-%03d-", s.ID))
+	if err != nil {
+		return "", fmt.Errorf("error creating session directory: %w", err)
+	}
+	s.dir, err = filepath.Abs(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	s.logger.Debug("Created session directory %s", s.dir)
+	return s.dir, nil
+}
+
+// lookupAllowed reports whether the client may look up a server path: it has
+// to be in the session directory or one of the shared directories, also once
+// symbolic links are resolved
+func (s *Session) lookupAllowed(file string) bool {
+	if !filepath.IsAbs(file) {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		// A missing file is checked by its path, the lookup doesn't find it
+		resolved = filepath.Clean(file)
+	}
+
+	roots := append([]string{s.dir}, s.shared...)
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		if utils.IsWithin(root, resolved) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkPlaced records a file placed in the session directory by the client, so
+// that it is only reported as an output if the controller modifies it
+func (s *Session) MarkPlaced(target string) {
+	rel, err := filepath.Rel(s.dir, target)
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(target); err == nil {
+		s.placed[filepath.ToSlash(rel)] = fileState{info.Size(), info.ModTime()}
+	}
+}
+
+// RecordTransforms logs the conversions the client applied to a file before
+// sending it, so that differences to the client's copy can be traced
+func (s *Session) RecordTransforms(entry utils.FileEntry) {
+	if entry.Transforms != "" {
+		s.logger.Debug("File %s was transformed by the client: %s", entry.Path, entry.Transforms)
+	}
+}
+
+// recordFile passes a file transferred in the session on to its recording
+func (s *Session) recordFile(direction string, entry utils.FileEntry) {
+	if s.onFile != nil {
+		s.onFile(trace.File{Time: time.Now(), Direction: direction, Path: entry.Path, Size: entry.Size, Hash: entry.Hash, Transforms: entry.Transforms})
+	}
+}
+
+// recordPlaced records a file placed in the session directory with the
+// transforms the client applied to it, hashing its content only if the
+// session is recorded
+func (s *Session) recordPlaced(direction, target, transforms string) {
+	if s.onFile == nil {
+		return
+	}
+	entry := utils.FileEntry{Path: filepath.Base(target), Transforms: transforms}
+	if rel, err := filepath.Rel(s.dir, target); err == nil {
+		entry.Path = filepath.ToSlash(rel)
+	}
+	if info, err := os.Stat(target); err == nil {
+		entry.Size = info.Size()
+	}
+	entry.Hash, _ = utils.HashFile(target)
+	s.recordFile(direction, entry)
+}
+
+// Outputs returns the files in the session directory which were created or
+// modified since they were placed by the client
+func (s *Session) Outputs() ([]utils.FileEntry, error) {
+	var outputs []utils.FileEntry
+	if s.dir == "" {
+		return outputs, nil
+	}
+
+	err := filepath.WalkDir(s.dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		// Skip incomplete uploads and bundle archives
+		if strings.HasPrefix(d.Name(), ".part-") || strings.HasPrefix(d.Name(), ".bundle-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if state, ok := s.placed[rel]; ok && state.size == info.Size() && state.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		outputs = append(outputs, utils.FileEntry{Path: rel, Size: info.Size()})
+		return nil
+	})
+	return outputs, err
+}
+
+// Close removes the session directory and everything in it
+func (s *Session) Close() {
+	if s.dir == "" {
+		return
+	}
+	s.logger.Debug("Removing session directory %s", s.dir)
+	if err := os.RemoveAll(s.dir); err != nil {
+		s.logger.Error("Failed to remove session directory %s: %v", s.dir, err)
+	}
+	s.dir = ""
+}
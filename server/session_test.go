@@ -0,0 +1,54 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"discon-wrapper/shared/utils"
+)
+
+func TestHandleStat(t *testing.T) {
+	shared := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(shared, "DISCON.IN"), []byte("gain"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	// Files reached through a link in a shared directory are rejected too
+	rejected := []string{
+		filepath.Join(outside, "secret"),
+		filepath.Join(shared, "..", filepath.Base(outside), "secret"),
+		"DISCON.IN",
+	}
+	if err := os.Symlink(outside, filepath.Join(shared, "link")); err == nil {
+		rejected = append(rejected, filepath.Join(shared, "link", "secret"))
+	}
+
+	session := NewSession(1, t.TempDir(), nil, utils.NewDebugLogger(0, "discon-server"))
+	session.shared = []string{shared}
+	defer session.Close()
+	dir, _ := session.Dir()
+	os.WriteFile(filepath.Join(dir, "placed"), []byte("placed"), 0644)
+
+	stat := func(files ...string) (*utils.ControlMessage, error) {
+		payload, err := handleStat(session, &utils.ControlMessage{Type: utils.ControlStat, Files: files}, session.logger)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ParseControlMessage(payload)
+	}
+
+	response, err := stat(filepath.Join(shared, "DISCON.IN"), filepath.Join(shared, "missing"), filepath.Join(dir, "placed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Entries) != 2 || response.Entries[0].Size != 4 || response.Entries[1].Size != 6 {
+		t.Errorf("Unexpected entries %+v", response.Entries)
+	}
+
+	// Paths outside the session and shared directories are rejected
+	for _, file := range rejected {
+		if _, err := stat(file); err == nil {
+			t.Errorf("Expected the lookup of %s to be rejected", file)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	dw "discon-wrapper"
+	"discon-wrapper/shared/utils"
+)
+
+// Locks of the incomplete uploads by content hash. An upload may be shared by
+// sessions sending the same file, uploads of different files proceed in
+// parallel.
+var uploadLocks = &hashLocks{locks: make(map[string]*hashLock)}
+
+type hashLocks struct {
+	mu    sync.Mutex
+	locks map[string]*hashLock
+}
+
+type hashLock struct {
+	sync.Mutex
+	users int // Sessions holding or waiting for the lock
+}
+
+// lock locks the upload of the content with the given hash and returns the
+// function unlocking it
+func (l *hashLocks) lock(hash string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[hash]
+	if !ok {
+		lock = &hashLock{}
+		l.locks[hash] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, hash)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// partialPath returns where the incomplete upload of a file is stored. With a
+// cache it outlives the session so that the upload can resume after a reconnect.
+func partialPath(cache *FileCache, sessionDir, hash string) string {
+	if cache != nil {
+		return cache.PartialPath(hash)
+	}
+	return filepath.Join(sessionDir, ".part-"+hash)
+}
+
+// receiveChunk appends a chunk to the incomplete upload of a file and returns
+// the number of bytes received so far. A chunk is only appended at the end of
+// the upload, chunks before it hold content the server already has.
+func receiveChunk(partial string, offset int64, chunk []byte) (int64, error) {
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if offset != size || len(chunk) == 0 {
+		return size, nil
+	}
+
+	if _, err := file.WriteAt(chunk, offset); err != nil {
+		// Drop a partially written chunk so it's sent again
+		file.Truncate(offset)
+		return offset, err
+	}
+	return offset + int64(len(chunk)), nil
+}
+
+// completeUpload verifies an upload against its hash and places it at target,
+// adding it to the cache unless it is transient
+func completeUpload(cache *FileCache, partial, hash, target string, transient bool) error {
+	actual, err := utils.HashFile(partial)
+	if err != nil {
+		return err
+	}
+	if actual != hash {
+		os.Remove(partial)
+		return fmt.Errorf("content hash %s does not match %s, the upload has to be restarted", actual[:8], hash[:8])
+	}
+
+	if cache != nil && !transient {
+		return cache.Store(hash, partial, target)
+	}
+	return moveFile(partial, target)
+}
+
+// handlePut receives a chunk of a file and, once the file is complete, places
+// it into the session directory and adds it to the cache. The response holds
+// the number of bytes received, and the server path once the file is complete.
+func handlePut(session *Session, msg *utils.ControlMessage, content []byte, logger *utils.DebugLogger) (*dw.Payload, error) {
+	if len(msg.Entries) != 1 {
+		err := fmt.Errorf("put request must have exactly one entry, got %d", len(msg.Entries))
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	entry := msg.Entries[0]
+
+	dir, err := session.Dir()
+	if err != nil {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	target, err := sessionTarget(dir, entry)
+	if err != nil {
+		errMsg := fmt.Sprintf("Security error: %v", err)
+		return utils.CreateControlResponse(msg, false, errMsg), err
+	}
+
+	if entry.Size < 0 || msg.Offset < 0 || msg.Offset+int64(len(content)) > entry.Size || len(content) > utils.MaxChunkSize {
+		err := fmt.Errorf("invalid chunk of %s at offset %d (size: %d bytes)", entry.Path, msg.Offset, len(content))
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+	if msg.ChunkHash != "" && utils.ComputeFileHash(content) != msg.ChunkHash {
+		err := fmt.Errorf("chunk of %s at offset %d does not match its hash", entry.Path, msg.Offset)
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	logger.Verbose("Received chunk of %s at offset %d (size: %d bytes)", entry.Path, msg.Offset, len(content))
+
+	response := &utils.ControlMessage{Type: msg.Type, Offset: entry.Size}
+
+	defer uploadLocks.lock(entry.Hash)()
+
+	// Another session may have completed the same file
+	if session.cache != nil && !msg.Transient {
+		if found, _ := session.cache.Place(entry.Hash, target); found {
+			logger.Debug("Placed cached file %s (hash: %s)", entry.Path, entry.Hash[:8])
+			session.MarkPlaced(target)
+			session.RecordTransforms(entry)
+			session.recordFile("cached", entry)
+			response.Path = filepath.ToSlash(target)
+			return utils.CreateControlResponse(response, true, fmt.Sprintf("File stored: %s", entry.Path)), nil
+		}
+	}
+
+	partial := partialPath(session.cache, dir, entry.Hash)
+	received, err := receiveChunk(partial, msg.Offset, content)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to store chunk of %s: %v", entry.Path, err)
+		return utils.CreateControlResponse(msg, false, errMsg), fmt.Errorf("failed to store chunk: %w", err)
+	}
+	if received > entry.Size {
+		os.Remove(partial)
+		err := fmt.Errorf("upload of %s exceeds its size of %d bytes, the upload has to be restarted", entry.Path, entry.Size)
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	response.Offset = received
+	if received < entry.Size {
+		return utils.CreateControlResponse(response, true, fmt.Sprintf("Received %d of %d bytes", received, entry.Size)), nil
+	}
+
+	if err := completeUpload(session.cache, partial, entry.Hash, target, msg.Transient); err != nil {
+		errMsg := fmt.Sprintf("Failed to store file %s: %v", entry.Path, err)
+		return utils.CreateControlResponse(msg, false, errMsg), fmt.Errorf("failed to store file: %w", err)
+	}
+
+	logger.Debug("Received file %s (size: %d bytes, hash: %s)", entry.Path, entry.Size, entry.Hash[:8])
+	session.MarkPlaced(target)
+	session.RecordTransforms(entry)
+	session.recordFile("upload", entry)
+	response.Path = filepath.ToSlash(target)
+	return utils.CreateControlResponse(response, true, fmt.Sprintf("File stored: %s", entry.Path)), nil
+}
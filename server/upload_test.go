@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashLocks(t *testing.T) {
+	locks := &hashLocks{locks: make(map[string]*hashLock)}
+	unlock := locks.lock("a")
+
+	// Uploads of other content aren't blocked
+	done := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the lock of another hash not to wait")
+	}
+
+	// Uploads of the same content wait for each other
+	locked := make(chan func())
+	go func() { locked <- locks.lock("a") }()
+	select {
+	case <-locked:
+		t.Fatal("Expected the lock of the same hash to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	(<-locked)()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected the unused locks to be removed, got %d", len(locks.locks))
+	}
+}
@@ -0,0 +1,552 @@
+package server
+
+import (
+	"bytes"
+	dw "discon-wrapper"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	// GH-Cp gen: Use the shared utilities package
+	"discon-wrapper/sdk"
+	"discon-wrapper/shared/library"
+	"discon-wrapper/shared/trace"
+	"discon-wrapper/shared/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// Unique identifier for the websocket connection
+var connectionID int32
+
+// Mutex to protect the connectionID variable
+var connectionIDMutex sync.Mutex
+
+// GH-Cp gen: Map of temporary files created for each connection
+var tempFiles = make(map[int32][]string)
+var tempFilesMutex sync.Mutex
+
+// GH-Cp gen: Handle file transfer from client to server - updated to use shared utilities
+func handleFileTransfer(connID int32, payload *dw.Payload, logger *utils.DebugLogger) (*dw.Payload, error) {
+	// Get server file path from payload
+	serverFilePath := utils.ExtractStringFromBytes(payload.ServerFilePath)
+
+	// Validate filename for security
+	err := utils.ValidateFileName(serverFilePath)
+	if err != nil {
+		errMsg := fmt.Sprintf("Security error: %v", err)
+		response := utils.CreateFileTransferResponse(false, errMsg)
+		return response, fmt.Errorf("file validation error: %w", err)
+	}
+
+	// Verify file contents with hash
+	contentHash := utils.ComputeFileHash(payload.FileContent)
+	
+	logger.Debug("Received file transfer request for %s (size: %d bytes, hash: %s)",
+		serverFilePath, len(payload.FileContent), contentHash[:8])
+
+	// Create the file with the server file path
+	file, err := os.Create(serverFilePath)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to create file: %v", err)
+		response := utils.CreateFileTransferResponse(false, errMsg)
+		return response, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	// Write the file contents
+	_, err = file.Write(payload.FileContent)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to write file: %v", err)
+		response := utils.CreateFileTransferResponse(false, errMsg)
+		os.Remove(serverFilePath) // Clean up the partial file
+		return response, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// Register the file to be cleaned up when the connection closes
+	tempFilesMutex.Lock()
+	tempFiles[connID] = append(tempFiles[connID], serverFilePath)
+	tempFilesMutex.Unlock()
+
+	logger.Debug("File %s created successfully", serverFilePath)
+
+	// Create success response
+	successMsg := fmt.Sprintf("File transferred successfully: %s", serverFilePath)
+	return utils.CreateFileTransferResponse(true, successMsg), nil
+}
+
+// handleControlMessage dispatches requests which are not controller calls
+func handleControlMessage(session *Session, payload *dw.Payload, logger *utils.DebugLogger) (*dw.Payload, error) {
+	msg, err := utils.ParseControlMessage(payload)
+	if err != nil {
+		return utils.CreateControlResponse(nil, false, err.Error()), err
+	}
+
+	switch msg.Type {
+	case utils.ControlBundle:
+		return handleBundle(session, msg, payload.FileContent, logger)
+	case utils.ControlHave:
+		return handleHave(session, msg, logger)
+	case utils.ControlPut:
+		return handlePut(session, msg, payload.FileContent, logger)
+	case utils.ControlOutputs:
+		return handleOutputs(session, msg, logger)
+	case utils.ControlGet:
+		return handleGet(session, msg, logger)
+	case utils.ControlStat:
+		return handleStat(session, msg, logger)
+	default:
+		err := fmt.Errorf("unknown control message type: %q", msg.Type)
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+}
+
+// handleStat replies with the entries of the files which exist on the server,
+// so that the client can confirm paths it mapped to a shared drive. Only paths
+// in the session directory and the shared directories may be looked up.
+func handleStat(session *Session, msg *utils.ControlMessage, logger *utils.DebugLogger) (*dw.Payload, error) {
+	response := &utils.ControlMessage{Type: msg.Type}
+	for _, file := range msg.Files {
+		if !session.lookupAllowed(file) {
+			err := fmt.Errorf("server path %s is not in a shared directory of the server", file)
+			return utils.CreateControlResponse(msg, false, err.Error()), err
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			logger.Debug("Mapped file %s not found on server", file)
+			continue
+		}
+		response.Entries = append(response.Entries, utils.FileEntry{Path: file, Size: info.Size()})
+	}
+	return utils.CreateControlResponse(response, true, fmt.Sprintf("%d of %d files found", len(response.Entries), len(msg.Files))), nil
+}
+
+// handleBundle unpacks a tar archive into the session directory, preserving relative paths
+func handleBundle(session *Session, msg *utils.ControlMessage, content []byte, logger *utils.DebugLogger) (*dw.Payload, error) {
+	dir, err := session.Dir()
+	if err != nil {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	// Large bundles are uploaded in chunks as a file in the session directory
+	// first, and are unpacked from it without reading them into memory
+	var archive io.Reader = bytes.NewReader(content)
+	size := int64(len(content))
+	if len(content) == 0 && msg.Path != "" {
+		archivePath, err := utils.BundleTarget(dir, msg.Path)
+		var file *os.File
+		if err == nil {
+			file, err = os.Open(archivePath)
+		}
+		if err != nil {
+			return utils.CreateControlResponse(msg, false, err.Error()), fmt.Errorf("bundle archive error: %w", err)
+		}
+		defer os.Remove(archivePath)
+		defer file.Close()
+		if info, err := file.Stat(); err == nil {
+			size = info.Size()
+		}
+		archive = file
+	}
+
+	logger.Debug("Received bundle (size: %d bytes)", size)
+
+	files, err := utils.ExtractBundle(archive, dir)
+	if err != nil {
+		errMsg := fmt.Sprintf("Security error: %v", err)
+		return utils.CreateControlResponse(msg, false, errMsg), fmt.Errorf("bundle extraction error: %w", err)
+	}
+
+	// The entries list the files which were transformed by the client
+	transforms := make(map[string]string)
+	for _, entry := range msg.Entries {
+		transforms[entry.Path] = entry.Transforms
+	}
+	for _, file := range files {
+		logger.Verbose("Unpacked %s", file)
+		session.MarkPlaced(filepath.Join(dir, filepath.FromSlash(file)))
+		session.recordPlaced("bundle", filepath.Join(dir, filepath.FromSlash(file)), transforms[file])
+		if session.cache != nil {
+			if err := session.cache.AddFile(filepath.Join(dir, filepath.FromSlash(file))); err != nil {
+				logger.Error("Failed to add %s to the file cache: %v", file, err)
+			}
+		}
+	}
+	for _, entry := range msg.Entries {
+		session.RecordTransforms(entry)
+	}
+	logger.Debug("Unpacked %d files into %s", len(files), dir)
+
+	response := &utils.ControlMessage{Type: msg.Type, Path: filepath.ToSlash(dir), Files: files}
+	return utils.CreateControlResponse(response, true, fmt.Sprintf("Bundle unpacked: %d files", len(files))), nil
+}
+
+// handleHave places the listed files which are in the cache into the session
+// directory and replies with the entries the client has to upload
+func handleHave(session *Session, msg *utils.ControlMessage, logger *utils.DebugLogger) (*dw.Payload, error) {
+	dir, err := session.Dir()
+	if err != nil {
+		return utils.CreateControlResponse(msg, false, err.Error()), err
+	}
+
+	response := &utils.ControlMessage{Type: msg.Type, Path: filepath.ToSlash(dir)}
+	for _, entry := range msg.Entries {
+		target, err := sessionTarget(dir, entry)
+		if err != nil {
+			errMsg := fmt.Sprintf("Security error: %v", err)
+			return utils.CreateControlResponse(msg, false, errMsg), err
+		}
+
+		found := false
+		if session.cache != nil {
+			found, err = session.cache.Place(entry.Hash, target)
+			if err != nil {
+				logger.Error("Failed to place cached file %s: %v", entry.Path, err)
+			}
+		}
+		if found {
+			logger.Debug("Placed cached file %s (hash: %s)", entry.Path, entry.Hash[:8])
+			session.MarkPlaced(target)
+			session.RecordTransforms(entry)
+			session.recordFile("cached", entry)
+		} else {
+			response.Entries = append(response.Entries, entry)
+		}
+	}
+
+	logger.Debug("Client has %d files, %d need to be uploaded", len(msg.Entries), len(response.Entries))
+	return utils.CreateControlResponse(response, true, fmt.Sprintf("%d files wanted", len(response.Entries))), nil
+}
+
+// sessionTarget validates a file entry and returns its location in the session directory
+func sessionTarget(dir string, entry utils.FileEntry) (string, error) {
+	if err := validateHash(entry.Hash); err != nil {
+		return "", err
+	}
+	target, err := utils.BundleTarget(dir, entry.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// ServeWs loads the controller requested by a client and answers its calls
+// until the connection is closed
+func (s *Server) ServeWs(w http.ResponseWriter, r *http.Request) {
+	s.wg.Add(1)
+	defer s.wg.Done()
+
+	// Get unique identifier for this connection
+	connectionIDMutex.Lock()
+	connID := connectionID
+	connectionID++
+	if connectionID > 8191 {
+		connectionID = 0
+	}
+	connectionIDMutex.Unlock()
+
+	// GH-Cp gen: Create a connection-specific logger with the connection ID
+	logger := utils.NewConnectionLogger(s.DebugLevel, "discon-server", connID)
+
+	// Read controller path and function name from post parameters
+	params, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, "Error parsing url parameters: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := params.Get("path")
+	proc := params.Get("proc")
+
+	// Reject clients writing payloads of another layout
+	if err := utils.CheckProtocol("discon-client", params.Get(utils.ProtocolParam)); err != nil {
+		logger.Error("Rejected connection from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Debug("Received request to load function '%s' from shared controller '%s'", proc, path)
+
+	// Check if controller exists at path, no controller is loaded to replay a trace
+	alias, isGo := sdk.AliasFromPath(path)
+	if s.Replay == nil && isGo {
+		if _, ok := s.lookupGoController(alias); !ok {
+			http.Error(w, "Go controller '"+alias+"' not registered", http.StatusNotFound)
+			return
+		}
+	} else if s.Replay == nil && !utils.FileExists(path) {
+		http.Error(w, "Controller not found at '"+path+"'", http.StatusInternalServerError)
+		return
+	}
+
+	// Environment variables can only be forwarded to a controller in its own process
+	env, err := utils.ParseEnvHeader(r.Header)
+	if err == nil {
+		err = checkControllerEnv(env)
+	}
+	if err != nil {
+		logger.Error("Rejected connection from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Session state, including the directory bundles are unpacked into
+	session := NewSession(connID, s.SessionDir, s.Cache, logger)
+	session.shared = s.SharedRoots
+	defer session.Close()
+
+	// GH-Cp gen: Initialize tempFiles entry for this connection
+	tempFilesMutex.Lock()
+	tempFiles[connID] = make([]string, 0)
+	tempFilesMutex.Unlock()
+
+	// GH-Cp gen: Clean up any temporary files when connection closes
+	defer func() {
+		tempFilesMutex.Lock()
+		fileList := tempFiles[connID]
+		delete(tempFiles, connID)
+		tempFilesMutex.Unlock()
+
+		// Remove all temporary files for this connection
+		for _, filePath := range fileList {
+			logger.Debug("Cleaning up temporary file: %s", filePath)
+			os.Remove(filePath)
+		}
+	}()
+
+	// Answer the calls from the replay trace, or load the controller
+	var ctrl controller
+	var crashed bool
+	if s.Replay != nil {
+		replay := newReplayController(s.Replay, logger)
+		defer replay.Report()
+		ctrl = replay
+	} else if isGo {
+		factory, _ := s.lookupGoController(alias)
+		ctrl = goController{factory()}
+		logger.Debug("Serving Go controller '%s'", alias)
+	} else {
+		// Create a copy of the shared library with a unique suffix
+		tmpPath, err := utils.CreateTempFile(path, connID)
+		if err != nil {
+			http.Error(w, "Error duplicating controller: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmpPath)
+
+		logger.Debug("Duplicated controller to '%s'", tmpPath)
+
+		// Load the shared library
+		lib, err := library.Load(connID, tmpPath, proc)
+		if errors.Is(err, library.ErrLoadLibrary) {
+			http.Error(w, "Error loading shared library", http.StatusInternalServerError)
+			return
+		} else if err != nil {
+			http.Error(w, "Error loading function from shared library", http.StatusInternalServerError)
+			return
+		}
+		defer lib.Unload()
+		ctrl = &libraryController{lib: lib, crashed: func(report *CrashReport) {
+			report.Session, report.RemoteAddr, report.LibPath, report.LibProc = connID, r.RemoteAddr, path, proc
+			s.reportCrash(report, logger)
+			crashed = true
+		}}
+
+		logger.Debug("Library and function loaded successfully")
+	}
+
+	// Convert connection to a websocket
+	ws, err := upgrader.Upgrade(w, r, utils.ProtocolResponseHeader())
+	if err != nil {
+		log.Println("upgrade:", err)
+		return
+	}
+	defer ws.Close()
+	s.track(ws)
+	defer s.untrack(ws)
+
+	// Log client connection info 
+	logger.Debug("New WebSocket connection established from %s", ws.RemoteAddr().String())
+
+	// Record the controller calls and transferred files if recording is enabled
+	recording := s.Recorder.open(connID, path, proc, ws.RemoteAddr().String(), logger)
+	defer recording.close()
+	if recording != nil {
+		session.onFile = recording.file
+	}
+
+	// Messages are written by the connection and the console, which forwards
+	// the controller's output if the client asked for it
+	var writeMutex sync.Mutex
+	writeMessage := func(b []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return ws.WriteMessage(websocket.BinaryMessage, b)
+	}
+	var sendConsole func(line utils.ConsoleLine)
+	if s.Console != nil && params.Get(utils.ConsoleParam) != "" {
+		sendConsole = func(line utils.ConsoleLine) {
+			msg := &utils.ControlMessage{Type: utils.ControlConsole, Lines: []utils.ConsoleLine{line}}
+			b, err := utils.CreateControlResponse(msg, true, "").MarshalBinary()
+			if err == nil {
+				err = writeMessage(b)
+			}
+			if err != nil {
+				logger.Debug("Failed to forward console output: %v", err)
+			}
+		}
+		logger.Debug("Forwarding the console output of the controller")
+	}
+	var console *consoleSession
+	if s.Console != nil {
+		console = s.Console.session(connID, sendConsole)
+	}
+
+	// Create payload structure
+	payload := dw.Payload{}
+	var calls uint64
+
+	// Loop while receiving messages over socket
+	for {
+		// If not in debug mode, set read deadline to 5 seconds
+		// This will disconnect the client if no message is received in 5 seconds
+		// which allows the controller to be unloaded and deleted
+		if s.DebugLevel == 0 {
+			ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+		}
+
+		// Read message from websocket
+		messageType, b, err := ws.ReadMessage()
+		if err != nil {
+			logger.Debug("WebSocket read error: %v", err)
+			break
+		}
+
+		if messageType != websocket.BinaryMessage {
+			logger.Debug("Received non-binary message type: %d", messageType)
+			continue
+		}
+
+		err = payload.UnmarshalBinary(b)
+		if err != nil {
+			logger.Error("Failed to unmarshal payload: %v", err)
+			break
+		}
+
+		// GH-Cp gen: Log received payload using the logger
+		logger.Verbose("received payload: %v", payload)
+
+		// Handle control messages such as bundle transfers
+		if utils.IsControlMessage(&payload) {
+			response, err := handleControlMessage(session, &payload, logger)
+			if err != nil {
+				logger.Error("handleControlMessage: %v", err)
+			}
+			b, err = response.MarshalBinary()
+			if err != nil {
+				logger.Error("Failed to marshal response: %v", err)
+				break
+			}
+			err = writeMessage(b)
+			if err != nil {
+				logger.Error("Failed to write response: %v", err)
+				break
+			}
+			continue
+		}
+
+		// GH-Cp gen: Check if the payload is a file transfer using shared utility
+		if utils.IsFileTransfer(&payload) {
+			response, err := handleFileTransfer(connID, &payload, logger)
+			if err != nil {
+				logger.Error("handleFileTransfer: %v", err)
+			} else if session.onFile != nil {
+				session.recordFile("upload", utils.FileEntry{
+					Path: utils.ExtractStringFromBytes(payload.ServerFilePath),
+					Size: int64(len(payload.FileContent)),
+					Hash: utils.ComputeFileHash(payload.FileContent),
+				})
+			}
+			b, err = response.MarshalBinary()
+			if err != nil {
+				logger.Error("Failed to marshal response: %v", err)
+				break
+			}
+			err = writeMessage(b)
+			if err != nil {
+				logger.Error("Failed to write response: %v", err)
+				break
+			}
+			continue
+		}
+
+		// Call the function from the shared library with data in payload,
+		// reporting the time spent in the controller to the client
+		record := recording != nil || s.OnCall != nil
+		var traceInput trace.Step
+		if record {
+			traceInput = trace.NewStep(payload.Swap, payload.Fail, payload.InFile, payload.OutName, payload.Msg)
+		}
+		var endConsole func()
+		if console != nil {
+			endConsole = console.begin(callTime(&payload))
+		}
+		callStart := time.Now()
+		ctrl.Call(&payload)
+		payload.CallTime = int64(time.Since(callStart))
+		if endConsole != nil {
+			endConsole()
+		}
+
+		if record {
+			call := trace.Call{
+				Index:          calls,
+				Start:          callStart,
+				Duration:       time.Duration(payload.CallTime),
+				ControllerTime: time.Duration(payload.CallTime),
+				Input:          traceInput,
+				Output:         trace.NewStep(payload.Swap, payload.Fail, payload.InFile, payload.OutName, payload.Msg),
+			}
+			if s.OnCall != nil {
+				s.OnCall(connID, call)
+			}
+			recording.call(call)
+			calls++
+		}
+
+		// Convert payload to binary and send over websocket
+		b, err = payload.MarshalBinary()
+		if err != nil {
+			logger.Error("Failed to marshal payload: %v", err)
+			break
+		}
+		err = writeMessage(b)
+		if err != nil {
+			logger.Error("Failed to write message: %v", err)
+			break
+		}
+
+		// GH-Cp gen: Log sent payload using the logger
+		logger.Verbose("sent payload: %v", payload)
+
+		// The crash may have left the heap of the process corrupted or its
+		// locks taken, so the session ends with the call that reports it
+		if crashed {
+			logger.Debug("Closing the session after the controller crashed")
+			break
+		}
+	}
+
+	logger.Debug("WebSocket connection closed")
+}
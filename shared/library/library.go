@@ -0,0 +1,155 @@
+// Package library loads controller shared libraries and calls their DISCON
+// procedure through the load_shared_library shim
+package library
+
+// #include <stdint.h>
+// #include <stdlib.h>
+// int call_shared_library(int connID, float* avrSWAP, int* aviFAIL, char* accINFILE, char* avcOUTNAME, char* avcMSG, int* signal_code, uintptr_t* signal_addr);
+// int load_shared_library(int connID, const char* library_path, const char* function_name);
+// void unload_shared_library(int connID);
+// void forget_shared_library(int connID);
+// int capture_crashes(void);
+// const char* crash_reason(int sig, int code);
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Number of libraries which can be loaded at the same time, IDs must be below it
+const MaxLoaded = 8192
+
+// Errors returned by Load
+var (
+	ErrLoadLibrary  = errors.New("error loading shared library")
+	ErrLoadFunction = errors.New("error loading function from shared library")
+)
+
+// Library is a loaded controller library
+type Library struct {
+	id    C.int
+	crash *Crash // Crash which ended the calls, nil while the library works
+}
+
+// Crash is a signal raised by a controller during a call, returned by Call
+// if crashes are captured
+type Crash struct {
+	Signal syscall.Signal
+	Code   int     // Signal code, the reason for the signal
+	Addr   uintptr // Faulting address, e.g. the invalid memory access
+}
+
+// Names of the signals a crashing controller raises
+var crashSignals = map[syscall.Signal]string{
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGABRT: "SIGABRT",
+}
+
+// Name returns the name of the signal, e.g. SIGSEGV
+func (c *Crash) Name() string {
+	if name, ok := crashSignals[c.Signal]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(c.Signal))
+}
+
+// Reason describes the signal code, e.g. "address not mapped", or returns an
+// empty string if the code is unknown
+func (c *Crash) Reason() string {
+	return C.GoString(C.crash_reason(C.int(c.Signal), C.int(c.Code)))
+}
+
+func (c *Crash) Error() string {
+	msg := "controller crashed with " + c.Name()
+	if reason := c.Reason(); reason != "" {
+		msg += " (" + reason + ")"
+	}
+	if c.Signal != syscall.SIGABRT {
+		msg += fmt.Sprintf(" at address %#x", c.Addr)
+	}
+	return msg
+}
+
+// CaptureCrashes installs handlers for the signals raised by a crashing
+// controller, so that a crash ends the call rather than the process and Call
+// returns it. Signals raised outside of controller calls are passed on to the
+// handlers installed before, those of the Go runtime. Capturing crashes isn't
+// supported on Windows.
+func CaptureCrashes() error {
+	if runtime.GOOS == "windows" {
+		return errors.New("capturing controller crashes is not supported on Windows")
+	}
+	if C.capture_crashes() != 0 {
+		return errors.New("error installing the signal handlers")
+	}
+	return nil
+}
+
+// Load loads the library at path and looks up the procedure. The ID selects
+// the shim's slot and must not be used by another loaded library.
+func Load(id int32, path, proc string) (*Library, error) {
+	if id < 0 || id >= MaxLoaded {
+		return nil, errors.New("library ID out of range")
+	}
+
+	libraryPath := C.CString(path)
+	defer C.free(unsafe.Pointer(libraryPath))
+	functionName := C.CString(proc)
+	defer C.free(unsafe.Pointer(functionName))
+
+	switch C.load_shared_library(C.int(id), libraryPath, functionName) {
+	case 1:
+		return nil, ErrLoadLibrary
+	case 2:
+		return nil, ErrLoadFunction
+	}
+	return &Library{id: C.int(id)}, nil
+}
+
+// Call calls the procedure, which reads and updates the arguments in place.
+// The strings must be null-terminated buffers of at least one byte. If crashes
+// are captured, a crash is returned as *Crash and later calls return it again
+// without calling the library, whose state is undefined.
+//
+// A crash is contained by jumping out of the signal handler, which doesn't
+// undo what the controller did before: the heap shared with the rest of the
+// process may be corrupted and locks the controller held, e.g. of malloc,
+// stay taken. Callers should stop using the process for controller calls
+// after a crash, only a separate process fully isolates a controller.
+func (l *Library) Call(swap []float32, fail *int32, inFile, outName, msg []byte) error {
+	if l.crash != nil {
+		return l.crash
+	}
+
+	var code C.int
+	var addr C.uintptr_t
+	sig := C.call_shared_library(l.id,
+		(*C.float)(unsafe.Pointer(&swap[0])),
+		(*C.int)(unsafe.Pointer(fail)),
+		(*C.char)(unsafe.Pointer(&inFile[0])),
+		(*C.char)(unsafe.Pointer(&outName[0])),
+		(*C.char)(unsafe.Pointer(&msg[0])),
+		&code, &addr)
+	if sig != 0 {
+		l.crash = &Crash{Signal: syscall.Signal(sig), Code: int(code), Addr: uintptr(addr)}
+		return l.crash
+	}
+	return nil
+}
+
+// Unload unloads the library. A library which crashed stays loaded, as running
+// its finalizers could crash the process.
+func (l *Library) Unload() {
+	if l.crash != nil {
+		C.forget_shared_library(l.id)
+		return
+	}
+	C.unload_shared_library(l.id)
+}
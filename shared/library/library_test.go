@@ -0,0 +1,150 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// Controller which writes to an unmapped address when called with status 0
+// and aborts when called with status -1
+const crashSource = `
+#include <stdlib.h>
+
+void crash(float *avrSWAP, int *aviFAIL, char *accINFILE, char *avcOUTNAME, char *avcMSG)
+{
+    if (avrSWAP[0] < 0)
+    {
+        abort();
+    }
+    *(volatile int *)0x10 = 1;
+}
+`
+
+// buildLibrary compiles C source into a shared library, skipping the test if
+// there is no C compiler
+func buildLibrary(t *testing.T, source string) string {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		t.Skipf("no C compiler to build the controller: %v", err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "controller.c")
+	if err := os.WriteFile(src, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lib := filepath.Join(dir, "libcontroller.so")
+	if out, err := exec.Command(cc, "-shared", "-fPIC", "-o", lib, src).CombinedOutput(); err != nil {
+		t.Fatalf("Building the controller failed: %v\n%s", err, out)
+	}
+	return lib
+}
+
+func TestCaptureCrashes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("capturing crashes is not supported on Windows")
+	}
+	path := buildLibrary(t, crashSource)
+	if err := CaptureCrashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signals raised by Go code are still handled by the Go runtime
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected a nil pointer dereference to panic")
+			}
+		}()
+		var p *int
+		_ = *p
+	}()
+
+	call := func(lib *Library, status float32) error {
+		var fail int32
+		return lib.Call([]float32{status, 0}, &fail, []byte{0}, []byte{0}, make([]byte, 64))
+	}
+
+	// A segmentation fault ends the call, later calls fail without calling the library
+	lib, err := Load(1, path, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Unload()
+	var crash *Crash
+	if err := call(lib, 0); !errors.As(err, &crash) {
+		t.Fatalf("Expected a crash, got %v", err)
+	}
+	if crash.Signal != syscall.SIGSEGV || crash.Addr != 0x10 {
+		t.Errorf("Unexpected crash %+v", crash)
+	}
+	if msg := crash.Error(); msg != "controller crashed with SIGSEGV (address not mapped) at address 0x10" {
+		t.Errorf("Unexpected description %q", msg)
+	}
+	if err := call(lib, 0); err != crash {
+		t.Errorf("Expected later calls to return the crash, got %v", err)
+	}
+
+	// An abort, e.g. of a failed assertion, is contained as well
+	aborting, err := Load(2, path, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aborting.Unload()
+	if err := call(aborting, -1); !errors.As(err, &crash) || crash.Signal != syscall.SIGABRT {
+		t.Fatalf("Expected the controller to abort, got %v", err)
+	}
+	if msg := crash.Error(); msg != "controller crashed with SIGABRT (aborted)" {
+		t.Errorf("Unexpected description %q", msg)
+	}
+}
+
+func TestFlushOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("controllers are built as shared objects")
+	}
+
+	// Controller standing in for one linked against the gfortran runtime,
+	// reporting how often all its units were flushed
+	path := buildLibrary(t, `
+static int flushes = 0;
+
+void _gfortran_flush_i4(int *unit)
+{
+    if (unit == 0)
+    {
+        flushes++;
+    }
+}
+
+void flushes_proc(float *avrSWAP, int *aviFAIL, char *accINFILE, char *avcOUTNAME, char *avcMSG)
+{
+    avrSWAP[0] = flushes;
+}
+`)
+	lib, err := Load(3, path, "flushes_proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	FlushOutput()
+
+	swap := []float32{0}
+	var fail int32
+	if err := lib.Call(swap, &fail, []byte{0}, []byte{0}, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if swap[0] != 1 {
+		t.Errorf("Expected the Fortran units to be flushed once, got %g", swap[0])
+	}
+
+	// Unloaded libraries are not flushed, which would call into unmapped code
+	lib.Unload()
+	FlushOutput()
+}
@@ -0,0 +1,304 @@
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+#ifdef _WIN32
+#include <windows.h>
+#else
+#include <dlfcn.h>
+#include <setjmp.h>
+#include <signal.h>
+#include <unistd.h>
+#endif
+
+#define NUM_HANDLES 8192
+
+typedef void (*discon_func)(float *, int *, char *, char *, char *);
+
+// FLUSH statement of the gfortran runtime, flushing every unit when passed NULL
+typedef void (*fortran_flush_func)(int *);
+
+static void *library_handles[NUM_HANDLES] = {NULL};
+static void *function_handles[NUM_HANDLES] = {NULL};
+static fortran_flush_func fortran_flush_handles[NUM_HANDLES] = {NULL};
+
+#ifndef _WIN32
+// Signals raised by a crashing controller, and the handlers installed before
+// ours, which handle the signals raised outside of controller calls
+static const int crash_signals[] = {SIGSEGV, SIGBUS, SIGFPE, SIGILL, SIGABRT};
+#define NUM_CRASH_SIGNALS (sizeof(crash_signals) / sizeof(crash_signals[0]))
+static struct sigaction previous_actions[NUM_CRASH_SIGNALS];
+static int crash_capture_installed = 0;
+
+// Set while a controller is called on this thread, with the details of the
+// signal once it crashed
+static __thread sigjmp_buf *crash_jump = NULL;
+static __thread int crash_code;
+static __thread uintptr_t crash_addr;
+
+static void crash_handler(int sig, siginfo_t *info, void *context)
+{
+    if (crash_jump != NULL)
+    {
+        crash_code = info->si_code;
+        crash_addr = (uintptr_t)info->si_addr;
+        siglongjmp(*crash_jump, sig);
+    }
+
+    // Not raised by a controller, pass it on, e.g. to the Go runtime
+    for (size_t i = 0; i < NUM_CRASH_SIGNALS; i++)
+    {
+        if (crash_signals[i] != sig)
+        {
+            continue;
+        }
+        struct sigaction *previous = &previous_actions[i];
+        if (previous->sa_flags & SA_SIGINFO)
+        {
+            previous->sa_sigaction(sig, info, context);
+        }
+        else if (previous->sa_handler == SIG_DFL)
+        {
+            sigaction(sig, previous, NULL);
+            raise(sig);
+        }
+        else if (previous->sa_handler != SIG_IGN)
+        {
+            previous->sa_handler(sig);
+        }
+        return;
+    }
+}
+
+// Installs the handler containing crashes of the controllers, returns 0 on success
+int capture_crashes(void)
+{
+    if (crash_capture_installed)
+    {
+        return 0;
+    }
+
+    struct sigaction action = {0};
+    action.sa_sigaction = crash_handler;
+    action.sa_flags = SA_SIGINFO | SA_ONSTACK;
+    sigemptyset(&action.sa_mask);
+    for (size_t i = 0; i < NUM_CRASH_SIGNALS; i++)
+    {
+        if (sigaction(crash_signals[i], &action, &previous_actions[i]) != 0)
+        {
+            return 1;
+        }
+    }
+    crash_capture_installed = 1;
+    return 0;
+}
+
+// Describes the reason for a signal given by its code
+const char *crash_reason(int sig, int code)
+{
+    switch (sig)
+    {
+    case SIGSEGV:
+        switch (code)
+        {
+        case SEGV_MAPERR:
+            return "address not mapped";
+        case SEGV_ACCERR:
+            return "invalid permissions for mapped object";
+        }
+        break;
+    case SIGBUS:
+        switch (code)
+        {
+        case BUS_ADRALN:
+            return "invalid address alignment";
+        case BUS_ADRERR:
+            return "nonexistent physical address";
+        }
+        break;
+    case SIGFPE:
+        switch (code)
+        {
+        case FPE_INTDIV:
+            return "integer divide by zero";
+        case FPE_INTOVF:
+            return "integer overflow";
+        case FPE_FLTDIV:
+            return "floating-point divide by zero";
+        case FPE_FLTOVF:
+            return "floating-point overflow";
+        case FPE_FLTUND:
+            return "floating-point underflow";
+        case FPE_FLTINV:
+            return "invalid floating-point operation";
+        }
+        break;
+    case SIGILL:
+        return "illegal instruction";
+    case SIGABRT:
+        return "aborted";
+    }
+    return "";
+}
+
+// Redirects a standard stream of the process to a pipe. Returns 0 on success
+// with the read end of the pipe and a duplicate of the original descriptor.
+int redirect_stream(int fd, int *pipe_read, int *original)
+{
+    int fds[2];
+    if (pipe(fds) != 0)
+    {
+        return 1;
+    }
+    *original = dup(fd);
+    if (*original < 0 || dup2(fds[1], fd) < 0)
+    {
+        close(fds[0]);
+        close(fds[1]);
+        return 1;
+    }
+    close(fds[1]);
+    *pipe_read = fds[0];
+
+    // Output written with printf reaches the pipe line by line
+    if (fd == STDOUT_FILENO)
+    {
+        setvbuf(stdout, NULL, _IOLBF, 0);
+    }
+    return 0;
+}
+#else
+int capture_crashes(void)
+{
+    return 1;
+}
+
+int redirect_stream(int fd, int *pipe_read, int *original)
+{
+    return 1;
+}
+
+const char *crash_reason(int sig, int code)
+{
+    return "";
+}
+#endif
+
+// Named so that it can't be confused with the DISCON procedure of a controller
+// when the shim is linked into discon-client. Returns the signal which ended the
+// call if the controller crashed and crashes are captured, otherwise 0.
+int call_shared_library(int connID, float *avrSWAP, int *aviFAIL, char *accINFILE, char *avcOUTNAME, char *avcMSG, int *signal_code, uintptr_t *signal_addr)
+{
+    discon_func discon = (discon_func)function_handles[connID];
+
+#ifndef _WIN32
+    if (crash_capture_installed)
+    {
+        sigjmp_buf jump;
+        int sig = sigsetjmp(jump, 1);
+        if (sig != 0)
+        {
+            crash_jump = NULL;
+            *signal_code = crash_code;
+            *signal_addr = crash_addr;
+            return sig;
+        }
+        crash_jump = &jump;
+        discon(avrSWAP, aviFAIL, accINFILE, avcOUTNAME, avcMSG);
+        crash_jump = NULL;
+        return 0;
+    }
+#endif
+
+    discon(avrSWAP, aviFAIL, accINFILE, avcOUTNAME, avcMSG);
+    return 0;
+}
+
+int load_shared_library(int connID, const char *library_path, const char *function_name)
+{
+
+#ifdef _WIN32
+    library_handles[connID] = LoadLibrary(library_path);
+    if (!library_handles[connID])
+    {
+        fprintf(stderr, "Failed to load library: %s\n", library_path);
+        fprintf(stderr, "Error: %lu\n", GetLastError());
+        return 1;
+    }
+
+    function_handles[connID] = GetProcAddress((HMODULE)library_handles[connID], function_name);
+    if (!function_handles[connID])
+    {
+        fprintf(stderr, "Failed to get function: %s\n", function_name);
+        FreeLibrary((HMODULE)library_handles[connID]);
+        return 2;
+    }
+#else
+    library_handles[connID] = dlopen(library_path, RTLD_LAZY);
+    if (!library_handles[connID])
+    {
+        fprintf(stderr, "Failed to load library: %s\nError: %s\n", library_path, dlerror());
+        return 1;
+    }
+
+    function_handles[connID] = dlsym(library_handles[connID], function_name);
+    char *error = dlerror();
+    if (error != NULL)
+    {
+        fprintf(stderr, "Failed to get function: %s\nError: %s\n", function_name, error);
+        dlclose(library_handles[connID]);
+        return 2;
+    }
+
+    // Found in Fortran controllers linked against the gfortran runtime
+    fortran_flush_handles[connID] = (fortran_flush_func)dlsym(library_handles[connID], "_gfortran_flush_i4");
+    dlerror();
+#endif
+
+    return 0;
+}
+
+// Writes the output buffered by the Fortran runtimes of the loaded libraries,
+// which are usually one shared by all of them, and by the C standard library
+void flush_standard_streams(void)
+{
+    fortran_flush_func flushed = NULL;
+    for (int i = 0; i < NUM_HANDLES; i++)
+    {
+        fortran_flush_func flush = fortran_flush_handles[i];
+        if (flush != NULL && flush != flushed)
+        {
+            flush(NULL);
+            flushed = flush;
+        }
+    }
+    fflush(stdout);
+    fflush(stderr);
+}
+
+// Forgets a library without unloading it, as its state is undefined after a crash
+void forget_shared_library(int connID)
+{
+    library_handles[connID] = NULL;
+    function_handles[connID] = NULL;
+    fortran_flush_handles[connID] = NULL;
+}
+
+void unload_shared_library(int connID)
+{
+#ifdef _WIN32
+    if (library_handles[connID])
+    {
+        FreeLibrary((HMODULE)library_handles[connID]);
+        library_handles[connID] = NULL;
+    }
+#else
+    if (library_handles[connID])
+    {
+        dlclose(library_handles[connID]);
+        library_handles[connID] = NULL;
+    }
+#endif
+    function_handles[connID] = NULL;
+    fortran_flush_handles[connID] = NULL;
+}
\ No newline at end of file
@@ -0,0 +1,60 @@
+package library
+
+// int redirect_stream(int fd, int* pipe_read, int* original);
+// void flush_standard_streams(void);
+import "C"
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// Output is a standard stream of the process redirected to a pipe, so that
+// what the controllers print can be captured
+type Output struct {
+	Name     string   // stdout or stderr
+	Pipe     *os.File // Read end of the pipe
+	Stream   *os.File // The redirected stream, written to by the controllers
+	Original *os.File // Where the stream went before, e.g. the terminal
+}
+
+// CaptureOutput redirects the standard output and error of the process to
+// pipes. The C standard output becomes line buffered, and the units gfortran
+// connects to the standard streams of the controllers loaded afterwards are
+// unbuffered. It's not supported on Windows.
+func CaptureOutput() (stdout, stderr *Output, err error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil, errors.New("capturing controller output is not supported on Windows")
+	}
+	if err := os.Setenv("GFORTRAN_UNBUFFERED_PRECONNECTED", "y"); err != nil {
+		return nil, nil, err
+	}
+	if stdout, err = redirectStream(1, "stdout"); err != nil {
+		return nil, nil, err
+	}
+	if stderr, err = redirectStream(2, "stderr"); err != nil {
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+func redirectStream(fd int, name string) (*Output, error) {
+	var pipe, original C.int
+	if C.redirect_stream(C.int(fd), &pipe, &original) != 0 {
+		return nil, errors.New("error redirecting " + name + " to a pipe")
+	}
+	return &Output{
+		Name:     name,
+		Pipe:     os.NewFile(uintptr(pipe), name+" pipe"),
+		Stream:   os.NewFile(uintptr(fd), name),
+		Original: os.NewFile(uintptr(original), name),
+	}, nil
+}
+
+// FlushOutput writes the output buffered by the C standard library of the
+// process and by the Fortran runtime of the loaded libraries, which controller
+// libraries usually print with
+func FlushOutput() {
+	C.flush_standard_streams()
+}
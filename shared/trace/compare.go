@@ -0,0 +1,119 @@
+package trace
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BufferSizeIndices are the 0-based indices of the avrSWAP entries holding the
+// sizes of the string buffers (49, 50 and 64 in the Bladed interface), which
+// describe the caller rather than the state of the controller
+var BufferSizeIndices = []int{48, 49, 63}
+
+// Tolerances are the absolute differences allowed between avrSWAP values
+type Tolerances struct {
+	Default float64
+	Index   map[int]float64 // Tolerances of individual entries by 0-based index
+}
+
+// ParseTolerances parses comma-separated tolerances, e.g. "1e-6,47=0.01,42-44=1e-3,60=inf".
+// An entry without an index sets the default. Indices are 1-based as in the
+// Bladed interface documentation, and an infinite tolerance ignores an entry.
+func ParseTolerances(spec string) (Tolerances, error) {
+	t := Tolerances{Index: make(map[int]float64)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		indices, value, found := strings.Cut(entry, "=")
+		if !found {
+			indices, value = "", entry
+		}
+		tolerance, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || tolerance < 0 {
+			return t, fmt.Errorf("invalid tolerance %q", entry)
+		}
+		if !found {
+			t.Default = tolerance
+			continue
+		}
+
+		first, last, isRange := strings.Cut(indices, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || from < 1 || to < from {
+			return t, fmt.Errorf("invalid avrSWAP index in tolerance %q", entry)
+		}
+		for i := from; i <= to; i++ {
+			t.Index[i-1] = tolerance
+		}
+	}
+	return t, nil
+}
+
+// IgnoreBufferSizes ignores the entries holding the string buffer sizes
+// unless a tolerance was set for them
+func (t Tolerances) IgnoreBufferSizes() {
+	for _, index := range BufferSizeIndices {
+		if _, ok := t.Index[index]; !ok {
+			t.Index[index] = math.Inf(1)
+		}
+	}
+}
+
+// For returns the tolerance of the avrSWAP entry at a 0-based index
+func (t Tolerances) For(index int) float64 {
+	if tolerance, ok := t.Index[index]; ok {
+		return tolerance
+	}
+	return t.Default
+}
+
+// Difference is an avrSWAP entry which differs by more than its tolerance
+type Difference struct {
+	Index     int // 0-based index of the entry
+	Want      float32
+	Got       float32
+	Tolerance float64
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: expected %g, got %g (difference %g, tolerance %g)",
+		SwapLabel(d.Index), d.Want, d.Got, math.Abs(float64(d.Got)-float64(d.Want)), d.Tolerance)
+}
+
+// Compare returns the entries of got which differ from want by more than their
+// tolerance. Entries missing from got are compared as NaN, and NaN only
+// matches NaN.
+func (t Tolerances) Compare(want, got []float32) []Difference {
+	var diffs []Difference
+	for i, w := range want {
+		g := float32(math.NaN())
+		if i < len(got) {
+			g = got[i]
+		}
+		tolerance := t.For(i)
+		if !withinTolerance(w, g, tolerance) {
+			diffs = append(diffs, Difference{Index: i, Want: w, Got: g, Tolerance: tolerance})
+		}
+	}
+	return diffs
+}
+
+func withinTolerance(want, got float32, tolerance float64) bool {
+	if math.IsInf(tolerance, 1) {
+		return true
+	}
+	wantNaN, gotNaN := math.IsNaN(float64(want)), math.IsNaN(float64(got))
+	if wantNaN || gotNaN {
+		return wantNaN && gotNaN
+	}
+	return math.Abs(float64(got)-float64(want)) <= tolerance
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteCSV converts a trace file to CSV with one row per call and named
+// columns for the timing, the strings and every avrSWAP entry before ("in_")
+// and after ("out_") the call. It returns the number of calls converted,
+// which are written even if the trace ends with an incomplete record.
+func WriteCSV(w io.Writer, path string) (int, error) {
+	// The first pass finds the longest avrSWAP so all rows have the same columns
+	swapLen, err := maxSwapLen(path)
+	if err != nil && swapLen == 0 {
+		return 0, err
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	out := csv.NewWriter(w)
+	header := []string{"call", "start", "duration_ms", "controller_ms", "in_fail", "in_infile", "in_outname", "in_msg"}
+	for i := 0; i < swapLen; i++ {
+		header = append(header, "in_"+SwapName(i))
+	}
+	header = append(header, "out_fail", "out_msg")
+	for i := 0; i < swapLen; i++ {
+		header = append(header, "out_"+SwapName(i))
+	}
+	out.Write(header)
+
+	count := 0
+	row := make([]string, 0, len(header))
+	for {
+		call, err := r.Next()
+		if err != nil {
+			out.Flush()
+			if err == io.EOF {
+				err = out.Error()
+			}
+			return count, err
+		}
+
+		row = append(row[:0],
+			strconv.FormatUint(call.Index, 10),
+			call.Start.UTC().Format(time.RFC3339Nano),
+			formatMs(call.Duration),
+			formatMs(call.ControllerTime),
+			strconv.Itoa(int(call.Input.Fail)),
+			call.Input.InFile,
+			call.Input.OutName,
+			call.Input.Msg)
+		row = appendSwap(row, call.Input.Swap, swapLen)
+		row = append(row, strconv.Itoa(int(call.Output.Fail)), call.Output.Msg)
+		row = appendSwap(row, call.Output.Swap, swapLen)
+		if err := out.Write(row); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// maxSwapLen returns the length of the longest avrSWAP in a trace
+func maxSwapLen(path string) (int, error) {
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	swapLen := 0
+	for {
+		call, err := r.Next()
+		if err == io.EOF {
+			return swapLen, nil
+		}
+		if err != nil {
+			return swapLen, err
+		}
+		swapLen = max(swapLen, len(call.Input.Swap), len(call.Output.Swap))
+	}
+}
+
+// appendSwap appends the formatted avrSWAP values, leaving the columns beyond
+// the end of a shorter array empty
+func appendSwap(row []string, swap []float32, n int) []string {
+	for i := 0; i < n; i++ {
+		if i < len(swap) {
+			row = append(row, strconv.FormatFloat(float64(swap[i]), 'g', -1, 32))
+		} else {
+			row = append(row, "")
+		}
+	}
+	return row
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
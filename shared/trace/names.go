@@ -0,0 +1,97 @@
+package trace
+
+import "fmt"
+
+// swapNames are the names of the avrSWAP entries defined by the Bladed
+// interface, by their 1-based index as used in the interface documentation
+var swapNames = map[int]string{
+	1:   "status",
+	2:   "time",
+	3:   "comm_interval",
+	4:   "blade1_pitch",
+	5:   "below_rated_pitch",
+	6:   "min_pitch",
+	7:   "max_pitch",
+	8:   "min_pitch_rate",
+	9:   "max_pitch_rate",
+	10:  "pitch_actuator_type",
+	11:  "pitch_demand",
+	12:  "pitch_rate_demand",
+	13:  "power_demand",
+	14:  "shaft_power",
+	15:  "electrical_power",
+	16:  "optimal_mode_gain",
+	17:  "min_gen_speed",
+	18:  "optimal_mode_max_speed",
+	19:  "above_rated_gen_speed",
+	20:  "gen_speed",
+	21:  "rotor_speed",
+	22:  "above_rated_gen_torque",
+	23:  "gen_torque",
+	24:  "yaw_error",
+	25:  "torque_table_start",
+	26:  "torque_table_points",
+	27:  "hub_wind_speed",
+	28:  "pitch_control_type",
+	29:  "yaw_control_type",
+	30:  "blade1_root_oop_moment",
+	31:  "blade2_root_oop_moment",
+	32:  "blade3_root_oop_moment",
+	33:  "blade2_pitch",
+	34:  "blade3_pitch",
+	35:  "gen_contactor",
+	36:  "shaft_brake",
+	37:  "nacelle_yaw",
+	41:  "yaw_torque_demand",
+	42:  "blade1_pitch_demand",
+	43:  "blade2_pitch_demand",
+	44:  "blade3_pitch_demand",
+	45:  "collective_pitch_demand",
+	46:  "collective_pitch_rate_demand",
+	47:  "gen_torque_demand",
+	48:  "yaw_rate_demand",
+	49:  "msg_size",
+	50:  "infile_size",
+	51:  "outname_size",
+	53:  "tower_fa_accel",
+	54:  "tower_ss_accel",
+	55:  "pitch_override",
+	56:  "torque_override",
+	60:  "rotor_azimuth",
+	61:  "num_blades",
+	62:  "max_log_values",
+	63:  "log_start_record",
+	64:  "max_outname_size",
+	65:  "log_values",
+	69:  "blade1_root_ip_moment",
+	70:  "blade2_root_ip_moment",
+	71:  "blade3_root_ip_moment",
+	73:  "hub_my",
+	74:  "hub_mz",
+	75:  "fixed_hub_my",
+	76:  "fixed_hub_mz",
+	77:  "yaw_bearing_my",
+	78:  "yaw_bearing_mz",
+	81:  "variable_slip_demand",
+	129: "swap_size",
+}
+
+// SwapName returns the name of a column for the avrSWAP entry at a 0-based
+// index, e.g. "2_time" for index 1, or only the 1-based index for entries
+// without a defined meaning
+func SwapName(index int) string {
+	if name, ok := swapNames[index+1]; ok {
+		return fmt.Sprintf("%d_%s", index+1, name)
+	}
+	return fmt.Sprint(index + 1)
+}
+
+// SwapLabel returns a label of the avrSWAP entry at a 0-based index for
+// messages, e.g. "avrSWAP[2] (time)" for index 1, or "avrSWAP[130]" for
+// entries without a defined meaning
+func SwapLabel(index int) string {
+	if name, ok := swapNames[index+1]; ok {
+		return fmt.Sprintf("avrSWAP[%d] (%s)", index+1, name)
+	}
+	return fmt.Sprintf("avrSWAP[%d]", index+1)
+}
@@ -0,0 +1,407 @@
+// Package trace reads and writes traces of DISCON calls. A trace starts with
+// the metadata of the session followed by one record per controller call with
+// the full inputs and outputs of the call and its timing, and one record per
+// file transferred in the session.
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Magic identifies a trace file, it is followed by the format version
+var Magic = [8]byte{'D', 'W', 'T', 'R', 'A', 'C', 'E', 0}
+
+// Version of the trace format written and read by this package
+const Version uint16 = 1
+
+// Kinds of the records of a trace, the first byte of a record
+const (
+	recordCall byte = 'C'
+	recordFile byte = 'F'
+)
+
+// FileExt is the extension of trace files
+const FileExt = ".dtr"
+
+// Limit on the size of the metadata and of a single record, which protects the
+// reader from allocating huge buffers for corrupt files
+const maxRecordSize = 64 << 20
+
+// Metadata describes the session a trace was recorded in
+type Metadata struct {
+	Program           string            `json:"program"`        // Program which wrote the trace, e.g. discon-client
+	Version           string            `json:"version"`        // Version of the program
+	Source            string            `json:"source"`         // Side of the connection the calls were recorded on, client or server
+	Created           time.Time         `json:"created"`        // Time the trace was created
+	Host              string            `json:"host,omitempty"` // Host name of the recording machine
+	PID               int               `json:"pid,omitempty"`  // Process ID of the recording process
+	ServerAddr        string            `json:"server_addr,omitempty"`
+	LibPath           string            `json:"lib_path,omitempty"`
+	LibProc           string            `json:"lib_proc,omitempty"`
+	ControllerID      string            `json:"controller_id,omitempty"`
+	ControllerVersion string            `json:"controller_version,omitempty"`
+	Profile           string            `json:"profile,omitempty"` // Client configuration profile
+	Extra             map[string]string `json:"extra,omitempty"`   // Any other details of the session
+}
+
+// Step holds the DISCON arguments either before or after a call. The strings
+// are stored without the null terminator and padding of the C buffers.
+type Step struct {
+	Swap    []float32
+	Fail    int32
+	InFile  string
+	OutName string
+	Msg     string
+}
+
+// NewStep copies the DISCON arguments, trimming the strings at the first null byte
+func NewStep(swap []float32, fail int32, inFile, outName, msg []byte) Step {
+	return Step{
+		Swap:    append([]float32(nil), swap...),
+		Fail:    fail,
+		InFile:  cString(inFile),
+		OutName: cString(outName),
+		Msg:     cString(msg),
+	}
+}
+
+// SwapValue returns an avrSWAP entry by 0-based index, or zero if the array is
+// too short
+func (s Step) SwapValue(index int) float32 {
+	if index >= 0 && index < len(s.Swap) {
+		return s.Swap[index]
+	}
+	return 0
+}
+
+// cString returns the bytes up to the first null byte as a string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Call is the record of a single controller call
+type Call struct {
+	Index          uint64        // Position of the call in the session, starting at 0
+	Start          time.Time     // Wall-clock time the call started
+	Duration       time.Duration // Duration of the call as seen by the recording side
+	ControllerTime time.Duration // Time spent in the controller, as reported by the server
+	Input          Step          // Arguments passed to DISCON
+	Output         Step          // Arguments returned by DISCON
+}
+
+// File is the record of a file transferred between the client and the server
+type File struct {
+	Time       time.Time `json:"time"`
+	Direction  string    `json:"direction"` // upload, cached, bundle or download
+	Path       string    `json:"path"`      // Path relative to the session directory
+	Size       int64     `json:"size"`
+	Hash       string    `json:"hash,omitempty"`       // SHA-256 hash of the content
+	Transforms string    `json:"transforms,omitempty"` // Conversions the client applied before sending, comma-separated
+}
+
+// Writer appends calls to a trace file
+type Writer struct {
+	file  *os.File
+	buf   *bufio.Writer
+	calls uint64
+	size  int64
+}
+
+// Create creates a trace file, replacing any existing file, and writes the metadata
+func Create(path string, meta Metadata) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{file: file, buf: bufio.NewWriter(file)}
+
+	header, err := json.Marshal(meta)
+	if err == nil {
+		w.buf.Write(Magic[:])
+		binary.Write(w.buf, binary.LittleEndian, Version)
+		binary.Write(w.buf, binary.LittleEndian, uint32(len(header)))
+		w.buf.Write(header)
+		err = w.buf.Flush()
+		w.size = int64(len(Magic) + 2 + 4 + len(header))
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing trace header: %w", err)
+	}
+	return w, nil
+}
+
+// Write appends a call to the trace. The index of the call is assigned by the
+// writer. Each record is flushed so that the trace of a simulation which
+// crashes is complete up to its last call.
+func (w *Writer) Write(call Call) error {
+	call.Index = w.calls
+
+	var record bytes.Buffer
+	record.WriteByte(recordCall)
+	binary.Write(&record, binary.LittleEndian, call.Index)
+	binary.Write(&record, binary.LittleEndian, call.Start.UnixNano())
+	binary.Write(&record, binary.LittleEndian, int64(call.Duration))
+	binary.Write(&record, binary.LittleEndian, int64(call.ControllerTime))
+	writeStep(&record, call.Input)
+	writeStep(&record, call.Output)
+
+	if err := w.writeRecord(record.Bytes()); err != nil {
+		return err
+	}
+	w.calls++
+	return nil
+}
+
+// WriteFile appends the record of a transferred file to the trace
+func (w *Writer) WriteFile(file File) error {
+	content, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return w.writeRecord(append([]byte{recordFile}, content...))
+}
+
+// writeRecord appends a length-prefixed record and flushes it
+func (w *Writer) writeRecord(record []byte) error {
+	binary.Write(w.buf, binary.LittleEndian, uint32(len(record)))
+	w.buf.Write(record)
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("error writing trace record: %w", err)
+	}
+	w.size += int64(4 + len(record))
+	return nil
+}
+
+// Calls returns the number of calls written
+func (w *Writer) Calls() uint64 {
+	return w.calls
+}
+
+// Size returns the number of bytes written
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+// Close flushes and closes the trace file
+func (w *Writer) Close() error {
+	err := w.buf.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeStep(buf *bytes.Buffer, step Step) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(step.Swap)))
+	binary.Write(buf, binary.LittleEndian, step.Swap)
+	binary.Write(buf, binary.LittleEndian, step.Fail)
+	for _, s := range []string{step.InFile, step.OutName, step.Msg} {
+		binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+}
+
+// Reader reads the calls of a trace file in order
+type Reader struct {
+	Metadata Metadata
+	Files    []File // Files transferred before the calls read so far
+
+	file  *os.File
+	buf   *bufio.Reader
+	calls uint64
+}
+
+// Open opens a trace file and reads its metadata
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{file: file, buf: bufio.NewReader(file)}
+	if err := r.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+func (r *Reader) readHeader() error {
+	var magic [len(Magic)]byte
+	if _, err := io.ReadFull(r.buf, magic[:]); err != nil || magic != Magic {
+		return errors.New("not a discon-wrapper trace file")
+	}
+	var version uint16
+	if err := binary.Read(r.buf, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("error reading trace version: %w", err)
+	}
+	if version != Version {
+		return fmt.Errorf("unsupported trace version %d, expected %d", version, Version)
+	}
+
+	header, err := r.readBlock()
+	if err != nil {
+		return fmt.Errorf("error reading trace metadata: %w", err)
+	}
+	if err := json.Unmarshal(header, &r.Metadata); err != nil {
+		return fmt.Errorf("error decoding trace metadata: %w", err)
+	}
+	return nil
+}
+
+// readBlock reads a length-prefixed block
+func (r *Reader) readBlock() ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r.buf, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record size %d exceeds the limit of %d bytes", size, maxRecordSize)
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(r.buf, block); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return block, nil
+}
+
+// Next returns the next call of the trace. It returns io.EOF after the last
+// call, and an error wrapping io.ErrUnexpectedEOF if the trace ends with an
+// incomplete record, e.g. because the recording process was killed. The
+// records of transferred files read on the way are added to Files.
+func (r *Reader) Next() (Call, error) {
+	block, err := r.nextCall()
+	if err == io.EOF {
+		return Call{}, io.EOF
+	}
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("trace truncated after %d calls: %w", r.calls, err)
+		}
+		return Call{}, err
+	}
+
+	call, err := decodeCall(block)
+	if err != nil {
+		return Call{}, fmt.Errorf("error decoding call %d: %w", r.calls, err)
+	}
+	r.calls++
+	return call, nil
+}
+
+// nextCall reads the records up to the next call and returns the call record
+func (r *Reader) nextCall() ([]byte, error) {
+	for {
+		block, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		if len(block) == 0 {
+			return nil, errors.New("empty trace record")
+		}
+		switch block[0] {
+		case recordCall:
+			return block[1:], nil
+		case recordFile:
+			var file File
+			if err := json.Unmarshal(block[1:], &file); err != nil {
+				return nil, fmt.Errorf("error decoding file record: %w", err)
+			}
+			r.Files = append(r.Files, file)
+		default:
+			// Unknown kinds of records are skipped
+		}
+	}
+}
+
+// Close closes the trace file
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+func decodeCall(block []byte) (Call, error) {
+	rd := bytes.NewReader(block)
+	var call Call
+	var start, duration, controllerTime int64
+	for _, v := range []any{&call.Index, &start, &duration, &controllerTime} {
+		if err := binary.Read(rd, binary.LittleEndian, v); err != nil {
+			return Call{}, err
+		}
+	}
+	call.Start = time.Unix(0, start)
+	call.Duration = time.Duration(duration)
+	call.ControllerTime = time.Duration(controllerTime)
+
+	var err error
+	if call.Input, err = readStep(rd); err != nil {
+		return Call{}, err
+	}
+	if call.Output, err = readStep(rd); err != nil {
+		return Call{}, err
+	}
+	return call, nil
+}
+
+func readStep(rd *bytes.Reader) (Step, error) {
+	var step Step
+	var swapLen uint32
+	if err := binary.Read(rd, binary.LittleEndian, &swapLen); err != nil {
+		return step, err
+	}
+	if int64(swapLen)*4 > int64(rd.Len()) {
+		return step, io.ErrUnexpectedEOF
+	}
+	step.Swap = make([]float32, swapLen)
+	if err := binary.Read(rd, binary.LittleEndian, step.Swap); err != nil {
+		return step, err
+	}
+	if err := binary.Read(rd, binary.LittleEndian, &step.Fail); err != nil {
+		return step, err
+	}
+	for _, s := range []*string{&step.InFile, &step.OutName, &step.Msg} {
+		var size uint32
+		if err := binary.Read(rd, binary.LittleEndian, &size); err != nil {
+			return step, err
+		}
+		if int64(size) > int64(rd.Len()) {
+			return step, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, size)
+		rd.Read(b)
+		*s = string(b)
+	}
+	return step, nil
+}
+
+// ReadAll reads the metadata and all calls of a trace file. The calls read
+// before an incomplete record are returned with the error.
+func ReadAll(path string) (Metadata, []Call, error) {
+	r, err := Open(path)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	defer r.Close()
+
+	var calls []Call
+	for {
+		call, err := r.Next()
+		if err == io.EOF {
+			return r.Metadata, calls, nil
+		}
+		if err != nil {
+			return r.Metadata, calls, err
+		}
+		calls = append(calls, call)
+	}
+}
@@ -0,0 +1,185 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.dtr")
+	w, err := Create(path, Metadata{Program: "test", Source: "client", LibProc: "discon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 123456789)
+	calls := []Call{
+		{
+			Start:          start,
+			Duration:       3 * time.Millisecond,
+			ControllerTime: time.Millisecond,
+			Input:          NewStep([]float32{0, 0, 0.01}, 0, []byte("DISCON.IN\x00\x00"), []byte("case\x00"), []byte{0}),
+			Output:         NewStep([]float32{0, 0, 0.01}, 0, []byte("DISCON.IN\x00"), []byte("case\x00"), []byte("ok\x00")),
+		},
+		{
+			Start:  start.Add(10 * time.Millisecond),
+			Input:  NewStep([]float32{1, 0.01, 0.01, 5}, 0, nil, nil, nil),
+			Output: NewStep([]float32{1, 0.01, 0.01, 5}, -1, nil, nil, []byte("failed")),
+		},
+	}
+	file := File{Time: start, Direction: "upload", Path: "DISCON.IN", Size: 10, Hash: "abc", Transforms: "bom,lf"}
+	if err := w.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range calls {
+		if err := w.Write(call); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	if info, _ := os.Stat(path); info.Size() != w.Size() {
+		t.Errorf("Expected a size of %d bytes, got %d", info.Size(), w.Size())
+	}
+
+	meta, read, err := ReadAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Program != "test" || meta.LibProc != "discon" {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+	calls[1].Index = 1
+	for i := range calls {
+		if !read[i].Start.Equal(calls[i].Start) {
+			t.Errorf("Call %d: expected start %v, got %v", i, calls[i].Start, read[i].Start)
+		}
+		read[i].Start = calls[i].Start
+	}
+	if !reflect.DeepEqual(read, calls) {
+		t.Errorf("Expected %+v, got %+v", calls, read)
+	}
+	if read[0].Input.InFile != "DISCON.IN" {
+		t.Errorf("Expected string trimmed at the null byte, got %q", read[0].Input.InFile)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Next()
+	r.Close()
+	if len(r.Files) != 1 || !r.Files[0].Time.Equal(start) || r.Files[0].Hash != file.Hash || r.Files[0].Transforms != file.Transforms {
+		t.Errorf("Expected the file record %+v, got %+v", file, r.Files)
+	}
+
+	// A trace cut off in the middle of a record keeps the complete calls
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-5], 0644)
+	_, read, err = ReadAll(path)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(read) != 1 {
+		t.Errorf("Expected 1 call and an unexpected EOF, got %d calls and %v", len(read), err)
+	}
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.dtr")
+	w, err := Create(path, Metadata{Program: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(Call{Input: NewStep([]float32{0, 0.5}, 0, nil, nil, nil)})
+	w.Close()
+
+	// Only the version written by this package is read
+	data, _ := os.ReadFile(path)
+	binary.LittleEndian.PutUint16(data[len(Magic):], Version+1)
+	os.WriteFile(path, data, 0644)
+
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "unsupported trace version") {
+		t.Errorf("Expected an unsupported version to be rejected, got %v", err)
+	}
+}
+
+func TestStepSwapValue(t *testing.T) {
+	step := NewStep([]float32{1, 0.5}, 0, nil, nil, nil)
+	for index, expected := range map[int]float32{1: 0.5, 2: 0, -1: 0} {
+		if value := step.SwapValue(index); value != expected {
+			t.Errorf("Expected avrSWAP(%d) of %g, got %g", index, expected, value)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.dtr")
+	w, err := Create(path, Metadata{Program: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(Call{Input: NewStep([]float32{0, 0.5}, 0, nil, nil, nil), Output: NewStep([]float32{0, 0.5}, 0, nil, nil, nil)})
+	w.Write(Call{Input: NewStep([]float32{1, 0.75, 0.01}, 0, nil, nil, nil), Output: NewStep([]float32{1, 0.75, 0.01}, 1, nil, nil, nil)})
+	w.Close()
+
+	var buf bytes.Buffer
+	count, err := WriteCSV(&buf, path)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 calls, got %d: %v", count, err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	column := map[string]int{}
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+	for _, name := range []string{"in_1_status", "in_2_time", "in_3_comm_interval", "out_fail", "out_3_comm_interval"} {
+		if _, ok := column[name]; !ok {
+			t.Errorf("Expected column %s in %v", name, rows[0])
+		}
+	}
+	if value := rows[2][column["in_2_time"]]; value != "0.75" {
+		t.Errorf("Expected time 0.75, got %q", value)
+	}
+	if value := rows[1][column["in_3_comm_interval"]]; value != "" {
+		t.Errorf("Expected empty column beyond a shorter avrSWAP, got %q", value)
+	}
+}
+
+func TestTolerances(t *testing.T) {
+	tolerances, err := ParseTolerances("1e-3, 47=0.5, 42-44=inf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tolerances.For(0) != 1e-3 || tolerances.For(46) != 0.5 || !math.IsInf(tolerances.For(42), 1) {
+		t.Errorf("Unexpected tolerances %+v", tolerances)
+	}
+
+	want := make([]float32, 49)
+	got := make([]float32, 48)
+	want[1], got[1] = 0.5, 0.502
+	want[46], got[46] = 100, 100.4
+	want[42], got[42] = 1, 99
+	want[3], got[3] = float32(math.NaN()), float32(math.NaN())
+	got[10] = 0.01
+	diffs := tolerances.Compare(want, got)
+	if len(diffs) != 3 || diffs[0].Index != 1 || diffs[1].Index != 10 || diffs[2].Index != 48 {
+		t.Errorf("Expected entries 2, 11 and the missing 49 to differ, got %v", diffs)
+	}
+
+	for _, spec := range []string{"x", "0=1", "5-3=1", "47=-1"} {
+		if _, err := ParseTolerances(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package utils provides shared utilities for both client and server
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	dw "discon-wrapper"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TLSConfig represents the TLS settings used for wss:// connections
+type TLSConfig struct {
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+}
+
+// ServerURL builds the WebSocket URL of a discon-server or discon-manager.
+// Addresses starting with https:// use wss://, all others ws://, and the
+// parameters are added as the query.
+func ServerURL(serverAddr string, params url.Values) (*url.URL, error) {
+	scheme := "ws"
+	if strings.HasPrefix(strings.ToLower(serverAddr), "http://") {
+		serverAddr = serverAddr[len("http://"):]
+	} else if strings.HasPrefix(strings.ToLower(serverAddr), "https://") {
+		scheme, serverAddr = "wss", serverAddr[len("https://"):]
+	}
+
+	u, err := url.Parse(fmt.Sprintf("%s://%s/ws", scheme, serverAddr))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = params.Encode()
+	return u, nil
+}
+
+// ControllerParams returns the query parameters selecting the controller
+// library, and on a discon-manager the controller ID and version, along with
+// the payload protocol version of the client
+func ControllerParams(libPath, libProc, controllerID, controllerVersion string) url.Values {
+	params := url.Values{"path": {libPath}, "proc": {libProc}, ProtocolParam: {strconv.Itoa(int(dw.ProtocolVersion))}}
+	if controllerID != "" {
+		params.Set("controller", controllerID)
+	}
+	if controllerVersion != "" {
+		params.Set("version", controllerVersion)
+	}
+	return params
+}
+
+// HandshakeRejectionReason returns the message sent by the server when it rejects the WebSocket handshake
+func HandshakeRejectionReason(resp *http.Response) string {
+	if resp.Body == nil {
+		return resp.Status
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(strings.TrimSpace(string(body))) == 0 {
+		return resp.Status
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// ClientConfig creates the TLS configuration of a connection from the settings
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+
+	if t.CAFile != "" {
+		caCert, err := ReadFileContents(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
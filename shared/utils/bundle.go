@@ -0,0 +1,351 @@
+// Package utils provides shared utilities for both client and server
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits on the content of a bundle to protect the server from archive bombs
+const (
+	MaxBundleSize    = 1 << 30 // Maximum total size of the unpacked files in bytes
+	MaxBundleEntries = 10000   // Maximum number of files and directories
+)
+
+// BundleFile is a file of a bundle directory. Its content is read from Path
+// when the archive is written, unless Content is set.
+type BundleFile struct {
+	Name    string // Slash-separated path relative to the bundle root
+	Path    string // Location of the file on disk
+	Content []byte // Content replacing the file on disk, e.g. after a transform
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// CollectBundleFiles lists the files under root whose slash-separated relative
+// paths match any of the include globs (all files if empty) and none of the
+// exclude globs. The content isn't read.
+func CollectBundleFiles(root string, include, exclude []string) ([]BundleFile, error) {
+	var files []BundleFile
+
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || d.IsDir() {
+			return nil
+		}
+
+		// Only regular files are sent, links would resolve differently on the server
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if len(include) > 0 && !MatchAnyGlob(include, rel) {
+			return nil
+		}
+		if MatchAnyGlob(exclude, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, BundleFile{Name: rel, Path: filePath, Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle directory %s: %w", root, err)
+	}
+
+	return files, nil
+}
+
+// WriteBundle writes a tar archive holding the files to w, streaming the
+// content of each file from disk
+func WriteBundle(w io.Writer, files []BundleFile) error {
+	tw := tar.NewWriter(w)
+
+	for _, file := range files {
+		mode := file.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		size := file.Size
+		if file.Content != nil || file.Path == "" {
+			size = int64(len(file.Content))
+		}
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.Name,
+			Mode:     int64(mode),
+			Size:     size,
+			ModTime:  file.ModTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := writeBundleContent(tw, file, size); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeBundleContent copies the content of a file into the archive
+func writeBundleContent(w io.Writer, file BundleFile, size int64) error {
+	if file.Content != nil || file.Path == "" {
+		_, err := w.Write(file.Content)
+		return err
+	}
+
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The size in the header was taken when the files were collected
+	if _, err := io.CopyN(w, f, size); err != nil {
+		return fmt.Errorf("error reading %s, was it modified while the bundle was created? %w", file.Path, err)
+	}
+	return nil
+}
+
+// CreateBundleFromContent creates a tar archive holding a single file
+func CreateBundleFromContent(rel string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteBundle(&buf, []BundleFile{{Name: rel, Content: content}}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateBundlePath checks that a bundle entry name is a relative path
+// which stays inside the directory the bundle is unpacked into
+func ValidateBundlePath(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty entry name")
+	}
+	if strings.ContainsAny(name, "\x00\\:") {
+		return fmt.Errorf("entry %q contains invalid characters", name)
+	}
+	if path.IsAbs(name) {
+		return fmt.Errorf("entry %q is an absolute path", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return fmt.Errorf("entry %q escapes the bundle directory", name)
+		}
+	}
+	return nil
+}
+
+// ExtractBundle unpacks a tar archive read from r into destDir after
+// validating every entry. Only regular files and directories are allowed, and
+// no entry may be written through a symbolic link. It returns the relative
+// paths of the files.
+func ExtractBundle(r io.Reader, destDir string) ([]string, error) {
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	var files []string
+	var totalSize int64
+	entries := 0
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("error reading bundle: %w", err)
+		}
+
+		entries++
+		if entries > MaxBundleEntries {
+			return files, fmt.Errorf("bundle has more than %d entries", MaxBundleEntries)
+		}
+
+		name := strings.TrimSuffix(path.Clean(header.Name), "/")
+		if name == "." {
+			continue
+		}
+		target, err := BundleTarget(root, name)
+		if err != nil {
+			return files, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+
+		case tar.TypeReg:
+			totalSize += header.Size
+			if header.Size < 0 || totalSize > MaxBundleSize {
+				return files, fmt.Errorf("bundle exceeds the maximum size of %d bytes", MaxBundleSize)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			// Directories created above may not be symlinks, but check again
+			// in case the parent existed before
+			if err := checkNoSymlinks(root, target); err != nil {
+				return files, err
+			}
+			if err := writeBundleFile(target, tr, header.Size); err != nil {
+				return files, err
+			}
+			files = append(files, name)
+
+		default:
+			return files, fmt.Errorf("entry %q has unsupported type %q, only files and directories are allowed", header.Name, header.Typeflag)
+		}
+	}
+
+	return files, nil
+}
+
+// BundleTarget validates a slash-separated relative path and returns the
+// location it refers to inside the absolute directory root
+func BundleTarget(root, name string) (string, error) {
+	if err := ValidateBundlePath(name); err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if !IsWithin(root, target) || target == root {
+		return "", fmt.Errorf("entry %q escapes the bundle directory", name)
+	}
+	if err := checkNoSymlinks(root, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// writeBundleFile replaces target with size bytes read from r
+func writeBundleFile(target string, r io.Reader, size int64) error {
+	// Remove an existing file rather than writing through it
+	if info, err := os.Lstat(target); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("cannot replace directory %s with a file", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.CopyN(file, r, size)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", target, err)
+	}
+	if n != size {
+		return fmt.Errorf("short write to %s", target)
+	}
+	return nil
+}
+
+// IsWithin reports whether target is root or a path inside root
+func IsWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// checkNoSymlinks returns an error if any existing path component between root and target is a symbolic link
+func checkNoSymlinks(root, target string) error {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("bundle path %s is a symbolic link", current)
+		}
+	}
+	return nil
+}
+
+// MatchAnyGlob reports whether the slash-separated path matches any of the globs
+func MatchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob matches a slash-separated path against a glob, where "**" matches
+// any number of directories. Patterns without a slash match the file name.
+func MatchGlob(pattern, name string) bool {
+	pattern = filepath.ToSlash(pattern)
+	name = filepath.ToSlash(name)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern at every remaining position
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractBundleRejectsEscapes(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "sub/../../evil.txt", "/tmp/evil.txt", `..\evil.txt`, "C:/evil.txt"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 1})
+		tw.Write([]byte("x"))
+		tw.Close()
+
+		if _, err := ExtractBundle(&buf, t.TempDir()); err == nil {
+			t.Errorf("Expected entry %q to be rejected", name)
+		}
+	}
+
+	// Files may not be written through a symbolic link in the destination
+	dest := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(dest, "link")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	archive, err := CreateBundleFromContent("link/evil.txt", []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractBundle(bytes.NewReader(archive), dest); err == nil {
+		t.Error("Expected entry written through a symbolic link to be rejected")
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "tables", "out"), 0755)
+	os.WriteFile(filepath.Join(root, "DISCON.IN"), []byte("gain 1"), 0644)
+	os.WriteFile(filepath.Join(root, "tables", "Cp.txt"), []byte("0.48"), 0644)
+	os.WriteFile(filepath.Join(root, "tables", "out", "log.txt"), []byte("log"), 0644)
+
+	files, err := CollectBundleFiles(root, nil, []string{"tables/out/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Content != nil || files[1].Size != 4 {
+		t.Fatalf("Unexpected files %+v", files)
+	}
+
+	// Content replacing the file on disk is written instead of it
+	files[0].Content = []byte("gain 2")
+
+	// The archive is streamed from the files into the extraction
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(WriteBundle(pw, files)) }()
+	dest := t.TempDir()
+	names, err := ExtractBundle(pr, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Unexpected files %v", names)
+	}
+	for name, want := range map[string]string{"DISCON.IN": "gain 2", "tables/Cp.txt": "0.48"} {
+		if content, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(content) != want {
+			t.Errorf("Expected %s to hold %q, got %q", name, want, content)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.txt", "tables/Cp.txt", true},
+		{"tables/*.txt", "tables/Cp.txt", true},
+		{"tables/*.txt", "tables/sub/Cp.txt", false},
+		{"tables/**", "tables/sub/Cp.txt", true},
+		{"**/Cp.txt", "Cp.txt", true},
+		{"out/**", "tables/Cp.txt", false},
+	}
+	for _, test := range tests {
+		if got := MatchGlob(test.pattern, test.name); got != test.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package utils provides shared utilities for both client and server
+package utils
+
+import (
+	dw "discon-wrapper"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Control message types
+const (
+	ControlBundle  = "bundle"  // Unpack a tar archive into the session directory
+	ControlHave    = "have"    // Place cached files by hash, the server replies with the ones it lacks
+	ControlPut     = "put"     // Upload a chunk of a file the server lacked, which is added to its cache
+	ControlOutputs = "outputs" // List the files created or modified in the session directory
+	ControlGet     = "get"     // Download a chunk of a file in the session directory
+	ControlStat    = "stat"    // Check which files exist at server paths, e.g. on a shared drive
+	ControlConsole = "console" // Output the controller printed during a call, sent by the server before the response
+)
+
+// Query parameter a client sets to receive the console output of its controller
+const ConsoleParam = "console"
+
+// Query parameter of the client and response header of the server announcing
+// their payload protocol version in the WebSocket handshake
+const (
+	ProtocolParam  = "protocol"
+	ProtocolHeader = "X-Discon-Protocol"
+)
+
+// CheckProtocol returns an error unless the protocol version announced by the
+// peer, e.g. "discon-client", is the version of this release
+func CheckProtocol(peer, version string) error {
+	if version == strconv.Itoa(int(dw.ProtocolVersion)) {
+		return nil
+	}
+	if version == "" {
+		version = "unknown (an older release)"
+	}
+	return fmt.Errorf("%s uses payload protocol version %s but version %d is required, use the same release of discon-client and discon-server",
+		peer, version, dw.ProtocolVersion)
+}
+
+// ProtocolResponseHeader returns the header a server answers the WebSocket
+// handshake with
+func ProtocolResponseHeader() http.Header {
+	return http.Header{ProtocolHeader: {strconv.Itoa(int(dw.ProtocolVersion))}}
+}
+
+// Maximum size of a single chunk of a transferred file
+const MaxChunkSize = 64 * 1024 * 1024
+
+// ControlMessage describes a request which is not a controller call, or the
+// server's response to it. It is sent JSON-encoded in the Payload Control field.
+type ControlMessage struct {
+	Type  string   `json:"type"`
+	Path  string   `json:"path,omitempty"`  // Server path of the file or directory
+	Files []string `json:"files,omitempty"` // Files affected by the request
+
+	Entries []FileEntry `json:"entries,omitempty"` // Files identified by their content hash
+
+	// Chunked transfers, for uploads the server replies with the number of bytes it holds
+	Offset    int64  `json:"offset,omitempty"`     // Offset of the chunk in the file
+	ChunkHash string `json:"chunk_hash,omitempty"` // SHA-256 hash of the chunk
+	Transient bool   `json:"transient,omitempty"`  // Don't add the file to the cache
+	Length    int64  `json:"length,omitempty"`     // Maximum size of a downloaded chunk
+
+	Lines []ConsoleLine `json:"lines,omitempty"` // Console output of the controller
+}
+
+// ConsoleLine is a line the controller printed to its standard output or error
+type ConsoleLine struct {
+	Stream string  `json:"stream"` // stdout or stderr
+	Time   float32 `json:"time"`   // Simulation time of the call, avrSWAP(2)
+	Text   string  `json:"text"`
+}
+
+// FileEntry identifies a file by the SHA-256 hash of its content and the
+// path it is placed at, relative to the session directory
+type FileEntry struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+
+	// Conversions the client applied before hashing, e.g. "strip-bom,crlf-to-lf"
+	Transforms string `json:"transforms,omitempty"`
+}
+
+// NewFileEntry creates the entry for a file placed at the relative path
+func NewFileEntry(path string, content []byte) FileEntry {
+	return FileEntry{Path: path, Hash: ComputeFileHash(content), Size: int64(len(content))}
+}
+
+// IsControlMessage checks if a payload carries a control message
+func IsControlMessage(payload *dw.Payload) bool {
+	return len(payload.Control) > 0
+}
+
+// CreateControlPayload creates a payload carrying a control message and optional file content
+func CreateControlPayload(msg *ControlMessage, content []byte) (*dw.Payload, error) {
+	control, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dw.Payload{
+		// Initialize required fields with empty values
+		Swap:        make([]float32, 1),
+		Fail:        0,
+		InFile:      []byte{0},
+		OutName:     []byte{0},
+		Msg:         []byte{0},
+		FileContent: content,
+		Control:     control,
+	}, nil
+}
+
+// CreateControlResponse creates a response payload for a control message
+func CreateControlResponse(msg *ControlMessage, success bool, message string) *dw.Payload {
+	response := CreateFileTransferResponse(success, message)
+	if msg != nil {
+		response.Control, _ = json.Marshal(msg)
+	}
+	return response
+}
+
+// ParseControlMessage decodes the control message carried by a payload
+func ParseControlMessage(payload *dw.Payload) (*ControlMessage, error) {
+	msg := &ControlMessage{}
+	if err := json.Unmarshal(payload.Control, msg); err != nil {
+		return nil, fmt.Errorf("invalid control message: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,43 @@
+// Package utils provides shared utilities for both client and server
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Handshake header the client forwards environment variables for the
+// controller in, with one NAME=value pair per header value
+const EnvHeader = "X-Discon-Env"
+
+// Variables which are never forwarded, they change how libraries are loaded
+// or are set by the discon-manager for the container
+var blockedEnvPatterns = []string{"LD_*", "DYLD_*", "PATH", "DEBUG_LEVEL"}
+
+// ForwardableEnv reports whether a variable may be forwarded to the controller
+func ForwardableEnv(name string) bool {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return false
+	}
+	for _, pattern := range blockedEnvPatterns {
+		if ok, _ := filepath.Match(pattern, strings.ToUpper(name)); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseEnvHeader returns the NAME=value pairs forwarded in the handshake
+func ParseEnvHeader(header http.Header) ([]string, error) {
+	var env []string
+	for _, pair := range header.Values(EnvHeader) {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || !ForwardableEnv(name) || strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("environment variable %q can't be forwarded to the controller", name)
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
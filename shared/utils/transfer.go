@@ -6,6 +6,8 @@ import (
 	dw "discon-wrapper"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 )
 
@@ -76,6 +78,21 @@ func ComputeFileHash(content []byte) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
+// HashFile computes the SHA-256 hash of a file without reading it into memory
+func HashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // GH-Cp gen: GetErrorMessageFromPayload extracts an error message from a payload
 func GetErrorMessageFromPayload(payload *dw.Payload) string {
 	if payload == nil {